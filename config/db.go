@@ -2,57 +2,91 @@ package config
 
 import (
 	"fmt"
+	"io/fs"
 	"log"
-	"os"
+	"time"
 
+	"book_order_app/migrations"
 	"book_order_app/models"
 
 	"github.com/golang-migrate/migrate/v4"
 	"github.com/golang-migrate/migrate/v4/database/postgres"
-	_ "github.com/golang-migrate/migrate/v4/source/file"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
 	postgresDriver "gorm.io/driver/postgres"
 	"gorm.io/gorm"
 )
 
+// devSQLMigrations hold schema changes AutoMigrate cannot express (extensions,
+// generated columns, GIN indexes, data conversions). They are written
+// idempotently so the dev environment can replay them after every AutoMigrate.
+// Names are relative to migrations.FS.
+var devSQLMigrations = []string{
+	"000004_add_books_search.up.sql",
+	"000005_create_order_items_table.up.sql",
+	"000008_add_order_user.up.sql",
+	"000010_create_role_changes_table.up.sql",
+}
+
+// DBHandler holds the connection pool shared by every service
 type DBHandler struct {
 	DB *gorm.DB
 }
 
-func InitializeDBHandler() *DBHandler {
-	db, err := InitPostgresDB()
+// NewDBHandler opens the database described by cfg, tunes its pool and brings the schema up to date
+func NewDBHandler(cfg *Config) (*DBHandler, error) {
+	db, err := InitPostgresDB(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &DBHandler{DB: db}, nil
+}
+
+// Close releases every connection in the pool
+func (h *DBHandler) Close() error {
+	sqlDB, err := h.DB.DB()
 	if err != nil {
-		log.Fatalf("Failed to connect to database: %v", err)
+		return err
 	}
-	return &DBHandler{DB: db}
+	return sqlDB.Close()
 }
 
 // InitPostgresDB initializes a PostgreSQL database connection using GORM
 // For dev environment: uses AutoMigrate
 // For non-dev environment: uses golang-migrate
-func InitPostgresDB() (*gorm.DB, error) {
-	dsn := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
-		HOSTNAME, PORT, USERNAME, PASSWORD, DBNAME)
-
-	db, err := gorm.Open(postgresDriver.Open(dsn), &gorm.Config{})
+func InitPostgresDB(cfg *Config) (*gorm.DB, error) {
+	db, err := gorm.Open(postgresDriver.Open(cfg.Database.DSN()), &gorm.Config{
+		// Surface constraint violations as gorm.ErrDuplicatedKey / gorm.ErrForeignKeyViolated
+		TranslateError: true,
+	})
 	if err != nil {
 		return nil, fmt.Errorf("error opening database: %w", err)
 	}
 
-	log.Println("Successfully connected to PostgreSQL database")
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, fmt.Errorf("error getting sql.DB from gorm: %w", err)
+	}
+	pool := cfg.Database.Pool
+	sqlDB.SetMaxOpenConns(pool.MaxOpenConns)
+	sqlDB.SetMaxIdleConns(pool.MaxIdleConns)
+	sqlDB.SetConnMaxLifetime(time.Duration(pool.ConnMaxLifetime))
+	sqlDB.SetConnMaxIdleTime(time.Duration(pool.ConnMaxIdleTime))
 
-	// Get environment
-	env := os.Getenv("APP_ENV")
+	log.Println("Successfully connected to PostgreSQL database")
 
 	// Run auto migration for development environment
-	if env == "" || env == "development" || env == "dev" {
-		if err := db.AutoMigrate(&models.Book{}, &models.Order{}, &models.User{}); err != nil {
+	if cfg.IsDevelopment() {
+		if err := db.AutoMigrate(&models.Book{}, &models.Order{}, &models.OrderItem{}, &models.OrderStatusChange{}, &models.StockMovement{}, &models.User{}, &models.RoleChange{}, &models.RefreshToken{}, &models.RevokedToken{}, &models.OneTimeToken{}, &models.RecoveryCode{}, &models.LoginThrottle{}, &models.APIKey{}, &models.Role{}); err != nil {
 			return nil, fmt.Errorf("error running auto migration: %w", err)
 		}
+		if err := applySQLFiles(db, devSQLMigrations); err != nil {
+			return nil, fmt.Errorf("error applying SQL migrations: %w", err)
+		}
 		log.Println("Database auto migration completed successfully (dev environment)")
 	} else {
 		// Use golang-migrate for non-dev environments
-		log.Printf("Running migrations using golang-migrate (environment: %s)", env)
-		if err := runMigrations(db, DBNAME); err != nil {
+		log.Printf("Running migrations using golang-migrate (environment: %s)", cfg.Env)
+		if err := runMigrations(db, cfg.Database.DatabaseName()); err != nil {
 			return nil, fmt.Errorf("error running migrations: %w", err)
 		}
 		log.Println("Database migrations completed successfully")
@@ -73,8 +107,14 @@ func runMigrations(gormDB *gorm.DB, dbname string) error {
 		return fmt.Errorf("error creating migrate driver: %w", err)
 	}
 
-	m, err := migrate.NewWithDatabaseInstance(
-		"file://migrations",
+	source, err := iofs.New(migrations.FS, ".")
+	if err != nil {
+		return fmt.Errorf("error opening embedded migrations: %w", err)
+	}
+
+	m, err := migrate.NewWithInstance(
+		"iofs",
+		source,
 		dbname,
 		driver,
 	)
@@ -88,3 +128,17 @@ func runMigrations(gormDB *gorm.DB, dbname string) error {
 
 	return nil
 }
+
+// applySQLFiles executes each embedded migration file as-is against the database
+func applySQLFiles(db *gorm.DB, paths []string) error {
+	for _, path := range paths {
+		statements, err := fs.ReadFile(migrations.FS, path)
+		if err != nil {
+			return fmt.Errorf("error reading %s: %w", path, err)
+		}
+		if err := db.Exec(string(statements)).Error; err != nil {
+			return fmt.Errorf("error executing %s: %w", path, err)
+		}
+	}
+	return nil
+}
@@ -1,9 +0,0 @@
-package config
-
-const (
-	HOSTNAME = "localhost"
-	PORT     = "5432"
-	USERNAME = "postgres"
-	PASSWORD = "postgres"
-	DBNAME   = "book_order_db"
-)
@@ -0,0 +1,93 @@
+package controllers
+
+import (
+	"net/http"
+
+	"book_order_app/models"
+	"book_order_app/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type APIKeyController struct {
+	service services.APIKeyService
+}
+
+func InitializeAPIKeyController(apiKeyService services.APIKeyService) *APIKeyController {
+	return &APIKeyController{service: apiKeyService}
+}
+
+// CreateAPIKey godoc
+// @Summary Create an API key
+// @Description Create a personal API key for scripts, sent in the X-API-Key header instead of a bearer token. The key is returned only in this response. Scopes limit it to reading (resource:read) or changing (resource:write) books, orders or users; without scopes it may do whatever the user may. Keys cannot manage the account's credentials, including API keys.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param key body models.CreateAPIKeyRequest true "Name, scopes and expiry"
+// @Security BearerAuth
+// @Success 201 {object} models.CreatedAPIKeyResponse
+// @Failure 400 {object} controllers.Problem
+// @Failure 401 {object} controllers.Problem
+// @Failure 403 {object} controllers.Problem
+// @Failure 500 {object} controllers.Problem
+// @Router /users/me/api-keys [post]
+func (kc *APIKeyController) CreateAPIKey(c *gin.Context) {
+	var req models.CreateAPIKeyRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+
+	created, err := kc.service.Create(c.Request.Context(), c.GetUint("user_id"), c.GetBool("mfa"), req)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusCreated, created)
+}
+
+// ListAPIKeys godoc
+// @Summary List your API keys
+// @Description List the authenticated user's API keys with their prefix, scopes, expiry and last use. The keys themselves are never shown again.
+// @Tags users
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {array} models.APIKey
+// @Failure 401 {object} controllers.Problem
+// @Failure 403 {object} controllers.Problem
+// @Failure 500 {object} controllers.Problem
+// @Router /users/me/api-keys [get]
+func (kc *APIKeyController) ListAPIKeys(c *gin.Context) {
+	keys, err := kc.service.List(c.Request.Context(), c.GetUint("user_id"))
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, keys)
+}
+
+// RevokeAPIKey godoc
+// @Summary Revoke an API key
+// @Description Delete one of the authenticated user's API keys; it stops working immediately
+// @Tags users
+// @Produce json
+// @Param keyId path int true "API key ID"
+// @Security BearerAuth
+// @Success 204
+// @Failure 400 {object} controllers.Problem
+// @Failure 401 {object} controllers.Problem
+// @Failure 403 {object} controllers.Problem
+// @Failure 404 {object} controllers.Problem
+// @Failure 500 {object} controllers.Problem
+// @Router /users/me/api-keys/{keyId} [delete]
+func (kc *APIKeyController) RevokeAPIKey(c *gin.Context) {
+	id, ok := parseIDParam(c, "keyId")
+	if !ok {
+		return
+	}
+
+	if err := kc.service.Revoke(c.Request.Context(), c.GetUint("user_id"), id); err != nil {
+		c.Error(err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
@@ -1,9 +1,11 @@
 package controllers
 
 import (
+	"errors"
 	"net/http"
 
 	"book_order_app/models"
+	"book_order_app/query"
 	"book_order_app/services"
 
 	"github.com/gin-gonic/gin"
@@ -13,21 +15,76 @@ type BookController struct {
 	service services.BookService
 }
 
-func InitializeBookController() *BookController {
-	bookService := services.NewBookService()
+func InitializeBookController(bookService services.BookService) *BookController {
 	return &BookController{service: bookService}
 }
 
 // GetBooks godoc
 // @Summary Get all books
-// @Description Get a list of all books
+// @Description Get a paginated list of books. Supports offset or cursor pagination, sorting and filtering; navigation links are also returned in the Link header.
 // @Tags books
 // @Accept json
 // @Produce json
-// @Success 200 {array} models.Book
+// @Param limit query int false "Page size (default 20, max 100)"
+// @Param offset query int false "Number of records to skip (offset pagination)"
+// @Param cursor query string false "Opaque cursor from a previous next_cursor (cursor pagination)"
+// @Param sort query string false "Comma-separated sort fields, prefix with - for descending (id, title, author, price, created_at, updated_at)" default(-created_at)
+// @Param title query string false "Title contains (case-insensitive)"
+// @Param author query string false "Author contains (case-insensitive)"
+// @Param min_price query number false "Minimum price"
+// @Param max_price query number false "Maximum price"
+// @Param created_after query string false "Created after (RFC 3339 or YYYY-MM-DD)"
+// @Param created_before query string false "Created before (RFC 3339 or YYYY-MM-DD)"
+// @Success 200 {object} query.Page[models.Book]
+// @Header 200 {string} Link "RFC 8288 next, prev and first page links"
+// @Failure 400 {object} controllers.Problem
+// @Failure 500 {object} controllers.Problem
 // @Router /books [get]
 func (bc *BookController) GetBooks(c *gin.Context) {
-	c.JSON(http.StatusOK, bc.service.GetAll())
+	bc.listBooks(c, false)
+}
+
+// ListBooksAdmin godoc
+// @Summary List books including trashed ones
+// @Description Admin listing of books; pass include_deleted=true to also return soft-deleted books
+// @Tags admin
+// @Produce json
+// @Param include_deleted query bool false "Include soft-deleted books"
+// @Param limit query int false "Page size (default 20, max 100)"
+// @Param offset query int false "Number of records to skip (offset pagination)"
+// @Param cursor query string false "Opaque cursor from a previous next_cursor (cursor pagination)"
+// @Param sort query string false "Comma-separated sort fields, prefix with - for descending (id, title, author, price, created_at, updated_at)" default(-created_at)
+// @Param title query string false "Title contains (case-insensitive)"
+// @Param author query string false "Author contains (case-insensitive)"
+// @Param min_price query number false "Minimum price"
+// @Param max_price query number false "Maximum price"
+// @Param created_after query string false "Created after (RFC 3339 or YYYY-MM-DD)"
+// @Param created_before query string false "Created before (RFC 3339 or YYYY-MM-DD)"
+// @Security BearerAuth
+// @Success 200 {object} query.Page[models.Book]
+// @Header 200 {string} Link "RFC 8288 next, prev and first page links"
+// @Failure 400 {object} controllers.Problem
+// @Failure 401 {object} controllers.Problem
+// @Failure 403 {object} controllers.Problem
+// @Failure 500 {object} controllers.Problem
+// @Router /admin/books [get]
+func (bc *BookController) ListBooksAdmin(c *gin.Context) {
+	bc.listBooks(c, includeDeleted(c))
+}
+
+func (bc *BookController) listBooks(c *gin.Context, withDeleted bool) {
+	params, err := query.Parse(c.Request.URL.Query(), services.BookQuerySpec)
+	if err != nil {
+		c.Error(services.Invalid(err))
+		return
+	}
+
+	page, err := bc.service.GetAll(c.Request.Context(), params, withDeleted)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	writePage(c, params, page)
 }
 
 // AddBook godoc
@@ -39,12 +96,12 @@ func (bc *BookController) GetBooks(c *gin.Context) {
 // @Param book body models.CreateBookRequest true "Book information"
 // @Security BearerAuth
 // @Success 201 {object} models.Book
-// @Failure 400 {object} map[string]string
+// @Failure 400 {object} controllers.Problem
+// @Failure 500 {object} controllers.Problem
 // @Router /books [post]
 func (bc *BookController) AddBook(c *gin.Context) {
 	var req models.CreateBookRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if !bindJSON(c, &req) {
 		return
 	}
 
@@ -55,10 +112,45 @@ func (bc *BookController) AddBook(c *gin.Context) {
 		Price:  req.Price,
 	}
 
-	created := bc.service.Create(book)
+	created, err := bc.service.Create(c.Request.Context(), book)
+	if err != nil {
+		c.Error(err)
+		return
+	}
 	c.JSON(http.StatusCreated, created)
 }
 
+// SearchBooks godoc
+// @Summary Search books
+// @Description Full-text search over title and author. Every term is prefix-matched, results are ranked by relevance, matches are highlighted with <mark> tags and close misspellings are tolerated.
+// @Tags books
+// @Produce json
+// @Param q query string true "Search terms"
+// @Param limit query int false "Page size (default 20, max 100)"
+// @Param offset query int false "Number of results to skip"
+// @Success 200 {object} query.Page[models.BookSearchResult]
+// @Failure 400 {object} controllers.Problem
+// @Failure 500 {object} controllers.Problem
+// @Router /books/search [get]
+func (bc *BookController) SearchBooks(c *gin.Context) {
+	params, err := query.Parse(c.Request.URL.Query(), query.Spec{})
+	if err != nil {
+		c.Error(services.Invalid(err))
+		return
+	}
+	if params.Cursor != "" {
+		c.Error(services.Invalid(errors.New("search supports offset pagination only")))
+		return
+	}
+
+	page, err := bc.service.Search(c.Request.Context(), c.Query("q"), params)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, page)
+}
+
 // GetBookById godoc
 // @Summary Get a book by ID
 // @Description Get a book by its ID
@@ -67,14 +159,140 @@ func (bc *BookController) AddBook(c *gin.Context) {
 // @Produce json
 // @Param bookId path string true "Book ID"
 // @Success 200 {object} models.Book
-// @Failure 404 {object} map[string]string
+// @Failure 404 {object} controllers.Problem
 // @Router /books/{bookId} [get]
 func (bc *BookController) GetBookById(c *gin.Context) {
 	bookId := c.Param("bookId")
-	res, err := bc.service.GetBookById(bookId)
+	res, err := bc.service.GetBookById(c.Request.Context(), bookId)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
 	c.JSON(http.StatusOK, res)
 }
+
+// UpdateBook godoc
+// @Summary Replace a book
+// @Description Replace all fields of an existing book
+// @Tags books
+// @Accept json
+// @Produce json
+// @Param bookId path int true "Book ID"
+// @Param book body models.UpdateBookRequest true "Book information"
+// @Security BearerAuth
+// @Success 200 {object} models.Book
+// @Failure 400 {object} controllers.Problem
+// @Failure 404 {object} controllers.Problem
+// @Failure 500 {object} controllers.Problem
+// @Router /books/{bookId} [put]
+func (bc *BookController) UpdateBook(c *gin.Context) {
+	id, ok := parseIDParam(c, "bookId")
+	if !ok {
+		return
+	}
+
+	var req models.UpdateBookRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+
+	book := models.Book{
+		Title:  req.Title,
+		Author: req.Author,
+		Price:  req.Price,
+	}
+
+	updated, err := bc.service.Update(c.Request.Context(), id, book)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, updated)
+}
+
+// PatchBook godoc
+// @Summary Partially update a book
+// @Description Apply a JSON merge patch (RFC 7386) to an existing book. Omitted fields are left unchanged and null values are rejected.
+// @Tags books
+// @Accept json
+// @Accept application/merge-patch+json
+// @Produce json
+// @Param bookId path int true "Book ID"
+// @Param book body models.PatchBookRequest true "Fields to change"
+// @Security BearerAuth
+// @Success 200 {object} models.Book
+// @Failure 400 {object} controllers.Problem
+// @Failure 404 {object} controllers.Problem
+// @Failure 500 {object} controllers.Problem
+// @Router /books/{bookId} [patch]
+func (bc *BookController) PatchBook(c *gin.Context) {
+	id, ok := parseIDParam(c, "bookId")
+	if !ok {
+		return
+	}
+
+	var req models.PatchBookRequest
+	if err := bindMergePatch(c, &req); err != nil {
+		c.Error(invalidBody(err))
+		return
+	}
+
+	patched, err := bc.service.Patch(c.Request.Context(), id, req)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, patched)
+}
+
+// DeleteBook godoc
+// @Summary Delete a book
+// @Description Delete a book that is not referenced by any open order
+// @Tags books
+// @Produce json
+// @Param bookId path int true "Book ID"
+// @Security BearerAuth
+// @Success 204
+// @Failure 400 {object} controllers.Problem
+// @Failure 404 {object} controllers.Problem
+// @Failure 409 {object} controllers.Problem
+// @Failure 500 {object} controllers.Problem
+// @Router /books/{bookId} [delete]
+func (bc *BookController) DeleteBook(c *gin.Context) {
+	id, ok := parseIDParam(c, "bookId")
+	if !ok {
+		return
+	}
+
+	if err := bc.service.Delete(c.Request.Context(), id); err != nil {
+		c.Error(err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// RestoreBook godoc
+// @Summary Restore a deleted book
+// @Description Bring a soft-deleted book back into the catalog
+// @Tags admin
+// @Produce json
+// @Param bookId path int true "Book ID"
+// @Security BearerAuth
+// @Success 200 {object} models.Book
+// @Failure 400 {object} controllers.Problem
+// @Failure 404 {object} controllers.Problem
+// @Failure 409 {object} controllers.Problem
+// @Router /admin/books/{bookId}/restore [post]
+func (bc *BookController) RestoreBook(c *gin.Context) {
+	id, ok := parseIDParam(c, "bookId")
+	if !ok {
+		return
+	}
+
+	restored, err := bc.service.Restore(c.Request.Context(), id)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, restored)
+}
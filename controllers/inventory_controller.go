@@ -0,0 +1,92 @@
+package controllers
+
+import (
+	"net/http"
+
+	"book_order_app/models"
+	"book_order_app/query"
+	"book_order_app/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type InventoryController struct {
+	service services.InventoryService
+}
+
+func InitializeInventoryController(inventoryService services.InventoryService) *InventoryController {
+	return &InventoryController{service: inventoryService}
+}
+
+// AdjustStock godoc
+// @Summary Adjust a book's stock
+// @Description Add or remove stock for a book. Every adjustment is recorded in the stock ledger with its reason code.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param bookId path int true "Book ID"
+// @Param adjustment body models.AdjustStockRequest true "Stock adjustment"
+// @Security BearerAuth
+// @Success 200 {object} models.Book
+// @Failure 400 {object} controllers.Problem
+// @Failure 404 {object} controllers.Problem
+// @Failure 409 {object} controllers.Problem
+// @Failure 500 {object} controllers.Problem
+// @Router /admin/books/{bookId}/stock [post]
+func (ic *InventoryController) AdjustStock(c *gin.Context) {
+	id, ok := parseIDParam(c, "bookId")
+	if !ok {
+		return
+	}
+
+	var req models.AdjustStockRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+
+	book, err := ic.service.AdjustStock(c.Request.Context(), id, c.GetUint("user_id"), req)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, book)
+}
+
+// GetStockMovements godoc
+// @Summary List a book's stock movements
+// @Description Get the stock ledger of a book, newest first by default
+// @Tags admin
+// @Produce json
+// @Param bookId path int true "Book ID"
+// @Param limit query int false "Page size (default 20, max 100)"
+// @Param offset query int false "Number of records to skip (offset pagination)"
+// @Param cursor query string false "Opaque cursor from a previous next_cursor (cursor pagination)"
+// @Param sort query string false "Comma-separated sort fields, prefix with - for descending (id, created_at)" default(-created_at)
+// @Param reason query string false "Only movements with this reason"
+// @Param order_id query int false "Only movements for this order"
+// @Security BearerAuth
+// @Success 200 {object} query.Page[models.StockMovement]
+// @Header 200 {string} Link "RFC 8288 next, prev and first page links"
+// @Failure 400 {object} controllers.Problem
+// @Failure 404 {object} controllers.Problem
+// @Failure 500 {object} controllers.Problem
+// @Router /admin/books/{bookId}/stock-movements [get]
+func (ic *InventoryController) GetStockMovements(c *gin.Context) {
+	id, ok := parseIDParam(c, "bookId")
+	if !ok {
+		return
+	}
+
+	params, err := query.Parse(c.Request.URL.Query(), services.StockMovementQuerySpec)
+	if err != nil {
+		c.Error(services.Invalid(err))
+		return
+	}
+
+	page, err := ic.service.GetMovements(c.Request.Context(), id, params)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	writePage(c, params, page)
+}
@@ -3,7 +3,9 @@ package controllers
 import (
 	"net/http"
 
+	"book_order_app/middleware"
 	"book_order_app/models"
+	"book_order_app/query"
 	"book_order_app/services"
 
 	"github.com/gin-gonic/gin"
@@ -11,60 +13,421 @@ import (
 
 type OrderController struct {
 	orderService services.OrderService
-	bookService  services.BookService
 }
 
-func InitializeOrderController() *OrderController {
-	orderService := services.NewOrderService()
-	bookService := services.NewBookService()
+func InitializeOrderController(orderService services.OrderService) *OrderController {
 	return &OrderController{
 		orderService: orderService,
-		bookService:  bookService,
 	}
 }
 
 // GetOrders godoc
-// @Summary Get all orders
-// @Description Get a list of all orders
+// @Summary Get orders
+// @Description Get a paginated list of orders. Callers only see their own orders unless their role grants orders:read:any, which also allows filtering by user_id. Supports offset or cursor pagination, sorting and filtering; navigation links are also returned in the Link header.
 // @Tags orders
 // @Accept json
 // @Produce json
-// @Success 200 {array} models.Order
+// @Param limit query int false "Page size (default 20, max 100)"
+// @Param offset query int false "Number of records to skip (offset pagination)"
+// @Param cursor query string false "Opaque cursor from a previous next_cursor (cursor pagination)"
+// @Param sort query string false "Comma-separated sort fields, prefix with - for descending (id, total, created_at)" default(-created_at)
+// @Param book_id query int false "Only orders containing this book"
+// @Param min_total query number false "Minimum order total"
+// @Param max_total query number false "Maximum order total"
+// @Param status query string false "Only orders in this status" Enums(pending, paid, fulfilled, shipped, delivered, cancelled, refunded)
+// @Param customer_name query string false "Customer name contains (case-insensitive)"
+// @Param created_after query string false "Created after (RFC 3339 or YYYY-MM-DD)"
+// @Param created_before query string false "Created before (RFC 3339 or YYYY-MM-DD)"
+// @Param user_id query int false "Only orders of this user (needs orders:read:any)"
+// @Security BearerAuth
+// @Success 200 {object} query.Page[models.Order]
+// @Header 200 {string} Link "RFC 8288 next, prev and first page links"
+// @Failure 400 {object} controllers.Problem
+// @Failure 401 {object} controllers.Problem
+// @Failure 500 {object} controllers.Problem
 // @Router /orders [get]
 func (oc *OrderController) GetOrders(c *gin.Context) {
-	c.JSON(http.StatusOK, oc.orderService.GetAll())
+	oc.listOrders(c, false, !middleware.HasPermission(c, models.PermOrdersReadAny))
+}
+
+// GetMyOrders godoc
+// @Summary Get my orders
+// @Description Get a paginated list of the authenticated user's orders, with the same pagination, sorting and filtering as GET /orders
+// @Tags users
+// @Produce json
+// @Param limit query int false "Page size (default 20, max 100)"
+// @Param offset query int false "Number of records to skip (offset pagination)"
+// @Param cursor query string false "Opaque cursor from a previous next_cursor (cursor pagination)"
+// @Param sort query string false "Comma-separated sort fields, prefix with - for descending (id, total, created_at)" default(-created_at)
+// @Param status query string false "Only orders in this status" Enums(pending, paid, fulfilled, shipped, delivered, cancelled, refunded)
+// @Security BearerAuth
+// @Success 200 {object} query.Page[models.Order]
+// @Header 200 {string} Link "RFC 8288 next, prev and first page links"
+// @Failure 400 {object} controllers.Problem
+// @Failure 401 {object} controllers.Problem
+// @Failure 500 {object} controllers.Problem
+// @Router /users/me/orders [get]
+func (oc *OrderController) GetMyOrders(c *gin.Context) {
+	oc.listOrders(c, false, true)
+}
+
+// GetOrder godoc
+// @Summary Get an order
+// @Description Get an order with its items. Regular users can only see their own orders.
+// @Tags orders
+// @Produce json
+// @Param orderId path int true "Order ID"
+// @Security BearerAuth
+// @Success 200 {object} models.Order
+// @Failure 400 {object} controllers.Problem
+// @Failure 401 {object} controllers.Problem
+// @Failure 404 {object} controllers.Problem
+// @Failure 500 {object} controllers.Problem
+// @Router /orders/{orderId} [get]
+func (oc *OrderController) GetOrder(c *gin.Context) {
+	id, ok := parseIDParam(c, "orderId")
+	if !ok {
+		return
+	}
+
+	order, ok := oc.loadVisibleOrder(c, id)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, order)
+}
+
+// ListOrdersAdmin godoc
+// @Summary List orders including trashed ones
+// @Description Admin listing of orders; pass include_deleted=true to also return soft-deleted orders
+// @Tags admin
+// @Produce json
+// @Param include_deleted query bool false "Include soft-deleted orders"
+// @Param limit query int false "Page size (default 20, max 100)"
+// @Param offset query int false "Number of records to skip (offset pagination)"
+// @Param cursor query string false "Opaque cursor from a previous next_cursor (cursor pagination)"
+// @Param sort query string false "Comma-separated sort fields, prefix with - for descending (id, total, created_at)" default(-created_at)
+// @Param book_id query int false "Only orders containing this book"
+// @Param min_total query number false "Minimum order total"
+// @Param max_total query number false "Maximum order total"
+// @Param status query string false "Only orders in this status" Enums(pending, paid, fulfilled, shipped, delivered, cancelled, refunded)
+// @Param customer_name query string false "Customer name contains (case-insensitive)"
+// @Param created_after query string false "Created after (RFC 3339 or YYYY-MM-DD)"
+// @Param created_before query string false "Created before (RFC 3339 or YYYY-MM-DD)"
+// @Param user_id query int false "Only orders of this user"
+// @Security BearerAuth
+// @Success 200 {object} query.Page[models.Order]
+// @Header 200 {string} Link "RFC 8288 next, prev and first page links"
+// @Failure 400 {object} controllers.Problem
+// @Failure 401 {object} controllers.Problem
+// @Failure 403 {object} controllers.Problem
+// @Failure 500 {object} controllers.Problem
+// @Router /admin/orders [get]
+func (oc *OrderController) ListOrdersAdmin(c *gin.Context) {
+	oc.listOrders(c, includeDeleted(c), false)
+}
+
+// listOrders serves an order listing, scoped to the caller's own orders when ownOnly is set
+func (oc *OrderController) listOrders(c *gin.Context, withDeleted bool, ownOnly bool) {
+	params, err := query.Parse(c.Request.URL.Query(), services.OrderQuerySpec)
+	if err != nil {
+		c.Error(services.Invalid(err))
+		return
+	}
+	if ownOnly {
+		params.Restrict("user_id", c.GetUint("user_id"))
+	}
+
+	page, err := oc.orderService.GetAll(c.Request.Context(), params, withDeleted)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	writePage(c, params, page)
 }
 
 // PlaceOrder godoc
 // @Summary Place a new order
-// @Description Create a new order for a book
+// @Description Create an order with one or more books for the authenticated user. Stock is reserved and each line captures the book's current price atomically; 409 is returned when a line cannot be filled. When verified email addresses are required, users who have not verified theirs get 403.
 // @Tags orders
 // @Accept json
 // @Produce json
 // @Param order body models.CreateOrderRequest true "Order information"
+// @Security BearerAuth
 // @Success 201 {object} models.Order
-// @Failure 400 {object} map[string]string
-// @Failure 404 {object} map[string]string
+// @Failure 400 {object} controllers.Problem
+// @Failure 401 {object} controllers.Problem
+// @Failure 403 {object} controllers.Problem
+// @Failure 404 {object} controllers.Problem
+// @Failure 409 {object} controllers.Problem
+// @Failure 500 {object} controllers.Problem
 // @Router /orders [post]
 func (oc *OrderController) PlaceOrder(c *gin.Context) {
 	var req models.CreateOrderRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if !bindJSON(c, &req) {
+		return
+	}
+
+	// Convert request to Order model; the customer comes from the token and prices are filled in by the service
+	userID := c.GetUint("user_id")
+	order := models.Order{
+		UserID:       &userID,
+		CustomerName: c.GetString("username"),
+		Items:        make([]models.OrderItem, len(req.Items)),
+	}
+	for i, item := range req.Items {
+		order.Items[i] = models.OrderItem{
+			BookID:   item.BookID,
+			Quantity: item.Quantity,
+		}
+	}
+
+	created, err := oc.orderService.Create(c.Request.Context(), order)
+	if err != nil {
+		c.Error(err)
 		return
 	}
+	c.JSON(http.StatusCreated, created)
+}
 
-	if !oc.bookService.Exists(req.BookID) {
-		c.JSON(http.StatusNotFound, gin.H{"error": "book not found"})
+// DeleteOrder godoc
+// @Summary Delete an order
+// @Description Move an order to the trash, releasing the stock it reserved
+// @Tags admin
+// @Produce json
+// @Param orderId path int true "Order ID"
+// @Security BearerAuth
+// @Success 204
+// @Failure 400 {object} controllers.Problem
+// @Failure 404 {object} controllers.Problem
+// @Failure 500 {object} controllers.Problem
+// @Router /admin/orders/{orderId} [delete]
+func (oc *OrderController) DeleteOrder(c *gin.Context) {
+	id, ok := parseIDParam(c, "orderId")
+	if !ok {
 		return
 	}
 
-	// Convert request to Order model
-	order := models.Order{
-		BookID:       req.BookID,
-		CustomerName: req.CustomerName,
-		Quantity:     req.Quantity,
+	if err := oc.orderService.Delete(c.Request.Context(), id); err != nil {
+		c.Error(err)
+		return
 	}
+	c.Status(http.StatusNoContent)
+}
 
-	created := oc.orderService.Create(order)
-	c.JSON(http.StatusCreated, created)
+// RestoreOrder godoc
+// @Summary Restore a deleted order
+// @Description Bring a soft-deleted order back, reserving its stock again
+// @Tags admin
+// @Produce json
+// @Param orderId path int true "Order ID"
+// @Security BearerAuth
+// @Success 200 {object} models.Order
+// @Failure 400 {object} controllers.Problem
+// @Failure 404 {object} controllers.Problem
+// @Failure 409 {object} controllers.Problem
+// @Router /admin/orders/{orderId}/restore [post]
+func (oc *OrderController) RestoreOrder(c *gin.Context) {
+	id, ok := parseIDParam(c, "orderId")
+	if !ok {
+		return
+	}
+
+	restored, err := oc.orderService.Restore(c.Request.Context(), id)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, restored)
+}
+
+// CancelOrder godoc
+// @Summary Cancel an order
+// @Description Cancel an order that has not shipped yet, releasing its reserved stock. Other users' orders need the orders:manage permission.
+// @Tags orders
+// @Accept json
+// @Produce json
+// @Param orderId path int true "Order ID"
+// @Param transition body models.OrderTransitionRequest false "Optional note"
+// @Security BearerAuth
+// @Success 200 {object} models.Order
+// @Failure 400 {object} controllers.Problem
+// @Failure 403 {object} controllers.Problem
+// @Failure 404 {object} controllers.Problem
+// @Failure 409 {object} controllers.Problem
+// @Failure 500 {object} controllers.Problem
+// @Router /orders/{orderId}/cancel [post]
+func (oc *OrderController) CancelOrder(c *gin.Context) {
+	id, ok := parseIDParam(c, "orderId")
+	if !ok {
+		return
+	}
+	order, ok := oc.loadVisibleOrder(c, id)
+	if !ok {
+		return
+	}
+	if !callerOwns(c, order) && !middleware.HasPermission(c, models.PermOrdersManage) {
+		c.Error(middleware.ErrPermissionDenied)
+		return
+	}
+	oc.transition(c, models.OrderStatusCancelled)
+}
+
+// ShipOrder godoc
+// @Summary Ship an order
+// @Description Mark a fulfilled order as shipped
+// @Tags orders
+// @Accept json
+// @Produce json
+// @Param orderId path int true "Order ID"
+// @Param transition body models.OrderTransitionRequest false "Optional note, e.g. a tracking number"
+// @Security BearerAuth
+// @Success 200 {object} models.Order
+// @Failure 400 {object} controllers.Problem
+// @Failure 404 {object} controllers.Problem
+// @Failure 409 {object} controllers.Problem
+// @Failure 500 {object} controllers.Problem
+// @Router /orders/{orderId}/ship [post]
+func (oc *OrderController) ShipOrder(c *gin.Context) {
+	oc.transition(c, models.OrderStatusShipped)
+}
+
+// DeliverOrder godoc
+// @Summary Mark an order delivered
+// @Description Mark a shipped order as delivered
+// @Tags orders
+// @Accept json
+// @Produce json
+// @Param orderId path int true "Order ID"
+// @Param transition body models.OrderTransitionRequest false "Optional note"
+// @Security BearerAuth
+// @Success 200 {object} models.Order
+// @Failure 400 {object} controllers.Problem
+// @Failure 404 {object} controllers.Problem
+// @Failure 409 {object} controllers.Problem
+// @Failure 500 {object} controllers.Problem
+// @Router /orders/{orderId}/deliver [post]
+func (oc *OrderController) DeliverOrder(c *gin.Context) {
+	oc.transition(c, models.OrderStatusDelivered)
+}
+
+// RefundOrder godoc
+// @Summary Refund an order
+// @Description Refund a paid, fulfilled or delivered order. Stock reserved by an unshipped order is released.
+// @Tags orders
+// @Accept json
+// @Produce json
+// @Param orderId path int true "Order ID"
+// @Param transition body models.OrderTransitionRequest false "Optional note, e.g. the reason for the refund"
+// @Security BearerAuth
+// @Success 200 {object} models.Order
+// @Failure 400 {object} controllers.Problem
+// @Failure 403 {object} controllers.Problem
+// @Failure 404 {object} controllers.Problem
+// @Failure 409 {object} controllers.Problem
+// @Failure 500 {object} controllers.Problem
+// @Router /orders/{orderId}/refund [post]
+func (oc *OrderController) RefundOrder(c *gin.Context) {
+	oc.transition(c, models.OrderStatusRefunded)
+}
+
+// UpdateOrderStatus godoc
+// @Summary Change an order's status
+// @Description Move an order to any status the lifecycle allows next (pending → paid|cancelled, paid → fulfilled|cancelled|refunded, fulfilled → shipped|cancelled|refunded, shipped → delivered, delivered → refunded). Refunds also need the orders:refund permission.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param orderId path int true "Order ID"
+// @Param status body models.UpdateOrderStatusRequest true "New status"
+// @Security BearerAuth
+// @Success 200 {object} models.Order
+// @Failure 400 {object} controllers.Problem
+// @Failure 404 {object} controllers.Problem
+// @Failure 409 {object} controllers.Problem
+// @Failure 500 {object} controllers.Problem
+// @Router /admin/orders/{orderId}/status [post]
+func (oc *OrderController) UpdateOrderStatus(c *gin.Context) {
+	id, ok := parseIDParam(c, "orderId")
+	if !ok {
+		return
+	}
+
+	var req models.UpdateOrderStatusRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+	if req.Status == models.OrderStatusRefunded && !middleware.HasPermission(c, models.PermOrdersRefund) {
+		c.Error(middleware.ErrPermissionDenied)
+		return
+	}
+
+	order, err := oc.orderService.Transition(c.Request.Context(), id, req.Status, c.GetUint("user_id"), req.Note)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, order)
+}
+
+// GetOrderHistory godoc
+// @Summary Get an order's status history
+// @Description List every status change of an order with who made it and when, oldest first
+// @Tags admin
+// @Produce json
+// @Param orderId path int true "Order ID"
+// @Security BearerAuth
+// @Success 200 {array} models.OrderStatusChange
+// @Failure 400 {object} controllers.Problem
+// @Failure 404 {object} controllers.Problem
+// @Failure 500 {object} controllers.Problem
+// @Router /admin/orders/{orderId}/history [get]
+func (oc *OrderController) GetOrderHistory(c *gin.Context) {
+	id, ok := parseIDParam(c, "orderId")
+	if !ok {
+		return
+	}
+
+	history, err := oc.orderService.GetHistory(c.Request.Context(), id)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, history)
+}
+
+// loadVisibleOrder fetches an order the caller may see, answering 404 for other
+// users' orders unless the caller may read every order
+func (oc *OrderController) loadVisibleOrder(c *gin.Context, id uint) (models.Order, bool) {
+	order, err := oc.orderService.GetByID(c.Request.Context(), id)
+	if err != nil {
+		c.Error(err)
+		return models.Order{}, false
+	}
+	if !callerOwns(c, order) && !middleware.HasPermission(c, models.PermOrdersReadAny) {
+		c.Error(services.ErrOrderNotFound)
+		return models.Order{}, false
+	}
+	return order, true
+}
+
+// transition applies a fixed status change with an optional note in the request body
+func (oc *OrderController) transition(c *gin.Context, to models.OrderStatus) {
+	id, ok := parseIDParam(c, "orderId")
+	if !ok {
+		return
+	}
+
+	var req models.OrderTransitionRequest
+	if c.Request.ContentLength > 0 {
+		if !bindJSON(c, &req) {
+			return
+		}
+	}
+
+	order, err := oc.orderService.Transition(c.Request.Context(), id, to, c.GetUint("user_id"), req.Note)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, order)
 }
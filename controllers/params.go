@@ -0,0 +1,71 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"book_order_app/models"
+	"book_order_app/query"
+	"book_order_app/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+// parseIDParam reads a numeric path parameter, reporting a validation problem when it is not a positive integer
+func parseIDParam(c *gin.Context, name string) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param(name), 10, 64)
+	if err != nil || id == 0 {
+		c.Error(services.Invalid(fmt.Errorf("invalid %s", name),
+			services.FieldError{Field: name, Message: "must be a positive integer"}))
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// callerOwns reports whether order was placed by the authenticated user
+func callerOwns(c *gin.Context, order models.Order) bool {
+	return order.UserID != nil && *order.UserID == c.GetUint("user_id")
+}
+
+// includeDeleted reports whether the caller asked for soft-deleted records with ?include_deleted=true
+func includeDeleted(c *gin.Context) bool {
+	value, err := strconv.ParseBool(c.DefaultQuery("include_deleted", "false"))
+	return err == nil && value
+}
+
+// writePage answers a list request with the page envelope and an RFC 8288 Link header
+func writePage[T any](c *gin.Context, params query.Params, page query.Page[T]) {
+	if link := query.LinkHeader(c.Request.URL, params, page.NextCursor); link != "" {
+		c.Header("Link", link)
+	}
+	c.JSON(http.StatusOK, page)
+}
+
+// bindMergePatch decodes a JSON merge patch into obj and validates the fields that are present.
+// Explicit nulls would remove a member under RFC 7386, which none of our required fields allow.
+func bindMergePatch(c *gin.Context, obj any) error {
+	body, err := c.GetRawData()
+	if err != nil {
+		return err
+	}
+
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal(body, &members); err != nil {
+		return errors.New("request body must be a JSON object")
+	}
+	for name, value := range members {
+		if bytes.Equal(bytes.TrimSpace(value), []byte("null")) {
+			return fmt.Errorf("field %q cannot be null", name)
+		}
+	}
+
+	if err := json.Unmarshal(body, obj); err != nil {
+		return err
+	}
+	return binding.Validator.ValidateStruct(obj)
+}
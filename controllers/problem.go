@@ -0,0 +1,204 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"book_order_app/repository"
+	"book_order_app/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+// ProblemContentType is the media type of every error response (RFC 7807)
+const ProblemContentType = "application/problem+json"
+
+// Problem is the body of every error response, following RFC 7807. Type identifies
+// the kind of problem and is stable; Detail explains this occurrence.
+type Problem struct {
+	Type      string                `json:"type" example:"/problems/not-found"`
+	Title     string                `json:"title" example:"Not Found"`
+	Status    int                   `json:"status" example:"404"`
+	Detail    string                `json:"detail,omitempty" example:"book not found"`
+	Instance  string                `json:"instance,omitempty" example:"/api/v1/books/42"`
+	RequestID string                `json:"request_id,omitempty" example:"4f1c2a9b0d7e4e1f8a3b6c5d2e1f0a9b"`
+	Errors    []services.FieldError `json:"errors,omitempty"`
+}
+
+// indexPattern matches the array indexes of a decoder field path such as items.0.quantity
+var indexPattern = regexp.MustCompile(`\.(\d+)`)
+
+// problemTypes maps each kind of domain error to its status and problem type
+var problemTypes = map[services.Kind]struct {
+	status int
+	typ    string
+}{
+	services.KindValidation:      {http.StatusBadRequest, "/problems/validation-error"},
+	services.KindUnauthorized:    {http.StatusUnauthorized, "/problems/unauthorized"},
+	services.KindForbidden:       {http.StatusForbidden, "/problems/forbidden"},
+	services.KindNotFound:        {http.StatusNotFound, "/problems/not-found"},
+	services.KindConflict:        {http.StatusConflict, "/problems/conflict"},
+	services.KindTooManyRequests: {http.StatusTooManyRequests, "/problems/too-many-requests"},
+	services.KindUnavailable:     {http.StatusServiceUnavailable, "/problems/unavailable"},
+}
+
+func init() {
+	// Report validation errors by JSON name, e.g. items[0].quantity
+	if v, ok := binding.Validator.Engine().(*validator.Validate); ok {
+		v.RegisterTagNameFunc(func(field reflect.StructField) string {
+			name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if name == "-" {
+				return ""
+			}
+			return name
+		})
+	}
+}
+
+// ErrorHandler renders the last error a handler or middleware attached with c.Error
+// as application/problem+json. Handlers report failures with c.Error and return
+// without writing a body.
+func ErrorHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		if len(c.Errors) == 0 || c.Writer.Written() {
+			return
+		}
+		writeProblem(c, c.Errors.Last().Err)
+	}
+}
+
+// NoRoute answers requests for unknown routes with a not-found problem
+func NoRoute(c *gin.Context) {
+	c.Error(services.NewError(services.KindNotFound, "no route matches "+c.Request.Method+" "+c.Request.URL.Path))
+}
+
+func writeProblem(c *gin.Context, err error) {
+	problem := Problem{
+		Type:      "about:blank",
+		Status:    http.StatusInternalServerError,
+		Detail:    "the server could not process the request",
+		Instance:  c.Request.URL.Path,
+		RequestID: c.GetString("request_id"),
+	}
+	if pt, ok := problemTypes[services.KindOf(err)]; ok {
+		problem.Type = pt.typ
+		problem.Status = pt.status
+		problem.Detail = err.Error()
+		if repository.IsUnavailable(err) {
+			// Driver errors name hosts and internals, so they are only logged
+			problem.Detail = "the database is unavailable, try again later"
+			c.Header("Retry-After", "5")
+		}
+
+		var domain *services.Error
+		if errors.As(err, &domain) {
+			problem.Errors = domain.Fields
+			if domain.RetryAfter > 0 {
+				// Whole seconds, rounded up so the client does not come back too early
+				c.Header("Retry-After", strconv.Itoa(int(math.Ceil(domain.RetryAfter.Seconds()))))
+			}
+		}
+	}
+	problem.Title = http.StatusText(problem.Status)
+
+	c.Header("Content-Type", ProblemContentType)
+	c.Status(problem.Status)
+	if err := json.NewEncoder(c.Writer).Encode(problem); err != nil {
+		c.Error(err)
+	}
+}
+
+// bindJSON binds and validates the request body into obj, reporting a validation
+// problem when that fails
+func bindJSON(c *gin.Context, obj any) bool {
+	if err := c.ShouldBindJSON(obj); err != nil {
+		c.Error(invalidBody(err))
+		return false
+	}
+	return true
+}
+
+// invalidBody turns a binding error into a validation error that names the fields at fault
+// instead of echoing validator or decoder internals
+func invalidBody(err error) error {
+	var (
+		validationErrs validator.ValidationErrors
+		syntaxErr      *json.SyntaxError
+		typeErr        *json.UnmarshalTypeError
+	)
+	switch {
+	case errors.As(err, &validationErrs):
+		fields := make([]services.FieldError, len(validationErrs))
+		for i, fe := range validationErrs {
+			fields[i] = services.FieldError{Field: fieldPath(fe), Message: fieldMessage(fe)}
+		}
+		return services.Invalid(errors.New("request body failed validation"), fields...)
+	case errors.As(err, &typeErr):
+		return services.Invalid(errors.New("request body has a field of the wrong type"),
+			services.FieldError{Field: indexPattern.ReplaceAllString(typeErr.Field, "[$1]"), Message: "must be a " + jsonType(typeErr.Type)})
+	case errors.As(err, &syntaxErr), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return services.Invalid(errors.New("request body is not valid JSON"))
+	}
+	return services.Invalid(err)
+}
+
+// fieldPath drops the struct name from a validator namespace such as CreateOrderRequest.items[0].quantity
+func fieldPath(fe validator.FieldError) string {
+	_, path, found := strings.Cut(fe.Namespace(), ".")
+	if !found {
+		return fe.Field()
+	}
+	return path
+}
+
+func fieldMessage(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required":
+		return "is required"
+	case "oneof":
+		return "must be one of: " + strings.ReplaceAll(fe.Param(), " ", ", ")
+	case "unique":
+		return "must not contain duplicates"
+	case "min", "max":
+		bound := "at least"
+		if fe.Tag() == "max" {
+			bound = "at most"
+		}
+		switch fe.Kind() {
+		case reflect.String:
+			return fmt.Sprintf("must be %s %s characters long", bound, fe.Param())
+		case reflect.Slice, reflect.Array, reflect.Map:
+			return fmt.Sprintf("must contain %s %s items", bound, fe.Param())
+		}
+		return fmt.Sprintf("must be %s %s", bound, fe.Param())
+	case "gt":
+		return "must be greater than " + fe.Param()
+	}
+	return fmt.Sprintf("failed the %s rule", fe.Tag())
+}
+
+func jsonType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	case reflect.Map, reflect.Struct:
+		return "object"
+	}
+	return "number"
+}
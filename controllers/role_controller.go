@@ -0,0 +1,156 @@
+package controllers
+
+import (
+	"net/http"
+
+	"book_order_app/models"
+	"book_order_app/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type RoleController struct {
+	service services.RoleService
+}
+
+func InitializeRoleController(roleService services.RoleService) *RoleController {
+	return &RoleController{service: roleService}
+}
+
+// ListRoles godoc
+// @Summary List roles
+// @Description List every role with the permissions it grants, ordered by name
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {array} models.Role
+// @Failure 401 {object} controllers.Problem
+// @Failure 403 {object} controllers.Problem
+// @Failure 500 {object} controllers.Problem
+// @Router /admin/roles [get]
+func (rc *RoleController) ListRoles(c *gin.Context) {
+	roles, err := rc.service.List(c.Request.Context())
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, roles)
+}
+
+// GetRole godoc
+// @Summary Get a role
+// @Description Get a role and the permissions it grants
+// @Tags admin
+// @Produce json
+// @Param role path string true "Role name"
+// @Security BearerAuth
+// @Success 200 {object} models.Role
+// @Failure 401 {object} controllers.Problem
+// @Failure 403 {object} controllers.Problem
+// @Failure 404 {object} controllers.Problem
+// @Failure 500 {object} controllers.Problem
+// @Router /admin/roles/{role} [get]
+func (rc *RoleController) GetRole(c *gin.Context) {
+	role, err := rc.service.Get(c.Request.Context(), models.UserRole(c.Param("role")))
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, role)
+}
+
+// CreateRole godoc
+// @Summary Create a role
+// @Description Define a role that users can be given. Names start with a lower-case letter and contain only lower-case letters, digits, '-' and '_'. The caller must hold every permission the role grants.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param role body models.CreateRoleRequest true "Name, description and permissions"
+// @Security BearerAuth
+// @Success 201 {object} models.Role
+// @Failure 400 {object} controllers.Problem
+// @Failure 401 {object} controllers.Problem
+// @Failure 403 {object} controllers.Problem
+// @Failure 409 {object} controllers.Problem
+// @Failure 500 {object} controllers.Problem
+// @Router /admin/roles [post]
+func (rc *RoleController) CreateRole(c *gin.Context) {
+	var req models.CreateRoleRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+
+	role, err := rc.service.Create(c.Request.Context(), c.GetUint("user_id"), req)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusCreated, role)
+}
+
+// UpdateRole godoc
+// @Summary Update a role
+// @Description Replace the description and permissions of a role. The change applies to the next request of every user with the role. The caller must hold every permission the role grants before and after. The admin role always grants every permission and cannot be changed.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param role path string true "Role name"
+// @Param definition body models.UpdateRoleRequest true "Description and permissions"
+// @Security BearerAuth
+// @Success 200 {object} models.Role
+// @Failure 400 {object} controllers.Problem
+// @Failure 401 {object} controllers.Problem
+// @Failure 403 {object} controllers.Problem
+// @Failure 404 {object} controllers.Problem
+// @Failure 409 {object} controllers.Problem
+// @Failure 500 {object} controllers.Problem
+// @Router /admin/roles/{role} [put]
+func (rc *RoleController) UpdateRole(c *gin.Context) {
+	var req models.UpdateRoleRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+
+	role, err := rc.service.Update(c.Request.Context(), c.GetUint("user_id"), models.UserRole(c.Param("role")), req)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, role)
+}
+
+// DeleteRole godoc
+// @Summary Delete a role
+// @Description Delete a role that no user, including deleted users that could be restored, has. Built-in roles cannot be deleted.
+// @Tags admin
+// @Produce json
+// @Param role path string true "Role name"
+// @Security BearerAuth
+// @Success 204
+// @Failure 401 {object} controllers.Problem
+// @Failure 403 {object} controllers.Problem
+// @Failure 404 {object} controllers.Problem
+// @Failure 409 {object} controllers.Problem
+// @Failure 500 {object} controllers.Problem
+// @Router /admin/roles/{role} [delete]
+func (rc *RoleController) DeleteRole(c *gin.Context) {
+	if err := rc.service.Delete(c.Request.Context(), models.UserRole(c.Param("role"))); err != nil {
+		c.Error(err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// ListPermissions godoc
+// @Summary List permissions
+// @Description List every permission a role can grant
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {array} models.Permission
+// @Failure 401 {object} controllers.Problem
+// @Failure 403 {object} controllers.Problem
+// @Router /admin/permissions [get]
+func (rc *RoleController) ListPermissions(c *gin.Context) {
+	c.JSON(http.StatusOK, models.Permissions)
+}
@@ -3,6 +3,7 @@ package controllers
 import (
 	"book_order_app/middleware"
 	"book_order_app/models"
+	"book_order_app/query"
 	"book_order_app/services"
 	"net/http"
 
@@ -10,38 +11,52 @@ import (
 )
 
 type UserController struct {
-	userService services.UserService
+	userService   services.UserService
+	tokenService  services.TokenService
+	resetService  services.PasswordResetService
+	verifyService services.EmailVerificationService
+	mfaService    services.MFAService
+	apiKeyService services.APIKeyService
 }
 
-func InitializeUserController() *UserController {
+func InitializeUserController(userService services.UserService, tokenService services.TokenService,
+	resetService services.PasswordResetService, verifyService services.EmailVerificationService,
+	mfaService services.MFAService, apiKeyService services.APIKeyService) *UserController {
 	return &UserController{
-		userService: services.NewUserService(),
+		userService:   userService,
+		tokenService:  tokenService,
+		resetService:  resetService,
+		verifyService: verifyService,
+		mfaService:    mfaService,
+		apiKeyService: apiKeyService,
 	}
 }
 
 // Register godoc
 // @Summary Register a new user
-// @Description Create a new user account
+// @Description Create a new account. Self-registered accounts always get the user role; admins create other admins through POST /admin/users. A verification link is mailed to the email address, if one is given.
 // @Tags users
 // @Accept json
 // @Produce json
 // @Param user body models.RegisterRequest true "User registration details"
 // @Success 201 {object} models.AuthResponse
-// @Failure 400 {object} map[string]string
-// @Failure 500 {object} map[string]string
+// @Failure 400 {object} controllers.Problem
+// @Failure 409 {object} controllers.Problem
+// @Failure 500 {object} controllers.Problem
 // @Router /users/register [post]
 func (uc *UserController) RegisterUser(c *gin.Context) {
 	var req models.RegisterRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if !bindJSON(c, &req) {
 		return
 	}
 
-	user, err := uc.userService.Register(req)
+	user, err := uc.userService.Register(c.Request.Context(), req)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
+	// The account exists either way; a failed mail can be asked for again
+	_ = uc.verifyService.Send(c.Request.Context(), user)
 
 	c.JSON(http.StatusCreated, models.AuthResponse{
 		User: *user,
@@ -50,40 +65,140 @@ func (uc *UserController) RegisterUser(c *gin.Context) {
 
 // Login godoc
 // @Summary Login user
-// @Description Authenticate user and return JWT token
+// @Description Authenticate user and return a short-lived JWT access token and a refresh token. After too many failed logins the username, or the client address, is locked out for a while (429 with Retry-After). Users with two-factor authentication get 202 with an MFA token instead, to exchange together with a code at POST /users/login/mfa.
 // @Tags users
 // @Accept json
 // @Produce json
 // @Param credentials body models.LoginRequest true "User login credentials"
 // @Success 200 {object} models.AuthResponse
-// @Failure 400 {object} map[string]string
-// @Failure 401 {object} map[string]string
-// @Failure 500 {object} map[string]string
+// @Success 202 {object} models.MFAChallengeResponse
+// @Failure 400 {object} controllers.Problem
+// @Failure 401 {object} controllers.Problem
+// @Failure 403 {object} controllers.Problem
+// @Failure 429 {object} controllers.Problem
+// @Header 429 {integer} Retry-After "Seconds until the lockout ends"
+// @Failure 500 {object} controllers.Problem
 // @Router /users/login [post]
 func (uc *UserController) LoginUser(c *gin.Context) {
 	var req models.LoginRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if !bindJSON(c, &req) {
 		return
 	}
 
-	user, err := uc.userService.Login(req)
+	user, err := uc.userService.Login(c.Request.Context(), req, c.ClientIP())
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
 
-	// Generate JWT token
-	token, err := middleware.GenerateToken(user.ID, user.Username, string(user.Role))
+	// The password is only the first step for users with MFA
+	if user.MFAEnabled() {
+		challenge, err := uc.mfaService.Challenge(c.Request.Context(), user)
+		if err != nil {
+			c.Error(err)
+			return
+		}
+		c.JSON(http.StatusAccepted, challenge)
+		return
+	}
+
+	// Start a session: short-lived access token plus rotating refresh token
+	resp, err := uc.tokenService.Issue(c.Request.Context(), user)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		c.Error(err)
 		return
 	}
 
-	c.JSON(http.StatusOK, models.AuthResponse{
-		Token: token,
-		User:  *user,
-	})
+	c.JSON(http.StatusOK, resp)
+}
+
+// LoginMFA godoc
+// @Summary Complete a two-factor login
+// @Description Exchange the MFA token from POST /users/login and a code from the authenticator app, or an unused recovery code, for a JWT access token and a refresh token. Each MFA token allows one attempt; after a wrong code, log in with the password again. Wrong codes count towards the login lockout.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param request body models.MFALoginRequest true "MFA token and code"
+// @Success 200 {object} models.AuthResponse
+// @Failure 400 {object} controllers.Problem
+// @Failure 401 {object} controllers.Problem
+// @Failure 429 {object} controllers.Problem
+// @Header 429 {integer} Retry-After "Seconds until the lockout ends"
+// @Failure 500 {object} controllers.Problem
+// @Router /users/login/mfa [post]
+func (uc *UserController) LoginMFA(c *gin.Context) {
+	var req models.MFALoginRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+
+	user, err := uc.mfaService.CompleteLogin(c.Request.Context(), req, c.ClientIP())
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	resp, err := uc.tokenService.Issue(c.Request.Context(), user)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// Refresh godoc
+// @Summary Refresh access token
+// @Description Exchange a refresh token for a new access token and a new refresh token. Each refresh token can be used once; replaying a used one revokes the whole session.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param refresh body models.RefreshRequest true "Refresh token"
+// @Success 200 {object} models.AuthResponse
+// @Failure 400 {object} controllers.Problem
+// @Failure 401 {object} controllers.Problem
+// @Failure 500 {object} controllers.Problem
+// @Router /users/refresh [post]
+func (uc *UserController) RefreshToken(c *gin.Context) {
+	var req models.RefreshRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+
+	resp, err := uc.tokenService.Refresh(c.Request.Context(), req.RefreshToken)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// Logout godoc
+// @Summary Logout user
+// @Description Revoke the current session: its refresh tokens stop working and its access tokens are rejected immediately
+// @Tags users
+// @Produce json
+// @Security BearerAuth
+// @Success 204
+// @Failure 401 {object} controllers.Problem
+// @Failure 500 {object} controllers.Problem
+// @Router /users/logout [post]
+func (uc *UserController) Logout(c *gin.Context) {
+	sessionID := c.GetString("session_id")
+	if sessionID == "" {
+		// Tokens issued before sessions existed can only be revoked individually
+		sessionID = c.GetString("token_id")
+	}
+	if sessionID == "" {
+		c.Status(http.StatusNoContent)
+		return
+	}
+
+	if err := uc.tokenService.RevokeSession(c.Request.Context(), sessionID); err != nil {
+		c.Error(err)
+		return
+	}
+	c.Status(http.StatusNoContent)
 }
 
 // GetProfile godoc
@@ -94,21 +209,697 @@ func (uc *UserController) LoginUser(c *gin.Context) {
 // @Produce json
 // @Security BearerAuth
 // @Success 200 {object} models.User
-// @Failure 401 {object} map[string]string
-// @Failure 404 {object} map[string]string
+// @Failure 401 {object} controllers.Problem
+// @Failure 404 {object} controllers.Problem
 // @Router /users/profile [get]
 func (uc *UserController) GetProfile(c *gin.Context) {
 	userID, exists := c.Get("user_id")
 	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		c.Error(middleware.ErrAuthRequired)
 		return
 	}
 
-	user, err := uc.userService.GetByID(userID.(uint))
+	user, err := uc.userService.GetByID(c.Request.Context(), userID.(uint))
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
 
 	c.JSON(http.StatusOK, user)
 }
+
+// UpdateProfile godoc
+// @Summary Update user profile
+// @Description Merge patch (RFC 7386) of the authenticated user's display name, email and default shipping address. Omitted fields are left unchanged; an empty string clears a field. A new email address is unverified until the user follows the link mailed to it.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param profile body models.UpdateProfileRequest true "Fields to change"
+// @Security BearerAuth
+// @Success 200 {object} models.User
+// @Failure 400 {object} controllers.Problem
+// @Failure 401 {object} controllers.Problem
+// @Failure 409 {object} controllers.Problem
+// @Failure 500 {object} controllers.Problem
+// @Router /users/profile [patch]
+func (uc *UserController) UpdateProfile(c *gin.Context) {
+	var req models.UpdateProfileRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+
+	user, err := uc.userService.UpdateProfile(c.Request.Context(), c.GetUint("user_id"), req)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	if req.Email != nil {
+		// The profile is saved either way; a failed mail can be asked for again
+		_ = uc.verifyService.Send(c.Request.Context(), user)
+	}
+	c.JSON(http.StatusOK, user)
+}
+
+// ChangePassword godoc
+// @Summary Change password
+// @Description Replace the authenticated user's password after checking the current one. Every existing session and API key is revoked and a new session is started for the caller.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param password body models.ChangePasswordRequest true "Current and new password"
+// @Security BearerAuth
+// @Success 200 {object} models.AuthResponse
+// @Failure 400 {object} controllers.Problem
+// @Failure 401 {object} controllers.Problem
+// @Failure 500 {object} controllers.Problem
+// @Router /users/password [post]
+func (uc *UserController) ChangePassword(c *gin.Context) {
+	var req models.ChangePasswordRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+
+	user, err := uc.userService.ChangePassword(c.Request.Context(), c.GetUint("user_id"), req)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	if !uc.revokeAPIKeys(c, user.ID) {
+		return
+	}
+	resp, ok := uc.restartSessions(c, user)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// ForgotPassword godoc
+// @Summary Request a password reset
+// @Description Mail a single-use password reset token to the account's email address. The response is the same whether or not the account exists or has an address.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param request body models.ForgotPasswordRequest true "Account to reset"
+// @Success 202 {object} models.MessageResponse
+// @Failure 400 {object} controllers.Problem
+// @Failure 500 {object} controllers.Problem
+// @Router /users/password/forgot [post]
+func (uc *UserController) ForgotPassword(c *gin.Context) {
+	var req models.ForgotPasswordRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+
+	if err := uc.resetService.Forgot(c.Request.Context(), req.Username); err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusAccepted, models.MessageResponse{
+		Message: "If the account exists and has an email address, a password reset link has been sent to it",
+	})
+}
+
+// CompletePasswordReset godoc
+// @Summary Reset a forgotten password
+// @Description Set a new password with the token from the password reset mail. The token works once and every session and API key of the user is revoked.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param request body models.ResetPasswordRequest true "Reset token and new password"
+// @Success 204
+// @Failure 400 {object} controllers.Problem
+// @Failure 500 {object} controllers.Problem
+// @Router /users/password/reset [post]
+func (uc *UserController) CompletePasswordReset(c *gin.Context) {
+	var req models.ResetPasswordRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+
+	user, err := uc.resetService.Reset(c.Request.Context(), req)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	if !uc.revokeSessions(c, user.ID) || !uc.revokeAPIKeys(c, user.ID) {
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// VerifyEmail godoc
+// @Summary Verify an email address
+// @Description Confirm the address a verification mail was sent to with the token from that mail. The token works once and only for the address it was sent to.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param request body models.VerifyEmailRequest true "Verification token"
+// @Success 200 {object} models.User
+// @Failure 400 {object} controllers.Problem
+// @Failure 500 {object} controllers.Problem
+// @Router /users/verify-email [post]
+func (uc *UserController) VerifyEmail(c *gin.Context) {
+	var req models.VerifyEmailRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+
+	user, err := uc.verifyService.Verify(c.Request.Context(), req.Token)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, user)
+}
+
+// ResendVerification godoc
+// @Summary Resend the verification mail
+// @Description Mail a new verification link to the authenticated user's email address. Links sent before stop working.
+// @Tags users
+// @Produce json
+// @Security BearerAuth
+// @Success 202 {object} models.MessageResponse
+// @Failure 400 {object} controllers.Problem
+// @Failure 401 {object} controllers.Problem
+// @Failure 409 {object} controllers.Problem
+// @Failure 500 {object} controllers.Problem
+// @Router /users/verify-email/resend [post]
+func (uc *UserController) ResendVerification(c *gin.Context) {
+	if err := uc.verifyService.Resend(c.Request.Context(), c.GetUint("user_id")); err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusAccepted, models.MessageResponse{Message: "A verification link has been sent to your email address"})
+}
+
+// StartMFA godoc
+// @Summary Start two-factor enrollment
+// @Description Create a new TOTP secret for the authenticated user. Add it to an authenticator app, usually by showing provisioning_uri as a QR code, then confirm it with POST /users/mfa/totp/confirm. Starting again replaces a secret that was not confirmed.
+// @Tags users
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} models.TOTPEnrollment
+// @Failure 401 {object} controllers.Problem
+// @Failure 409 {object} controllers.Problem
+// @Failure 500 {object} controllers.Problem
+// @Router /users/mfa/totp [post]
+func (uc *UserController) StartMFA(c *gin.Context) {
+	enrollment, err := uc.mfaService.StartEnrollment(c.Request.Context(), c.GetUint("user_id"))
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, enrollment)
+}
+
+// ConfirmMFA godoc
+// @Summary Turn on two-factor authentication
+// @Description Confirm the enrollment with a code from the authenticator app. Returns the recovery codes, shown only this once, and a new session; every other session is revoked.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param request body models.MFACodeRequest true "Code from the authenticator app"
+// @Security BearerAuth
+// @Success 200 {object} models.MFAEnabledResponse
+// @Failure 400 {object} controllers.Problem
+// @Failure 401 {object} controllers.Problem
+// @Failure 409 {object} controllers.Problem
+// @Failure 500 {object} controllers.Problem
+// @Router /users/mfa/totp/confirm [post]
+func (uc *UserController) ConfirmMFA(c *gin.Context) {
+	var req models.MFACodeRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+
+	user, codes, err := uc.mfaService.Enable(c.Request.Context(), c.GetUint("user_id"), req.Code)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	resp, ok := uc.restartSessions(c, user)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, models.MFAEnabledResponse{AuthResponse: resp, RecoveryCodes: codes})
+}
+
+// DisableMFA godoc
+// @Summary Turn off two-factor authentication
+// @Description Turn off two-factor authentication with a code from the authenticator app or a recovery code. Returns a new session; every other session is revoked.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param request body models.MFACodeRequest true "Current code or recovery code"
+// @Security BearerAuth
+// @Success 200 {object} models.AuthResponse
+// @Failure 400 {object} controllers.Problem
+// @Failure 401 {object} controllers.Problem
+// @Failure 409 {object} controllers.Problem
+// @Failure 500 {object} controllers.Problem
+// @Router /users/mfa/disable [post]
+func (uc *UserController) DisableMFA(c *gin.Context) {
+	var req models.MFACodeRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+
+	user, err := uc.mfaService.Disable(c.Request.Context(), c.GetUint("user_id"), req.Code)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	resp, ok := uc.restartSessions(c, user)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// RegenerateRecoveryCodes godoc
+// @Summary Replace recovery codes
+// @Description Replace every recovery code with new ones, shown only in this response, after checking a code from the authenticator app or a recovery code
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param request body models.MFACodeRequest true "Current code or recovery code"
+// @Security BearerAuth
+// @Success 200 {object} models.RecoveryCodesResponse
+// @Failure 400 {object} controllers.Problem
+// @Failure 401 {object} controllers.Problem
+// @Failure 409 {object} controllers.Problem
+// @Failure 500 {object} controllers.Problem
+// @Router /users/mfa/recovery-codes [post]
+func (uc *UserController) RegenerateRecoveryCodes(c *gin.Context) {
+	var req models.MFACodeRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+
+	codes, err := uc.mfaService.RegenerateRecoveryCodes(c.Request.Context(), c.GetUint("user_id"), req.Code)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, models.RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// restartSessions replaces every session of a user with a new one, after a change
+// the claims of existing access tokens no longer match
+func (uc *UserController) restartSessions(c *gin.Context, user *models.User) (models.AuthResponse, bool) {
+	if !uc.revokeSessions(c, user.ID) {
+		return models.AuthResponse{}, false
+	}
+	resp, err := uc.tokenService.Issue(c.Request.Context(), user)
+	if err != nil {
+		c.Error(err)
+		return models.AuthResponse{}, false
+	}
+	return resp, true
+}
+
+// ListUsers godoc
+// @Summary List users
+// @Description Admin listing of users; pass include_deleted=true to also return soft-deleted users
+// @Tags admin
+// @Produce json
+// @Param include_deleted query bool false "Include soft-deleted users"
+// @Param limit query int false "Page size (default 20, max 100)"
+// @Param offset query int false "Number of records to skip (offset pagination)"
+// @Param cursor query string false "Opaque cursor from a previous next_cursor (cursor pagination)"
+// @Param sort query string false "Comma-separated sort fields, prefix with - for descending (id, username, created_at)" default(id)
+// @Param username query string false "Username contains (case-insensitive)"
+// @Param role query string false "Exact role"
+// @Security BearerAuth
+// @Success 200 {object} query.Page[models.User]
+// @Header 200 {string} Link "RFC 8288 next, prev and first page links"
+// @Failure 400 {object} controllers.Problem
+// @Failure 401 {object} controllers.Problem
+// @Failure 403 {object} controllers.Problem
+// @Failure 500 {object} controllers.Problem
+// @Router /admin/users [get]
+func (uc *UserController) ListUsers(c *gin.Context) {
+	params, err := query.Parse(c.Request.URL.Query(), services.UserQuerySpec)
+	if err != nil {
+		c.Error(services.Invalid(err))
+		return
+	}
+
+	page, err := uc.userService.GetAll(c.Request.Context(), params, includeDeleted(c))
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	writePage(c, params, page)
+}
+
+// CreateUser godoc
+// @Summary Create a user
+// @Description Create an account with any defined role whose permissions the caller holds too. The role is recorded in the user's role history.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param user body models.CreateUserRequest true "Account details"
+// @Security BearerAuth
+// @Success 201 {object} models.User
+// @Failure 400 {object} controllers.Problem
+// @Failure 401 {object} controllers.Problem
+// @Failure 403 {object} controllers.Problem
+// @Failure 409 {object} controllers.Problem
+// @Failure 500 {object} controllers.Problem
+// @Router /admin/users [post]
+func (uc *UserController) CreateUser(c *gin.Context) {
+	var req models.CreateUserRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+
+	user, err := uc.userService.Create(c.Request.Context(), c.GetUint("user_id"), req)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusCreated, user)
+}
+
+// GetRoleHistory godoc
+// @Summary Get a user's role history
+// @Description Audit trail of every role a user has held, oldest first, with the admin who granted it
+// @Tags admin
+// @Produce json
+// @Param userId path int true "User ID"
+// @Security BearerAuth
+// @Success 200 {array} models.RoleChange
+// @Failure 400 {object} controllers.Problem
+// @Failure 401 {object} controllers.Problem
+// @Failure 403 {object} controllers.Problem
+// @Failure 404 {object} controllers.Problem
+// @Failure 500 {object} controllers.Problem
+// @Router /admin/users/{userId}/role-history [get]
+func (uc *UserController) GetRoleHistory(c *gin.Context) {
+	id, ok := parseIDParam(c, "userId")
+	if !ok {
+		return
+	}
+
+	history, err := uc.userService.GetRoleHistory(c.Request.Context(), id)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, history)
+}
+
+// ChangeRole godoc
+// @Summary Change a user's role
+// @Description Give a user another defined role. The caller must hold every permission of the old and the new role. The change is recorded in the role history and the user's sessions are revoked so no token keeps the old role. The last active admin cannot be demoted.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param userId path int true "User ID"
+// @Param role body models.ChangeRoleRequest true "New role"
+// @Security BearerAuth
+// @Success 200 {object} models.User
+// @Failure 400 {object} controllers.Problem
+// @Failure 401 {object} controllers.Problem
+// @Failure 403 {object} controllers.Problem
+// @Failure 404 {object} controllers.Problem
+// @Failure 409 {object} controllers.Problem
+// @Failure 500 {object} controllers.Problem
+// @Router /admin/users/{userId}/role [put]
+func (uc *UserController) ChangeRole(c *gin.Context) {
+	id, ok := parseIDParam(c, "userId")
+	if !ok {
+		return
+	}
+	var req models.ChangeRoleRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+
+	user, err := uc.userService.ChangeRole(c.Request.Context(), c.GetUint("user_id"), id, req)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	if !uc.revokeSessions(c, id) {
+		return
+	}
+	c.JSON(http.StatusOK, user)
+}
+
+// DisableUser godoc
+// @Summary Disable a user
+// @Description Disable an account: its sessions are revoked and it can no longer sign in until enabled again. The last active admin cannot be disabled.
+// @Tags admin
+// @Produce json
+// @Param userId path int true "User ID"
+// @Security BearerAuth
+// @Success 200 {object} models.User
+// @Failure 400 {object} controllers.Problem
+// @Failure 401 {object} controllers.Problem
+// @Failure 403 {object} controllers.Problem
+// @Failure 404 {object} controllers.Problem
+// @Failure 409 {object} controllers.Problem
+// @Failure 500 {object} controllers.Problem
+// @Router /admin/users/{userId}/disable [post]
+func (uc *UserController) DisableUser(c *gin.Context) {
+	id, ok := parseIDParam(c, "userId")
+	if !ok {
+		return
+	}
+
+	user, err := uc.userService.SetDisabled(c.Request.Context(), c.GetUint("user_id"), id, true)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	if !uc.revokeSessions(c, id) {
+		return
+	}
+	c.JSON(http.StatusOK, user)
+}
+
+// EnableUser godoc
+// @Summary Enable a user
+// @Description Let a disabled account sign in again
+// @Tags admin
+// @Produce json
+// @Param userId path int true "User ID"
+// @Security BearerAuth
+// @Success 200 {object} models.User
+// @Failure 400 {object} controllers.Problem
+// @Failure 401 {object} controllers.Problem
+// @Failure 403 {object} controllers.Problem
+// @Failure 404 {object} controllers.Problem
+// @Failure 500 {object} controllers.Problem
+// @Router /admin/users/{userId}/enable [post]
+func (uc *UserController) EnableUser(c *gin.Context) {
+	id, ok := parseIDParam(c, "userId")
+	if !ok {
+		return
+	}
+
+	user, err := uc.userService.SetDisabled(c.Request.Context(), c.GetUint("user_id"), id, false)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, user)
+}
+
+// ResetPassword godoc
+// @Summary Force a password reset
+// @Description Replace a user's password with a temporary one, returned only in this response, revoke the user's sessions and API keys and flag the account: until the user chooses a new password, their sessions can only read the profile, change the password and log out
+// @Tags admin
+// @Produce json
+// @Param userId path int true "User ID"
+// @Security BearerAuth
+// @Success 200 {object} models.PasswordResetResponse
+// @Failure 400 {object} controllers.Problem
+// @Failure 401 {object} controllers.Problem
+// @Failure 403 {object} controllers.Problem
+// @Failure 404 {object} controllers.Problem
+// @Failure 500 {object} controllers.Problem
+// @Router /admin/users/{userId}/reset-password [post]
+func (uc *UserController) ResetPassword(c *gin.Context) {
+	id, ok := parseIDParam(c, "userId")
+	if !ok {
+		return
+	}
+
+	resp, err := uc.userService.ResetPassword(c.Request.Context(), c.GetUint("user_id"), id)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	if !uc.revokeSessions(c, id) || !uc.revokeAPIKeys(c, id) {
+		return
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// UnlockUser godoc
+// @Summary Unlock a user's logins
+// @Description Lift the lockout caused by failed logins of a user and forget those failures. Lockouts of client addresses expire on their own.
+// @Tags admin
+// @Produce json
+// @Param userId path int true "User ID"
+// @Security BearerAuth
+// @Success 200 {object} models.User
+// @Failure 400 {object} controllers.Problem
+// @Failure 401 {object} controllers.Problem
+// @Failure 403 {object} controllers.Problem
+// @Failure 404 {object} controllers.Problem
+// @Failure 500 {object} controllers.Problem
+// @Router /admin/users/{userId}/unlock [post]
+func (uc *UserController) UnlockUser(c *gin.Context) {
+	id, ok := parseIDParam(c, "userId")
+	if !ok {
+		return
+	}
+
+	user, err := uc.userService.Unlock(c.Request.Context(), c.GetUint("user_id"), id)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, user)
+}
+
+// ResetMFA godoc
+// @Summary Reset two-factor authentication
+// @Description Turn off two-factor authentication for a user who lost both the authenticator and the recovery codes, and revoke the user's sessions and API keys
+// @Tags admin
+// @Produce json
+// @Param userId path int true "User ID"
+// @Security BearerAuth
+// @Success 200 {object} models.User
+// @Failure 400 {object} controllers.Problem
+// @Failure 401 {object} controllers.Problem
+// @Failure 403 {object} controllers.Problem
+// @Failure 404 {object} controllers.Problem
+// @Failure 409 {object} controllers.Problem
+// @Failure 500 {object} controllers.Problem
+// @Router /admin/users/{userId}/mfa/reset [post]
+func (uc *UserController) ResetMFA(c *gin.Context) {
+	id, ok := parseIDParam(c, "userId")
+	if !ok {
+		return
+	}
+
+	user, err := uc.mfaService.Reset(c.Request.Context(), c.GetUint("user_id"), id)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	if !uc.revokeSessions(c, id) || !uc.revokeAPIKeys(c, id) {
+		return
+	}
+	c.JSON(http.StatusOK, user)
+}
+
+// DeleteUser godoc
+// @Summary Delete a user
+// @Description Move a user to the trash and revoke its sessions; restore it with POST /admin/users/{userId}/restore. The last active admin cannot be deleted.
+// @Tags admin
+// @Param userId path int true "User ID"
+// @Security BearerAuth
+// @Success 204
+// @Failure 400 {object} controllers.Problem
+// @Failure 401 {object} controllers.Problem
+// @Failure 403 {object} controllers.Problem
+// @Failure 404 {object} controllers.Problem
+// @Failure 409 {object} controllers.Problem
+// @Failure 500 {object} controllers.Problem
+// @Router /admin/users/{userId} [delete]
+func (uc *UserController) DeleteUser(c *gin.Context) {
+	id, ok := parseIDParam(c, "userId")
+	if !ok {
+		return
+	}
+
+	if err := uc.userService.Delete(c.Request.Context(), c.GetUint("user_id"), id); err != nil {
+		c.Error(err)
+		return
+	}
+	if !uc.revokeSessions(c, id) {
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// revokeSessions signs a user out everywhere after the account changed, reporting
+// the error when that fails
+func (uc *UserController) revokeSessions(c *gin.Context, userID uint) bool {
+	if err := uc.tokenService.RevokeUserSessions(c.Request.Context(), userID); err != nil {
+		c.Error(err)
+		return false
+	}
+	return true
+}
+
+// revokeAPIKeys deletes a user's API keys after the account's credentials were
+// reset, reporting failures through c
+func (uc *UserController) revokeAPIKeys(c *gin.Context, userID uint) bool {
+	if err := uc.apiKeyService.RevokeAll(c.Request.Context(), userID); err != nil {
+		c.Error(err)
+		return false
+	}
+	return true
+}
+
+// RestoreUser godoc
+// @Summary Restore a deleted user
+// @Description Bring a soft-deleted user account back
+// @Tags admin
+// @Produce json
+// @Param userId path int true "User ID"
+// @Security BearerAuth
+// @Success 200 {object} models.User
+// @Failure 400 {object} controllers.Problem
+// @Failure 404 {object} controllers.Problem
+// @Failure 409 {object} controllers.Problem
+// @Failure 500 {object} controllers.Problem
+// @Router /admin/users/{userId}/restore [post]
+func (uc *UserController) RestoreUser(c *gin.Context) {
+	id, ok := parseIDParam(c, "userId")
+	if !ok {
+		return
+	}
+
+	user, err := uc.userService.Restore(c.Request.Context(), c.GetUint("user_id"), id)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, user)
+}
+
+// RevokeToken godoc
+// @Summary Revoke an access token
+// @Description Add an access token id (jti) to the denylist so the token is rejected immediately
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param token body models.RevokeTokenRequest true "Token to revoke"
+// @Security BearerAuth
+// @Success 204
+// @Failure 400 {object} controllers.Problem
+// @Failure 401 {object} controllers.Problem
+// @Failure 403 {object} controllers.Problem
+// @Failure 500 {object} controllers.Problem
+// @Router /admin/tokens/revoke [post]
+func (uc *UserController) RevokeToken(c *gin.Context) {
+	var req models.RevokeTokenRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+
+	if err := uc.tokenService.RevokeAccessToken(c.Request.Context(), req.JTI); err != nil {
+		c.Error(err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,27 @@
+package controllers
+
+import (
+	"net/http"
+
+	"book_order_app/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+type WellKnownController struct {
+	tokens *middleware.AccessTokens
+}
+
+func InitializeWellKnownController(tokens *middleware.AccessTokens) *WellKnownController {
+	return &WellKnownController{tokens: tokens}
+}
+
+// JWKS serves the public keys access tokens are signed with, so that other
+// services can verify them without sharing a secret. It lives at
+// /.well-known/jwks.json, outside the API base path, and is empty while tokens
+// are signed with HS256.
+func (wc *WellKnownController) JWKS(c *gin.Context) {
+	// Keys change only with a restart, but rotated-in keys should be picked up soon
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, wc.tokens.JWKS())
+}
@@ -0,0 +1,77 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"book_order_app/middleware"
+)
+
+var logger = middleware.GetLogger()
+
+type logMailer struct {
+	from string
+}
+
+// NewLogMailer returns a mailer that writes every message to the application log
+// instead of sending it. Reset links then show up in the server output.
+func NewLogMailer(from string) Mailer {
+	return &logMailer{from: from}
+}
+
+func (m *logMailer) Send(ctx context.Context, msg Message) error {
+	if _, err := compose(m.from, msg); err != nil {
+		return err
+	}
+	logger.WithFields(map[string]interface{}{
+		"to":      msg.To,
+		"subject": msg.Subject,
+		"body":    msg.Body,
+	}).Info("Mail not sent (log mail driver)")
+	return nil
+}
+
+type fileMailer struct {
+	from string
+	dir  string
+	seq  atomic.Uint64
+}
+
+// NewFileMailer returns a mailer that stores every message as an .eml file in dir,
+// named so that a directory listing is in sending order
+func NewFileMailer(from, dir string) Mailer {
+	return &fileMailer{from: from, dir: dir}
+}
+
+func (m *fileMailer) Send(ctx context.Context, msg Message) error {
+	data, err := compose(m.from, msg)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(m.dir, 0o755); err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%s-%06d-%s.eml", time.Now().UTC().Format("20060102T150405.000000000"), m.seq.Add(1), sanitize(msg.To))
+	// Write then rename, so readers never see a partial message
+	tmp := filepath.Join(m.dir, "."+name+".tmp")
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(m.dir, name))
+}
+
+// sanitize keeps a recipient address safe to use in a file name
+func sanitize(address string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '@' || r == '-' || r == '_' {
+			return r
+		}
+		return '_'
+	}, address)
+}
@@ -1,10 +1,19 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
 	"log"
+	"os"
+	"time"
 
+	"book_order_app/config"
 	_ "book_order_app/docs"
+	"book_order_app/mailer"
 	"book_order_app/middleware"
+	"book_order_app/repository"
 	"book_order_app/routers"
 
 	"github.com/gin-gonic/gin"
@@ -12,7 +21,7 @@ import (
 
 // @title Book Order API
 // @version 1.0
-// @description API for managing books and orders
+// @description API for managing books and orders. Every error response is an RFC 7807 problem (application/problem+json) with type, title, status, detail, per-field errors and a request id. Requests fail with 503 when the database is unreachable or does not answer within the request timeout.
 // @host localhost:8080
 // @BasePath /api/v1
 // @schemes http https
@@ -21,20 +30,79 @@ import (
 // @name Authorization
 // @description Type "Bearer" followed by a space and JWT token.
 func main() {
+	if err := run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// run starts the server and returns once it stops, so deferred cleanup such as
+// closing the connection pool happens before main exits
+func run() error {
+	// Resolve configuration: defaults < config file < environment < flags
+	cfg, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	// Access tokens are signed with the configured key pair, or the shared secret without one
+	keys, err := middleware.LoadKeySet(cfg.Auth)
+	if err != nil {
+		return fmt.Errorf("failed to load JWT keys: %w", err)
+	}
+
+	// One connection pool shared by every service
+	dbHandler, err := config.NewDBHandler(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer dbHandler.Close()
 
 	// Initialize Gin router
 	r := gin.New()
+	// Only trusted proxies may name the client, which login lockouts are counted for
+	if err := r.SetTrustedProxies(cfg.Server.TrustedProxies); err != nil {
+		return fmt.Errorf("invalid trusted proxies: %w", err)
+	}
 
 	// Add middleware
 	r.Use(gin.Recovery())      // Panic recovery
 	r.Use(middleware.Logger()) // Custom logger
+	r.Use(middleware.Timeout(time.Duration(cfg.Server.RequestTimeout)))
+
+	mail, err := mailer.New(cfg.Mail)
+	if err != nil {
+		return fmt.Errorf("invalid mail configuration: %w", err)
+	}
+	svc := routers.NewServices(cfg, keys, repository.NewGormRepositories(dbHandler.DB), mail)
+	routers.RegisterRoutes(r, svc)
+
+	// Users are given roles by name, so the built-in ones must exist before anyone signs in
+	if err := svc.Roles.SeedBuiltIn(context.Background()); err != nil {
+		return fmt.Errorf("failed to create built-in roles: %w", err)
+	}
+
+	// Self-registration only creates users, so the first admin comes from the configuration
+	if admin := cfg.Auth.BootstrapAdmin; admin.Username != "" {
+		user, err := svc.Users.BootstrapAdmin(context.Background(), admin.Username, admin.Password)
+		if err != nil {
+			return fmt.Errorf("failed to create bootstrap admin: %w", err)
+		}
+		if user != nil {
+			log.Printf("Created bootstrap admin %q", user.Username)
+		}
+	}
 
-	routers.RegisterRoutes(r)
+	// Permanently remove records that have been in the trash longer than the retention window
+	go svc.Purge.Run(context.Background(), time.Duration(cfg.Retention.PurgeInterval), time.Duration(cfg.Retention.SoftDelete))
 
 	// Start server
-	log.Println("Server starting on :8080")
-	log.Println("Swagger documentation available at http://localhost:8080/swagger/index.html")
-	if err := r.Run(":8080"); err != nil {
-		log.Fatalf("Failed to start server: %v", err)
+	log.Printf("Server starting on %s (environment: %s)", cfg.Server.Addr, cfg.Env)
+	log.Println("Swagger documentation available at /swagger-ui/index.html")
+	if err := r.Run(cfg.Server.Addr); err != nil {
+		return fmt.Errorf("failed to start server: %w", err)
 	}
+	return nil
 }
@@ -1,48 +1,168 @@
 package middleware
 
 import (
-	"net/http"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"strings"
 	"time"
 
+	"book_order_app/config"
+
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
 )
 
-var jwtSecret = []byte("your-secret-key-change-this-in-production") // TODO: Move to environment variable
+var (
+	ErrAuthRequired           = errors.New("authorization header required")
+	ErrInvalidToken           = errors.New("invalid or expired token")
+	ErrTokenRevoked           = errors.New("token has been revoked")
+	ErrAccountInactive        = errors.New("account is disabled or no longer exists")
+	ErrTokenCheckFailed       = errors.New("unable to validate token")
+	ErrPermissionDenied       = errors.New("insufficient permissions")
+	ErrPasswordChangeRequired = errors.New("your password was reset; choose a new one with POST /users/password first")
+	ErrMFARequired            = errors.New("admin access requires signing in with two-factor authentication; enable it and sign in again")
+)
 
 // Claims represents the JWT claims
 type Claims struct {
-	UserID   uint   `json:"user_id"`
-	Username string `json:"username"`
-	Role     string `json:"role"`
+	UserID    uint   `json:"user_id"`
+	Username  string `json:"username"`
+	Role      string `json:"role"`
+	SessionID string `json:"sid"`
+	// MFA is set when the session was started with a second factor
+	MFA bool `json:"mfa,omitempty"`
+	// PasswordChange is set while the user must replace a password an admin reset;
+	// the session can then only reach routes marked with AllowPendingPasswordChange
+	PasswordChange bool `json:"pwd_change,omitempty"`
 	jwt.RegisteredClaims
 }
 
-// GenerateToken generates a JWT token for a user
-func GenerateToken(userID uint, username string, role string) (string, error) {
+// TokenDenylist reports whether any of the given token or session ids has been revoked
+type TokenDenylist interface {
+	IsRevoked(ctx context.Context, ids ...string) (bool, error)
+}
+
+// AccessTokens signs and verifies the JWT access tokens of one API
+type AccessTokens struct {
+	keys     *KeySet
+	issuer   string
+	audience string
+	ttl      time.Duration
+}
+
+// NewAccessTokens returns access tokens signed with keys that carry the issuer,
+// audience and lifetime configured in auth
+func NewAccessTokens(auth config.AuthConfig, keys *KeySet) *AccessTokens {
+	return &AccessTokens{
+		keys:     keys,
+		issuer:   auth.JWTIssuer,
+		audience: auth.JWTAudience,
+		ttl:      time.Duration(auth.AccessTokenTTL),
+	}
+}
+
+// TTL is how long an access token is valid
+func (t *AccessTokens) TTL() time.Duration {
+	return t.ttl
+}
+
+// JWKS returns the public keys the tokens are verified with
+func (t *AccessTokens) JWKS() JWKS {
+	return t.keys.JWKS()
+}
+
+// Generate signs a short-lived access token for a user session; mfa records that
+// the session was started with a second factor and passwordChange that the user
+// has to choose a new password before anything else
+func (t *AccessTokens) Generate(userID uint, username string, role string, sessionID string, mfa bool, passwordChange bool) (string, error) {
+	now := time.Now()
 	claims := Claims{
-		UserID:   userID,
-		Username: username,
-		Role:     role,
+		UserID:         userID,
+		Username:       username,
+		Role:           role,
+		SessionID:      sessionID,
+		MFA:            mfa,
+		PasswordChange: passwordChange,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)), // Token expires in 24 hours
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-			NotBefore: jwt.NewNumericDate(time.Now()),
+			ID:        NewTokenID(),
+			Issuer:    t.issuer,
+			Audience:  jwt.ClaimStrings{t.audience},
+			ExpiresAt: jwt.NewNumericDate(now.Add(t.ttl)),
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(jwtSecret)
+	return t.keys.sign(claims)
+}
+
+// parse verifies a token; only the algorithms of known keys and tokens issued by
+// and for this API are accepted
+func (t *AccessTokens) parse(raw string) (*Claims, error) {
+	token, err := jwt.ParseWithClaims(raw, &Claims{}, t.keys.keyFunc,
+		jwt.WithValidMethods(t.keys.methods),
+		jwt.WithIssuer(t.issuer),
+		jwt.WithAudience(t.audience),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil || !token.Valid {
+		return nil, ErrInvalidToken
+	}
+	claims, ok := token.Claims.(*Claims)
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+	return claims, nil
 }
 
-// AuthMiddleware validates JWT token
-func AuthMiddleware() gin.HandlerFunc {
+// NewTokenID returns a random 128-bit identifier for jti and session ids
+func NewTokenID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err) // crypto/rand never fails on supported platforms
+	}
+	return hex.EncodeToString(b)
+}
+
+// Auth authenticates requests and checks what callers may do. Each router is
+// wired with its own, so routers in one process share nothing.
+type Auth struct {
+	tokens   *AccessTokens
+	denylist TokenDenylist
+	apiKeys  APIKeyAuthenticator
+	// permissions checks the caller's account and resolves its permissions; without
+	// one, callers have no permissions
+	permissions     PermissionResolver
+	requireAdminMFA bool
+}
+
+// NewAuth returns middleware that accepts tokens signed by tokens unless denylist
+// revoked them, and API keys checked by apiKeys. requireAdminMFA keeps admin
+// sessions without a second factor out of routes that need a permission.
+func NewAuth(tokens *AccessTokens, denylist TokenDenylist, apiKeys APIKeyAuthenticator, permissions PermissionResolver, requireAdminMFA bool) *Auth {
+	return &Auth{
+		tokens:          tokens,
+		denylist:        denylist,
+		apiKeys:         apiKeys,
+		permissions:     permissions,
+		requireAdminMFA: requireAdminMFA,
+	}
+}
+
+// Authenticate validates the JWT access token, or the API key in the X-API-Key
+// header when one is sent
+func (a *Auth) Authenticate() gin.HandlerFunc {
 	return func(c *gin.Context) {
+		if key := c.GetHeader(APIKeyHeader); key != "" {
+			a.authenticateAPIKey(c, key)
+			return
+		}
+
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header required"})
-			c.Abort()
+			abort(c, ErrAuthRequired)
 			return
 		}
 
@@ -55,30 +175,41 @@ func AuthMiddleware() gin.HandlerFunc {
 		}
 
 		if tokenString == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Token is empty"})
-			c.Abort()
+			abort(c, ErrAuthRequired)
 			return
 		}
 
-		// Parse and validate token
-		token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-			return jwtSecret, nil
-		})
+		claims, err := a.tokens.parse(tokenString)
+		if err != nil {
+			abort(c, err)
+			return
+		}
+
+		// Reject tokens that were revoked individually or through their session
+		if a.denylist != nil {
+			revoked, err := a.denylist.IsRevoked(c.Request.Context(), claims.ID, claims.SessionID)
+			if err != nil {
+				abort(c, ErrTokenCheckFailed)
+				return
+			}
+			if revoked {
+				abort(c, ErrTokenRevoked)
+				return
+			}
+		}
 
-		if err != nil || !token.Valid {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
-			c.Abort()
+		if claims.PasswordChange && !c.GetBool("allow_password_change") {
+			abort(c, ErrPasswordChangeRequired)
 			return
 		}
 
-		// Extract claims and set in context
-		if claims, ok := token.Claims.(*Claims); ok {
-			c.Set("user_id", claims.UserID)
-			c.Set("username", claims.Username)
-			c.Set("role", claims.Role)
-		} else {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token claims"})
-			c.Abort()
+		c.Set("user_id", claims.UserID)
+		c.Set("username", claims.Username)
+		c.Set("role", claims.Role)
+		c.Set("token_id", claims.ID)
+		c.Set("session_id", claims.SessionID)
+		c.Set("mfa", claims.MFA)
+		if !a.setPermissions(c, claims.UserID) {
 			return
 		}
 
@@ -86,32 +217,18 @@ func AuthMiddleware() gin.HandlerFunc {
 	}
 }
 
-// RequireRole creates a middleware that checks if the user has the required role
-func RequireRole(roles ...string) gin.HandlerFunc {
+// AllowPendingPasswordChange lets sessions whose password an admin reset through
+// the Authenticate middleware that follows it; other routes turn them away until the user
+// chose a new password
+func AllowPendingPasswordChange() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		userRole, exists := c.Get("role")
-		if !exists {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "User role not found in token"})
-			c.Abort()
-			return
-		}
-
-		// Check if user's role matches any of the required roles
-		roleStr := userRole.(string)
-		hasRole := false
-		for _, role := range roles {
-			if roleStr == role {
-				hasRole = true
-				break
-			}
-		}
-
-		if !hasRole {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
-			c.Abort()
-			return
-		}
-
+		c.Set("allow_password_change", true)
 		c.Next()
 	}
 }
+
+// abort stops the handler chain and leaves err for the error handler to render
+func abort(c *gin.Context, err error) {
+	c.Error(err)
+	c.Abort()
+}
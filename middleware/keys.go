@@ -0,0 +1,225 @@
+package middleware
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+
+	"book_order_app/config"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// minRSABits is the smallest RSA key accepted for signing or verification
+const minRSABits = 2048
+
+// KeySet holds the key that signs access tokens and every key whose tokens are
+// accepted. Asymmetric keys are identified by the kid header, their RFC 7638
+// thumbprint, so every instance derives the same ids from the same files.
+type KeySet struct {
+	signing jwtKey
+	// verification maps kid to key and includes the signing key
+	verification map[string]jwtKey
+	// methods are the algorithms of the verification keys; tokens using any other are rejected
+	methods []string
+}
+
+// jwtKey is a key with the algorithm it is used with. HS256 keys have no id.
+type jwtKey struct {
+	id     string
+	method jwt.SigningMethod
+	// private signs; it is nil for verification-only keys
+	private interface{}
+	public  interface{}
+}
+
+// secretKeySet signs and verifies with HS256 and a shared secret
+func secretKeySet(secret []byte) *KeySet {
+	key := jwtKey{method: jwt.SigningMethodHS256, private: secret, public: secret}
+	return &KeySet{signing: key, verification: map[string]jwtKey{"": key}, methods: []string{key.method.Alg()}}
+}
+
+// LoadKeySet reads the signing key and the further verification keys named in
+// auth. Without a private key file tokens are signed with HS256 and auth.JWTSecret.
+func LoadKeySet(auth config.AuthConfig) (*KeySet, error) {
+	if auth.JWTPrivateKeyFile == "" {
+		return secretKeySet([]byte(auth.JWTSecret)), nil
+	}
+	signing, err := readKey(auth.JWTPrivateKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	if signing.private == nil {
+		return nil, fmt.Errorf("jwt private key file %s holds a public key", auth.JWTPrivateKeyFile)
+	}
+
+	ks := &KeySet{signing: signing, verification: map[string]jwtKey{}}
+	ks.add(signing)
+	for _, path := range auth.JWTVerificationKeyFiles {
+		key, err := readKey(path)
+		if err != nil {
+			return nil, err
+		}
+		// Verification keys never sign, even when the file holds the private key
+		key.private = nil
+		ks.add(key)
+	}
+	return ks, nil
+}
+
+func (ks *KeySet) add(key jwtKey) {
+	if _, ok := ks.verification[key.id]; ok {
+		return
+	}
+	ks.verification[key.id] = key
+	for _, m := range ks.methods {
+		if m == key.method.Alg() {
+			return
+		}
+	}
+	ks.methods = append(ks.methods, key.method.Alg())
+}
+
+// sign returns the signed token for claims, with the kid header of the signing key
+func (ks *KeySet) sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(ks.signing.method, claims)
+	if ks.signing.id != "" {
+		token.Header["kid"] = ks.signing.id
+	}
+	return token.SignedString(ks.signing.private)
+}
+
+// keyFunc picks the verification key named by the kid header and rejects tokens
+// whose algorithm is not that key's, so a public key is never used as an HMAC secret
+func (ks *KeySet) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, ok := ks.verification[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	if token.Method.Alg() != key.method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %s for key %q", token.Method.Alg(), kid)
+	}
+	return key.public, nil
+}
+
+// JWK is a public key in JSON Web Key format (RFC 7517)
+type JWK struct {
+	Kty string `json:"kty" example:"OKP"`
+	Kid string `json:"kid" example:"NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs"`
+	Use string `json:"use" example:"sig"`
+	Alg string `json:"alg" example:"EdDSA"`
+	// N and E are set for RSA keys
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// Crv and X are set for Ed25519 keys
+	Crv string `json:"crv,omitempty" example:"Ed25519"`
+	X   string `json:"x,omitempty" example:"11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo"`
+}
+
+// JWKS is a JSON Web Key Set
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public keys tokens are verified with, for other services to
+// verify them too. It is empty while tokens are signed with HS256.
+func (ks *KeySet) JWKS() JWKS {
+	set := JWKS{Keys: []JWK{}}
+	// The signing key first, then the others in a stable order
+	ids := []string{ks.signing.id}
+	for id := range ks.verification {
+		if id != ks.signing.id {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids[1:])
+	for _, id := range ids {
+		if jwk, ok := toJWK(ks.verification[id]); ok {
+			set.Keys = append(set.Keys, jwk)
+		}
+	}
+	return set
+}
+
+func toJWK(key jwtKey) (JWK, bool) {
+	switch pub := key.public.(type) {
+	case *rsa.PublicKey:
+		return JWK{Kty: "RSA", Kid: key.id, Use: "sig", Alg: key.method.Alg(),
+			N: b64(pub.N.Bytes()), E: b64(big.NewInt(int64(pub.E)).Bytes())}, true
+	case ed25519.PublicKey:
+		return JWK{Kty: "OKP", Kid: key.id, Use: "sig", Alg: key.method.Alg(), Crv: "Ed25519", X: b64(pub)}, true
+	}
+	return JWK{}, false
+}
+
+// readKey loads a PEM private key (PKCS#8, or PKCS#1 for RSA) or public key (PKIX)
+func readKey(path string) (jwtKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return jwtKey{}, fmt.Errorf("read jwt key: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return jwtKey{}, fmt.Errorf("jwt key file %s is not PEM encoded", path)
+	}
+
+	var parsed interface{}
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return jwtKey{}, fmt.Errorf("jwt key file %s holds an unsupported %s", path, block.Type)
+	}
+	if err != nil {
+		return jwtKey{}, fmt.Errorf("parse jwt key %s: %w", path, err)
+	}
+
+	var key jwtKey
+	if signer, ok := parsed.(crypto.Signer); ok {
+		key.private = signer
+		parsed = signer.Public()
+	}
+	switch pub := parsed.(type) {
+	case *rsa.PublicKey:
+		if pub.N.BitLen() < minRSABits {
+			return jwtKey{}, fmt.Errorf("jwt key %s: RSA keys need at least %d bits", path, minRSABits)
+		}
+		key.method = jwt.SigningMethodRS256
+		key.public = pub
+		key.id = thumbprint(map[string]string{"e": b64(big.NewInt(int64(pub.E)).Bytes()), "kty": "RSA", "n": b64(pub.N.Bytes())})
+	case ed25519.PublicKey:
+		key.method = jwt.SigningMethodEdDSA
+		key.public = pub
+		key.id = thumbprint(map[string]string{"crv": "Ed25519", "kty": "OKP", "x": b64(pub)})
+	default:
+		return jwtKey{}, errors.New("jwt key " + path + " is neither RSA nor Ed25519")
+	}
+	return key, nil
+}
+
+// thumbprint returns the RFC 7638 thumbprint of a JWK given its required members.
+// encoding/json sorts map keys and adds no whitespace, as the RFC asks.
+func thumbprint(members map[string]string) string {
+	b, _ := json.Marshal(members)
+	sum := sha256.Sum256(b)
+	return b64(sum[:])
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -46,12 +46,14 @@ func Logger() gin.HandlerFunc {
 			"method":      method,
 			"path":        path,
 			"user_agent":  userAgent,
+			"request_id":  c.GetString("request_id"),
 		})
+		if len(c.Errors) > 0 {
+			entry = entry.WithField("errors", c.Errors.String())
+		}
 
 		// Log based on status code
-		if len(c.Errors) > 0 {
-			entry.Error(c.Errors.String())
-		} else if statusCode >= 500 {
+		if statusCode >= 500 {
 			entry.Error("Server error")
 		} else if statusCode >= 400 {
 			entry.Warn("Client error")
@@ -0,0 +1,65 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"slices"
+
+	"book_order_app/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PermissionResolver returns the permissions granted by a user's current role;
+// unknown roles have none. It returns ErrAccountInactive for users who were
+// disabled or deleted, so their tokens stop working even if revoking them failed.
+type PermissionResolver interface {
+	UserPermissions(ctx context.Context, userID uint) ([]string, error)
+}
+
+// setPermissions stores the permissions of the user's role in the context. Accounts
+// and roles are looked up on every request, so disabling a user or changing a role
+// applies to tokens already issued.
+func (a *Auth) setPermissions(c *gin.Context, userID uint) bool {
+	perms := []string{}
+	if a.permissions != nil {
+		var err error
+		if perms, err = a.permissions.UserPermissions(c.Request.Context(), userID); err != nil {
+			if errors.Is(err, ErrAccountInactive) {
+				abort(c, ErrAccountInactive)
+			} else {
+				abort(c, ErrTokenCheckFailed)
+			}
+			return false
+		}
+	}
+	c.Set("permissions", perms)
+	return true
+}
+
+// HasPermission reports whether the authenticated caller's role grants permission
+func HasPermission(c *gin.Context, permission string) bool {
+	return slices.Contains(c.GetStringSlice("permissions"), permission)
+}
+
+// RequirePermission creates a middleware that checks that the caller's role grants
+// permission. When admins must use MFA, admin sessions started without it are turned
+// away; other staff roles are not affected.
+func (a *Auth) RequirePermission(permission string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, exists := c.Get("permissions"); !exists {
+			abort(c, ErrInvalidToken)
+			return
+		}
+		if !HasPermission(c, permission) {
+			abort(c, ErrPermissionDenied)
+			return
+		}
+		if a.requireAdminMFA && c.GetString("role") == string(models.RoleAdmin) && !c.GetBool("mfa") {
+			abort(c, ErrMFARequired)
+			return
+		}
+
+		c.Next()
+	}
+}
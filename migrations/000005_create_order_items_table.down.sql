@@ -0,0 +1,26 @@
+-- Orders with several items keep only their first line when rolled back.
+ALTER TABLE orders
+    ADD COLUMN IF NOT EXISTS book_id BIGINT,
+    ADD COLUMN IF NOT EXISTS quantity INTEGER;
+
+UPDATE orders o
+SET book_id = i.book_id, quantity = i.quantity
+FROM (
+    SELECT DISTINCT ON (order_id) order_id, book_id, quantity
+    FROM order_items
+    ORDER BY order_id, id
+) i
+WHERE i.order_id = o.id;
+
+DELETE FROM orders WHERE book_id IS NULL;
+
+ALTER TABLE orders
+    ALTER COLUMN book_id SET NOT NULL,
+    ALTER COLUMN quantity SET NOT NULL,
+    ADD CONSTRAINT fk_orders_book FOREIGN KEY (book_id) REFERENCES books(id),
+    DROP COLUMN subtotal,
+    DROP COLUMN total;
+
+CREATE INDEX IF NOT EXISTS idx_orders_book_id ON orders(book_id);
+
+DROP TABLE IF EXISTS order_items;
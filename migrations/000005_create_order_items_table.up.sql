@@ -0,0 +1,43 @@
+CREATE TABLE IF NOT EXISTS order_items (
+    id BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMP WITH TIME ZONE,
+    updated_at TIMESTAMP WITH TIME ZONE,
+    order_id BIGINT NOT NULL,
+    book_id BIGINT NOT NULL,
+    quantity INTEGER NOT NULL,
+    unit_price DECIMAL(10, 2) NOT NULL,
+    line_total DECIMAL(10, 2) NOT NULL,
+    CONSTRAINT fk_orders_items FOREIGN KEY (order_id) REFERENCES orders(id) ON DELETE CASCADE,
+    CONSTRAINT fk_order_items_book FOREIGN KEY (book_id) REFERENCES books(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_order_items_order_id ON order_items(order_id);
+CREATE INDEX IF NOT EXISTS idx_order_items_book_id ON order_items(book_id);
+
+ALTER TABLE orders
+    ADD COLUMN IF NOT EXISTS subtotal DECIMAL(10, 2) NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS total DECIMAL(10, 2) NOT NULL DEFAULT 0;
+
+-- Convert legacy single-book orders into one-item orders. The book's current
+-- price is the best available snapshot for orders placed before this migration.
+DO $$
+BEGIN
+    IF EXISTS (
+        SELECT 1 FROM information_schema.columns
+        WHERE table_schema = current_schema() AND table_name = 'orders' AND column_name = 'book_id'
+    ) THEN
+        INSERT INTO order_items (created_at, updated_at, order_id, book_id, quantity, unit_price, line_total)
+        SELECT o.created_at, o.updated_at, o.id, o.book_id, o.quantity, b.price, ROUND(b.price * o.quantity, 2)
+        FROM orders o
+        JOIN books b ON b.id = o.book_id;
+
+        UPDATE orders o
+        SET subtotal = i.line_total, total = i.line_total
+        FROM order_items i
+        WHERE i.order_id = o.id;
+
+        ALTER TABLE orders DROP CONSTRAINT IF EXISTS fk_orders_book;
+        DROP INDEX IF EXISTS idx_orders_book_id;
+        ALTER TABLE orders DROP COLUMN book_id, DROP COLUMN quantity;
+    END IF;
+END $$;
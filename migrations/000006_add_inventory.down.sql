@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS stock_movements;
+ALTER TABLE books DROP CONSTRAINT IF EXISTS chk_books_stock;
+ALTER TABLE books DROP COLUMN IF EXISTS stock;
@@ -0,0 +1,20 @@
+ALTER TABLE books ADD COLUMN IF NOT EXISTS stock INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE books DROP CONSTRAINT IF EXISTS chk_books_stock;
+ALTER TABLE books ADD CONSTRAINT chk_books_stock CHECK (stock >= 0);
+
+CREATE TABLE IF NOT EXISTS stock_movements (
+    id BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMP WITH TIME ZONE,
+    book_id BIGINT NOT NULL,
+    order_id BIGINT,
+    actor_id BIGINT,
+    delta INTEGER NOT NULL,
+    balance_after INTEGER NOT NULL,
+    reason VARCHAR(32) NOT NULL,
+    note TEXT,
+    CONSTRAINT fk_stock_movements_book FOREIGN KEY (book_id) REFERENCES books(id),
+    CONSTRAINT fk_stock_movements_order FOREIGN KEY (order_id) REFERENCES orders(id) ON DELETE SET NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_stock_movements_book_id ON stock_movements(book_id);
+CREATE INDEX IF NOT EXISTS idx_stock_movements_order_id ON stock_movements(order_id);
@@ -0,0 +1,3 @@
+ALTER TABLE orders DROP CONSTRAINT IF EXISTS fk_orders_user;
+DROP INDEX IF EXISTS idx_orders_user_id;
+ALTER TABLE orders DROP COLUMN IF EXISTS user_id;
@@ -0,0 +1,15 @@
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS user_id BIGINT;
+CREATE INDEX IF NOT EXISTS idx_orders_user_id ON orders(user_id);
+
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'fk_orders_user') THEN
+        ALTER TABLE orders ADD CONSTRAINT fk_orders_user FOREIGN KEY (user_id) REFERENCES users(id);
+    END IF;
+END $$;
+
+-- Link legacy orders to the account whose username matches their free-text customer name
+UPDATE orders o
+SET user_id = u.id
+FROM users u
+WHERE o.user_id IS NULL AND u.username = o.customer_name;
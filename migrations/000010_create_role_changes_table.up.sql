@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS role_changes (
+    id BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMP WITH TIME ZONE,
+    user_id BIGINT NOT NULL,
+    from_role VARCHAR(20),
+    to_role VARCHAR(20) NOT NULL,
+    changed_by BIGINT,
+    note TEXT,
+    CONSTRAINT fk_role_changes_user FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_role_changes_user_id ON role_changes(user_id);
+
+-- Existing accounts start their history with the role they hold today
+INSERT INTO role_changes (created_at, user_id, to_role, note)
+SELECT created_at, id, role, 'recorded by migration'
+FROM users
+WHERE NOT EXISTS (SELECT 1 FROM role_changes WHERE role_changes.user_id = users.id);
@@ -0,0 +1,9 @@
+-- The built-in roles (admin, user, warehouse, support) are inserted by the server at startup
+CREATE TABLE IF NOT EXISTS roles (
+    name VARCHAR(20) PRIMARY KEY,
+    created_at TIMESTAMP WITH TIME ZONE,
+    updated_at TIMESTAMP WITH TIME ZONE,
+    description VARCHAR(255),
+    permissions TEXT,
+    built_in BOOLEAN NOT NULL DEFAULT FALSE
+);
@@ -0,0 +1,10 @@
+// Package migrations embeds the SQL schema migrations so the binary applies them
+// wherever it is started from
+package migrations
+
+import "embed"
+
+// FS holds every migration, named NNNNNN_description.{up,down}.sql at its root
+//
+//go:embed *.sql
+var FS embed.FS
@@ -2,22 +2,48 @@ package models
 
 import (
 	"time"
+
+	"gorm.io/gorm"
 )
 
 // Book represents a book in the system
 type Book struct {
-	ID        uint       `json:"id" gorm:"primarykey"`
-	CreatedAt time.Time  `json:"created_at"`
-	UpdatedAt time.Time  `json:"updated_at"`
-	DeletedAt *time.Time `json:"deleted_at,omitempty" gorm:"index" format:"date-time"`
-	Title     string     `json:"title" binding:"required" gorm:"not null" example:"The Go Programming Language"`
-	Author    string     `json:"author" binding:"required" gorm:"not null" example:"Alan A. A. Donovan"`
-	Price     float64    `json:"price" binding:"required" gorm:"not null" example:"29.99"`
+	ID        uint           `json:"id" gorm:"primarykey"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `json:"deleted_at,omitempty" gorm:"index" swaggertype:"string" format:"date-time"`
+	Title     string         `json:"title" binding:"required" gorm:"not null" example:"The Go Programming Language"`
+	Author    string         `json:"author" binding:"required" gorm:"not null" example:"Alan A. A. Donovan"`
+	Price     float64        `json:"price" binding:"required" gorm:"not null" example:"29.99"`
+	Stock     int            `json:"stock" gorm:"not null;default:0;check:chk_books_stock,stock >= 0" example:"12"`
 }
 
 // CreateBookRequest represents the request body for creating a book
 type CreateBookRequest struct {
 	Title  string  `json:"title" binding:"required" example:"The Go Programming Language"`
 	Author string  `json:"author" binding:"required" example:"Alan A. A. Donovan"`
-	Price  float64 `json:"price" binding:"required" example:"29.99"`
+	Price  float64 `json:"price" binding:"required,gt=0" example:"29.99"`
+}
+
+// UpdateBookRequest represents the request body for replacing a book
+type UpdateBookRequest struct {
+	Title  string  `json:"title" binding:"required" example:"The Go Programming Language"`
+	Author string  `json:"author" binding:"required" example:"Alan A. A. Donovan"`
+	Price  float64 `json:"price" binding:"required,gt=0" example:"29.99"`
+}
+
+// PatchBookRequest represents a JSON merge patch (RFC 7386) for a book.
+// Omitted fields are left unchanged; present fields follow the same rules as CreateBookRequest.
+type PatchBookRequest struct {
+	Title  *string  `json:"title,omitempty" binding:"omitempty,min=1" example:"The Go Programming Language"`
+	Author *string  `json:"author,omitempty" binding:"omitempty,min=1" example:"Alan A. A. Donovan"`
+	Price  *float64 `json:"price,omitempty" binding:"omitempty,gt=0" example:"34.99"`
+}
+
+// BookSearchResult is a book matched by full-text search, with its relevance and highlighted fields
+type BookSearchResult struct {
+	Book
+	Rank            float64 `json:"rank" example:"0.85"`
+	TitleHighlight  string  `json:"title_highlight" example:"The <mark>Go</mark> Programming Language"`
+	AuthorHighlight string  `json:"author_highlight" example:"Alan A. A. <mark>Donovan</mark>"`
 }
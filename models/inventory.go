@@ -0,0 +1,38 @@
+package models
+
+import (
+	"time"
+)
+
+// StockReason explains why a book's stock changed
+type StockReason string
+
+const (
+	StockReasonOrderPlaced    StockReason = "order_placed"
+	StockReasonOrderCancelled StockReason = "order_cancelled"
+	StockReasonRestock        StockReason = "restock"
+	StockReasonReturn         StockReason = "return"
+	StockReasonDamaged        StockReason = "damaged"
+	StockReasonLost           StockReason = "lost"
+	StockReasonCorrection     StockReason = "correction"
+)
+
+// StockMovement is an entry in the stock ledger; every change to Book.Stock records one
+type StockMovement struct {
+	ID           uint        `json:"id" gorm:"primarykey"`
+	CreatedAt    time.Time   `json:"created_at"`
+	BookID       uint        `json:"book_id" gorm:"not null;index" example:"1"`
+	OrderID      *uint       `json:"order_id,omitempty" gorm:"index" example:"7"`
+	ActorID      *uint       `json:"actor_id,omitempty" example:"1"`
+	Delta        int         `json:"delta" gorm:"not null" example:"-2"`
+	BalanceAfter int         `json:"balance_after" gorm:"not null" example:"10"`
+	Reason       StockReason `json:"reason" gorm:"type:varchar(32);not null" example:"order_placed"`
+	Note         string      `json:"note,omitempty" example:"Delivery from distributor"`
+}
+
+// AdjustStockRequest represents a manual stock adjustment by an admin
+type AdjustStockRequest struct {
+	Delta  int         `json:"delta" binding:"required" example:"10"`
+	Reason StockReason `json:"reason" binding:"required,oneof=restock return damaged lost correction" example:"restock"`
+	Note   string      `json:"note" binding:"max=255" example:"Delivery from distributor"`
+}
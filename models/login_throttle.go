@@ -0,0 +1,31 @@
+package models
+
+import (
+	"time"
+)
+
+// LoginScope says what a login throttle counts failures for
+type LoginScope string
+
+const (
+	LoginScopeUsername LoginScope = "username"
+	LoginScopeIP       LoginScope = "ip"
+)
+
+// LoginThrottle counts the recent failed logins of a username or client address
+// and, once there were too many, refuses further attempts until LockedUntil
+type LoginThrottle struct {
+	ID            uint       `json:"id" gorm:"primarykey"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+	Scope         LoginScope `json:"scope" gorm:"type:varchar(16);not null;uniqueIndex:idx_login_throttles_scope_subject"`
+	Subject       string     `json:"subject" gorm:"type:varchar(255);not null;uniqueIndex:idx_login_throttles_scope_subject"`
+	Failures      int        `json:"failures" gorm:"not null;default:0"`
+	LastFailureAt time.Time  `json:"last_failure_at" gorm:"not null;index"`
+	LockedUntil   *time.Time `json:"locked_until,omitempty"`
+}
+
+// IsLocked reports whether logins are refused at t
+func (lt LoginThrottle) IsLocked(t time.Time) bool {
+	return lt.LockedUntil != nil && t.Before(*lt.LockedUntil)
+}
@@ -0,0 +1,53 @@
+package models
+
+import (
+	"time"
+)
+
+// RecoveryCode is a single-use code that stands in for the authenticator when it
+// is lost. Only its SHA-256 hash is stored.
+type RecoveryCode struct {
+	ID        uint       `json:"id" gorm:"primarykey"`
+	CreatedAt time.Time  `json:"created_at"`
+	UserID    uint       `json:"user_id" gorm:"not null;index"`
+	CodeHash  string     `json:"-" gorm:"type:varchar(64);not null"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+}
+
+// TOTPEnrollment is what an authenticator app needs to generate codes for the account
+type TOTPEnrollment struct {
+	Secret string `json:"secret" example:"JBSWY3DPEHPK3PXPJBSWY3DPEHPK3PXP"`
+	// ProvisioningURI is usually shown as a QR code for the app to scan
+	ProvisioningURI string `json:"provisioning_uri" example:"otpauth://totp/Book%20Order%20App:johndoe?algorithm=SHA1&digits=6&issuer=Book%20Order%20App&period=30&secret=JBSWY3DPEHPK3PXPJBSWY3DPEHPK3PXP"`
+}
+
+// MFACodeRequest carries a code from the authenticator app or a recovery code
+type MFACodeRequest struct {
+	Code string `json:"code" binding:"required,max=32" example:"123456"`
+}
+
+// MFAEnabledResponse is returned once when MFA is turned on: a new session, since
+// the old ones are revoked, and the recovery codes, which are never shown again
+type MFAEnabledResponse struct {
+	AuthResponse
+	RecoveryCodes []string `json:"recovery_codes" example:"k3x9m-q2w7p,7hv4c-n8r2t"`
+}
+
+// RecoveryCodesResponse lists freshly generated recovery codes, shown only this once
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes" example:"k3x9m-q2w7p,7hv4c-n8r2t"`
+}
+
+// MFAChallengeResponse is the answer to a correct password on an account with MFA:
+// the session starts once the challenge token is sent back with a code
+type MFAChallengeResponse struct {
+	MFARequired bool   `json:"mfa_required" example:"true"`
+	MFAToken    string `json:"mfa_token" example:"q8v0m3Yx6f..."`
+	ExpiresIn   int    `json:"expires_in" example:"300"`
+}
+
+// MFALoginRequest completes a two-step login
+type MFALoginRequest struct {
+	MFAToken string `json:"mfa_token" binding:"required" example:"q8v0m3Yx6f..."`
+	Code     string `json:"code" binding:"required,max=32" example:"123456"`
+}
@@ -1,23 +1,137 @@
 package models
 
 import (
+	"math"
 	"time"
+
+	"gorm.io/gorm"
+)
+
+// OrderStatus is the lifecycle state of an order
+type OrderStatus string
+
+const (
+	OrderStatusPending   OrderStatus = "pending"
+	OrderStatusPaid      OrderStatus = "paid"
+	OrderStatusFulfilled OrderStatus = "fulfilled"
+	OrderStatusShipped   OrderStatus = "shipped"
+	OrderStatusDelivered OrderStatus = "delivered"
+	OrderStatusCancelled OrderStatus = "cancelled"
+	OrderStatusRefunded  OrderStatus = "refunded"
 )
 
+// HoldsStock reports whether an order in this status still has its stock reserved.
+// Shipped and delivered orders have consumed it; cancelled and refunded ones released it.
+func (s OrderStatus) HoldsStock() bool {
+	return s == OrderStatusPending || s == OrderStatusPaid || s == OrderStatusFulfilled
+}
+
+// orderTransitions is the order lifecycle: the statuses each status may move to
+var orderTransitions = map[OrderStatus][]OrderStatus{
+	OrderStatusPending:   {OrderStatusPaid, OrderStatusCancelled},
+	OrderStatusPaid:      {OrderStatusFulfilled, OrderStatusCancelled, OrderStatusRefunded},
+	OrderStatusFulfilled: {OrderStatusShipped, OrderStatusCancelled, OrderStatusRefunded},
+	OrderStatusShipped:   {OrderStatusDelivered},
+	OrderStatusDelivered: {OrderStatusRefunded},
+	OrderStatusCancelled: {},
+	OrderStatusRefunded:  {},
+}
+
+// ClosedOrderStatuses are the statuses an order ends its lifecycle in; once delivered
+// an order can only be refunded, which moves no more goods
+var ClosedOrderStatuses = []OrderStatus{OrderStatusDelivered, OrderStatusCancelled, OrderStatusRefunded}
+
+// IsOpen reports whether an order in this status is still under way
+func (s OrderStatus) IsOpen() bool {
+	for _, closed := range ClosedOrderStatuses {
+		if s == closed {
+			return false
+		}
+	}
+	return true
+}
+
+// CanTransitionTo reports whether the lifecycle allows moving an order from this status to another
+func (s OrderStatus) CanTransitionTo(to OrderStatus) bool {
+	for _, next := range orderTransitions[s] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
 // Order represents an order in the system
 type Order struct {
-	ID           uint       `json:"id" gorm:"primarykey"`
-	CreatedAt    time.Time  `json:"created_at"`
-	UpdatedAt    time.Time  `json:"updated_at"`
-	DeletedAt    *time.Time `json:"deleted_at,omitempty" gorm:"index" format:"date-time"`
-	BookID       uint       `json:"book_id" binding:"required" gorm:"not null" example:"1"`
-	CustomerName string     `json:"customer_name" binding:"required" gorm:"not null" example:"John Doe"`
-	Quantity     int        `json:"quantity" binding:"required" gorm:"not null" example:"2"`
+	ID           uint           `json:"id" gorm:"primarykey"`
+	CreatedAt    time.Time      `json:"created_at"`
+	UpdatedAt    time.Time      `json:"updated_at"`
+	DeletedAt    gorm.DeletedAt `json:"deleted_at,omitempty" gorm:"index" swaggertype:"string" format:"date-time"`
+	UserID       *uint          `json:"user_id,omitempty" gorm:"index" example:"1"`
+	CustomerName string         `json:"customer_name" gorm:"not null" example:"johndoe"`
+	Status       OrderStatus    `json:"status" gorm:"type:varchar(20);not null;default:'pending';index" example:"pending"`
+	Subtotal     float64        `json:"subtotal" gorm:"not null;default:0" example:"59.98"`
+	Total        float64        `json:"total" gorm:"not null;default:0" example:"59.98"`
+	Items        []OrderItem    `json:"items" gorm:"constraint:OnDelete:CASCADE"`
 }
 
-// CreateOrderRequest represents the request body for creating an order
+// OrderItem is one line of an order, capturing the book's price at order time
+type OrderItem struct {
+	ID        uint      `json:"id" gorm:"primarykey"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	OrderID   uint      `json:"order_id" gorm:"not null;index" example:"1"`
+	BookID    uint      `json:"book_id" gorm:"not null;index" example:"1"`
+	Quantity  int       `json:"quantity" gorm:"not null" example:"2"`
+	UnitPrice float64   `json:"unit_price" gorm:"not null" example:"29.99"`
+	LineTotal float64   `json:"line_total" gorm:"not null" example:"59.98"`
+}
+
+// OrderStatusChange is an entry in an order's status history
+type OrderStatusChange struct {
+	ID         uint        `json:"id" gorm:"primarykey"`
+	CreatedAt  time.Time   `json:"created_at"`
+	OrderID    uint        `json:"order_id" gorm:"not null;index" example:"1"`
+	FromStatus OrderStatus `json:"from_status,omitempty" gorm:"type:varchar(20)" example:"fulfilled"`
+	ToStatus   OrderStatus `json:"to_status" gorm:"type:varchar(20);not null" example:"shipped"`
+	ChangedBy  *uint       `json:"changed_by,omitempty" example:"1"`
+	Note       string      `json:"note,omitempty" example:"Tracking number 1Z999"`
+}
+
+// CalculateTotals fills in every line total and the order's subtotal and total from the unit prices
+func (o *Order) CalculateTotals() {
+	var subtotal float64
+	for i := range o.Items {
+		o.Items[i].LineTotal = roundCents(o.Items[i].UnitPrice * float64(o.Items[i].Quantity))
+		subtotal += o.Items[i].LineTotal
+	}
+	o.Subtotal = roundCents(subtotal)
+	o.Total = o.Subtotal
+}
+
+func roundCents(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
+
+// CreateOrderItemRequest represents one line of an order request
+type CreateOrderItemRequest struct {
+	BookID   uint `json:"book_id" binding:"required" example:"1"`
+	Quantity int  `json:"quantity" binding:"required,min=1" example:"2"`
+}
+
+// CreateOrderRequest represents the request body for creating an order.
+// The customer is always the authenticated user.
 type CreateOrderRequest struct {
-	BookID       uint   `json:"book_id" binding:"required" example:"1"`
-	CustomerName string `json:"customer_name" binding:"required" example:"John Doe"`
-	Quantity     int    `json:"quantity" binding:"required,min=1" example:"2"`
+	Items []CreateOrderItemRequest `json:"items" binding:"required,min=1,unique=BookID,dive"`
+}
+
+// OrderTransitionRequest carries an optional note for a status change
+type OrderTransitionRequest struct {
+	Note string `json:"note" binding:"max=255" example:"Customer asked to cancel"`
+}
+
+// UpdateOrderStatusRequest represents an admin status change to any allowed next status
+type UpdateOrderStatusRequest struct {
+	Status OrderStatus `json:"status" binding:"required,oneof=pending paid fulfilled shipped delivered cancelled refunded" example:"paid"`
+	Note   string      `json:"note" binding:"max=255" example:"Payment captured"`
 }
@@ -0,0 +1,91 @@
+package models
+
+import (
+	"slices"
+	"time"
+)
+
+// Permissions name the staff actions a role may grant. Users need none for their
+// own account and orders.
+const (
+	PermBooksWrite     = "books:write"
+	PermInventoryRead  = "inventory:read"
+	PermInventoryWrite = "inventory:write"
+	PermOrdersReadAny  = "orders:read:any"
+	PermOrdersFulfill  = "orders:fulfill"
+	PermOrdersRefund   = "orders:refund"
+	PermOrdersManage   = "orders:manage"
+	PermUsersRead      = "users:read"
+	PermUsersManage    = "users:manage"
+	PermRolesManage    = "roles:manage"
+)
+
+// Permission describes one permission
+type Permission struct {
+	Name        string `json:"name" example:"orders:refund"`
+	Description string `json:"description" example:"Refund paid, fulfilled and delivered orders"`
+}
+
+// Permissions lists every permission a role can grant
+var Permissions = []Permission{
+	{PermBooksWrite, "Create, change, delete and restore books"},
+	{PermInventoryRead, "Read the stock ledger of books"},
+	{PermInventoryWrite, "Adjust the stock of books"},
+	{PermOrdersReadAny, "Read every user's orders and their status history"},
+	{PermOrdersFulfill, "Ship orders and mark them delivered"},
+	{PermOrdersRefund, "Refund paid, fulfilled and delivered orders"},
+	{PermOrdersManage, "Cancel any order, set any status the lifecycle allows, delete and restore orders"},
+	{PermUsersRead, "List users and read their role history"},
+	{PermUsersManage, "Create, disable, delete and restore users, change their roles, reset their credentials and revoke tokens"},
+	{PermRolesManage, "Define roles and the permissions they grant"},
+}
+
+// PermissionNames returns the name of every permission
+func PermissionNames() []string {
+	names := make([]string, len(Permissions))
+	for i, p := range Permissions {
+		names[i] = p.Name
+	}
+	return names
+}
+
+// BuiltInRoles are created at startup and cannot be deleted. The admin role always
+// grants every permission; the others can be redefined.
+var BuiltInRoles = []Role{
+	{Name: RoleAdmin, Description: "Full access", Permissions: PermissionNames()},
+	{Name: RoleUser, Description: "Customers: their own account and orders", Permissions: []string{}},
+	{Name: RoleWarehouse, Description: "Stock keeping and order fulfillment", Permissions: []string{
+		PermInventoryRead, PermInventoryWrite, PermOrdersReadAny, PermOrdersFulfill,
+	}},
+	{Name: RoleSupport, Description: "Customer support", Permissions: []string{
+		PermOrdersReadAny, PermOrdersRefund, PermUsersRead,
+	}},
+}
+
+// Role is a named set of permissions that users are given
+type Role struct {
+	Name        UserRole  `json:"name" gorm:"primaryKey;type:varchar(20)" example:"warehouse"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	Description string    `json:"description" gorm:"size:255" example:"Stock keeping and order fulfillment"`
+	Permissions []string  `json:"permissions" gorm:"type:text;serializer:json" example:"inventory:read,inventory:write"`
+	BuiltIn     bool      `json:"built_in" gorm:"not null;default:false"`
+}
+
+// Grants reports whether the role includes permission
+func (r Role) Grants(permission string) bool {
+	return slices.Contains(r.Permissions, permission)
+}
+
+// CreateRoleRequest defines a new role
+type CreateRoleRequest struct {
+	Name        UserRole `json:"name" binding:"required,max=20" example:"catalog"`
+	Description string   `json:"description" binding:"max=255" example:"Maintains the catalog"`
+	Permissions []string `json:"permissions" binding:"unique,dive,oneof=books:write inventory:read inventory:write orders:read:any orders:fulfill orders:refund orders:manage users:read users:manage roles:manage" example:"books:write,inventory:write"`
+}
+
+// UpdateRoleRequest replaces the description and permissions of a role
+type UpdateRoleRequest struct {
+	Description string   `json:"description" binding:"max=255" example:"Maintains the catalog"`
+	Permissions []string `json:"permissions" binding:"required,unique,dive,oneof=books:write inventory:read inventory:write orders:read:any orders:fulfill orders:refund orders:manage users:read users:manage roles:manage" example:"books:write,inventory:write"`
+}
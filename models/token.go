@@ -0,0 +1,80 @@
+package models
+
+import (
+	"time"
+)
+
+// RefreshToken is a rotating refresh token. Only its SHA-256 hash is stored.
+// Tokens issued from the same login share a FamilyID so a replayed token can
+// revoke every token descended from it.
+type RefreshToken struct {
+	ID           uint       `json:"id" gorm:"primarykey"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UserID       uint       `json:"user_id" gorm:"not null;index"`
+	FamilyID     string     `json:"family_id" gorm:"type:varchar(64);not null;index"`
+	TokenHash    string     `json:"-" gorm:"type:varchar(64);not null;uniqueIndex"`
+	ExpiresAt    time.Time  `json:"expires_at"`
+	RevokedAt    *time.Time `json:"revoked_at,omitempty"`
+	ReplacedByID *uint      `json:"replaced_by_id,omitempty"`
+}
+
+// RevokedToken is a denylist entry for an access token id (jti) or a whole session id,
+// kept until every token it covers has expired anyway
+type RevokedToken struct {
+	ID        uint      `json:"id" gorm:"primarykey"`
+	CreatedAt time.Time `json:"created_at"`
+	TokenID   string    `json:"token_id" gorm:"type:varchar(64);not null;uniqueIndex"`
+	ExpiresAt time.Time `json:"expires_at" gorm:"not null;index"`
+}
+
+// TokenPurpose says what a one-time token may be used for
+type TokenPurpose string
+
+const (
+	PurposePasswordReset     TokenPurpose = "password_reset"
+	PurposeEmailVerification TokenPurpose = "email_verification"
+	PurposeMFAChallenge      TokenPurpose = "mfa_challenge"
+)
+
+// OneTimeToken is a single-use token sent to a user out of band, e.g. by email.
+// Only its SHA-256 hash is stored.
+type OneTimeToken struct {
+	ID        uint         `json:"id" gorm:"primarykey"`
+	CreatedAt time.Time    `json:"created_at"`
+	UserID    uint         `json:"user_id" gorm:"not null;index"`
+	Purpose   TokenPurpose `json:"purpose" gorm:"type:varchar(32);not null"`
+	TokenHash string       `json:"-" gorm:"type:varchar(64);not null;uniqueIndex"`
+	ExpiresAt time.Time    `json:"expires_at" gorm:"not null;index"`
+	UsedAt    *time.Time   `json:"used_at,omitempty"`
+}
+
+// ForgotPasswordRequest asks for a password reset link to be mailed to an account
+type ForgotPasswordRequest struct {
+	Username string `json:"username" binding:"required" example:"johndoe"`
+}
+
+// MessageResponse is a response that only carries a human readable message
+type MessageResponse struct {
+	Message string `json:"message" example:"If the account exists and has an email address, a password reset link has been sent to it"`
+}
+
+// ResetPasswordRequest completes a password reset with the token from the email
+type ResetPasswordRequest struct {
+	Token       string `json:"token" binding:"required" example:"q8v0m3Yx6f..."`
+	NewPassword string `json:"new_password" binding:"required" example:"correct horse battery staple"`
+}
+
+// VerifyEmailRequest confirms an email address with the token from the verification mail
+type VerifyEmailRequest struct {
+	Token string `json:"token" binding:"required" example:"q8v0m3Yx6f..."`
+}
+
+// RefreshRequest represents the token refresh request payload
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required" example:"q8v0m3Yx6f..."`
+}
+
+// RevokeTokenRequest represents an admin request to kill a single access token
+type RevokeTokenRequest struct {
+	JTI string `json:"jti" binding:"required,max=64" example:"4f1c2a9b0d7e4e1f8a3b6c5d2e1f0a9b"`
+}
@@ -7,23 +7,71 @@ import (
 	"gorm.io/gorm"
 )
 
-// UserRole represents the role of a user
+// UserRole names the role of a user, one of the roles defined in the roles table
 type UserRole string
 
+// The built-in roles; see BuiltInRoles
 const (
-	RoleAdmin UserRole = "admin"
-	RoleUser  UserRole = "user"
+	RoleAdmin     UserRole = "admin"
+	RoleUser      UserRole = "user"
+	RoleWarehouse UserRole = "warehouse"
+	RoleSupport   UserRole = "support"
 )
 
 // User represents a user in the system
 type User struct {
-	ID        uint       `json:"id" gorm:"primarykey"`
-	CreatedAt time.Time  `json:"created_at"`
-	UpdatedAt time.Time  `json:"updated_at"`
-	DeletedAt *time.Time `json:"deleted_at,omitempty" gorm:"index" format:"date-time"`
-	Username  string     `json:"username" binding:"required" gorm:"uniqueIndex;not null" example:"johndoe"`
-	Password  string     `json:"-" gorm:"not null"` // "-" means this field won't be included in JSON responses
-	Role      UserRole   `json:"role" gorm:"type:varchar(20);not null;default:'user'" example:"user"`
+	ID              uint           `json:"id" gorm:"primarykey"`
+	CreatedAt       time.Time      `json:"created_at"`
+	UpdatedAt       time.Time      `json:"updated_at"`
+	DeletedAt       gorm.DeletedAt `json:"deleted_at,omitempty" gorm:"index" swaggertype:"string" format:"date-time"`
+	Username        string         `json:"username" binding:"required" gorm:"uniqueIndex;not null" example:"johndoe"`
+	Password        string         `json:"-" gorm:"not null"` // "-" means this field won't be included in JSON responses
+	Role            UserRole       `json:"role" gorm:"type:varchar(20);not null;default:'user'" example:"user"`
+	DisplayName     string         `json:"display_name,omitempty" gorm:"size:100" example:"John Doe"`
+	Email           *string        `json:"email,omitempty" gorm:"size:254;uniqueIndex" example:"john@example.com"`
+	ShippingAddress string         `json:"shipping_address,omitempty" example:"221B Baker Street, London NW1 6XE"`
+	// EmailVerifiedAt is set once the user followed the verification link sent to Email,
+	// and cleared whenever Email changes
+	EmailVerifiedAt *time.Time `json:"email_verified_at,omitempty" swaggertype:"string" format:"date-time"`
+	// DisabledAt is set while an admin has disabled the account; disabled accounts cannot sign in
+	DisabledAt *time.Time `json:"disabled_at,omitempty" swaggertype:"string" format:"date-time"`
+	// PasswordResetRequired is set when an admin reset the password. Until the user
+	// chooses a new one, their sessions can only change the password.
+	PasswordResetRequired bool `json:"password_reset_required,omitempty" gorm:"not null;default:false"`
+	// MFAEnabledAt is set once the user confirmed a TOTP authenticator; logins then need a code
+	MFAEnabledAt *time.Time `json:"mfa_enabled_at,omitempty" swaggertype:"string" format:"date-time"`
+	// TOTPSecret is the shared authenticator secret, set from the start of enrollment
+	TOTPSecret string `json:"-" gorm:"size:64"`
+	// TOTPLastStep is the time step of the last code accepted, so no code is accepted twice
+	TOTPLastStep int64 `json:"-" gorm:"not null;default:0"`
+}
+
+// IsDisabled reports whether an admin has disabled the account
+func (u *User) IsDisabled() bool {
+	return u.DisabledAt != nil
+}
+
+// MFAEnabled reports whether signing in needs a second factor
+func (u *User) MFAEnabled() bool {
+	return u.MFAEnabledAt != nil
+}
+
+// IsEmailVerified reports whether the user proved to own their current email address
+func (u *User) IsEmailVerified() bool {
+	return u.Email != nil && u.EmailVerifiedAt != nil
+}
+
+// RoleChange is an entry in a user's role history. The first entry of every user
+// records the role it was created with.
+type RoleChange struct {
+	ID        uint      `json:"id" gorm:"primarykey"`
+	CreatedAt time.Time `json:"created_at"`
+	UserID    uint      `json:"user_id" gorm:"not null;index" example:"2"`
+	FromRole  UserRole  `json:"from_role,omitempty" gorm:"type:varchar(20)" example:"user"`
+	ToRole    UserRole  `json:"to_role" gorm:"type:varchar(20);not null" example:"admin"`
+	// ChangedBy is the admin who made the change; empty for self-registration and the bootstrap admin
+	ChangedBy *uint  `json:"changed_by,omitempty" example:"1"`
+	Note      string `json:"note,omitempty" example:"created by an admin"`
 }
 
 // HashPassword hashes the user's password before saving
@@ -52,15 +100,52 @@ type LoginRequest struct {
 	Password string `json:"password" binding:"required" example:"password123"`
 }
 
-// RegisterRequest represents the registration request payload
+// RegisterRequest represents the registration request payload. Self-registered
+// accounts always get the user role. The password must satisfy the password policy.
 type RegisterRequest struct {
-	Username string   `json:"username" binding:"required" example:"johndoe"`
-	Password string   `json:"password" binding:"required,min=6" example:"password123"`
-	Role     UserRole `json:"role" binding:"required,oneof=admin user" example:"user"`
+	Username string `json:"username" binding:"required" example:"johndoe"`
+	Password string `json:"password" binding:"required" example:"password123"`
+	// Email is optional; a verification link is mailed to it
+	Email string `json:"email,omitempty" binding:"omitempty,max=254" example:"john@example.com"`
+}
+
+// CreateUserRequest is the payload an admin sends to create an account with any role
+type CreateUserRequest struct {
+	Username string   `json:"username" binding:"required" example:"janedoe"`
+	Password string   `json:"password" binding:"required" example:"password123"`
+	Role     UserRole `json:"role" binding:"required,max=20" example:"warehouse"`
+}
+
+// UpdateProfileRequest is a JSON merge patch (RFC 7386) of the fields users edit
+// themselves. Omitted fields are left unchanged; an empty string clears a field.
+type UpdateProfileRequest struct {
+	DisplayName     *string `json:"display_name,omitempty" binding:"omitempty,max=100" example:"John Doe"`
+	Email           *string `json:"email,omitempty" binding:"omitempty,max=254" example:"john@example.com"`
+	ShippingAddress *string `json:"shipping_address,omitempty" binding:"omitempty,max=500" example:"221B Baker Street, London NW1 6XE"`
+}
+
+// ChangePasswordRequest is the payload a user sends to choose a new password
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" binding:"required" example:"password123"`
+	NewPassword     string `json:"new_password" binding:"required" example:"correct horse battery staple"`
+}
+
+// ChangeRoleRequest is the payload an admin sends to change a user's role
+type ChangeRoleRequest struct {
+	Role UserRole `json:"role" binding:"required,max=20" example:"warehouse"`
+	Note string   `json:"note" binding:"max=500" example:"Moved to the warehouse team"`
+}
+
+// PasswordResetResponse carries the one-time password an admin reset left the account with
+type PasswordResetResponse struct {
+	TemporaryPassword string `json:"temporary_password" example:"Xb3k9QmZ2pLr"`
+	User              User   `json:"user"`
 }
 
 // AuthResponse represents the authentication response
 type AuthResponse struct {
-	Token string `json:"token" example:"eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9..."`
-	User  User   `json:"user"`
+	Token        string `json:"token,omitempty" example:"eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9..."`
+	ExpiresIn    int    `json:"expires_in,omitempty" example:"900"`
+	RefreshToken string `json:"refresh_token,omitempty" example:"q8v0m3Yx6f..."`
+	User         User   `json:"user"`
 }
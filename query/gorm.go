@@ -0,0 +1,123 @@
+package query
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const idColumn = "id"
+
+// Find runs a paginated list query for T, counting the filtered total and
+// fetching one extra row to know whether a next cursor is needed.
+func Find[T any](db *gorm.DB, p Params) (Page[T], error) {
+	base := db.Model(new(T))
+	for _, f := range p.Filters {
+		expr := fmt.Sprintf("%s %s ?", f.Column, f.Op)
+		switch {
+		case f.Expr != "":
+			expr = f.Expr
+		case f.Op == Contains:
+			expr = fmt.Sprintf(`%s ILIKE ? ESCAPE '\'`, f.Column)
+		}
+		base = base.Where(expr, f.Value)
+	}
+	base = base.Session(&gorm.Session{})
+
+	var total int64
+	if err := base.Count(&total).Error; err != nil {
+		return Page[T]{}, err
+	}
+
+	q := base
+	if p.after != nil {
+		expr, args := keysetCondition(p.Sort, p.after)
+		q = q.Where(expr, args...)
+	} else if p.Offset > 0 {
+		q = q.Offset(p.Offset)
+	}
+	for _, s := range orderFields(p.Sort) {
+		q = q.Order(clause.OrderByColumn{Column: clause.Column{Name: s.Column}, Desc: s.Desc})
+	}
+
+	items := make([]T, 0, p.Limit+1)
+	if err := q.Limit(p.Limit + 1).Find(&items).Error; err != nil {
+		return Page[T]{}, err
+	}
+
+	page := Page[T]{Total: total, Limit: p.Limit, Offset: p.Offset}
+	if len(items) > p.Limit {
+		items = items[:p.Limit]
+		cursor, err := cursorFor(db, p.Sort, items[len(items)-1])
+		if err != nil {
+			return Page[T]{}, err
+		}
+		page.NextCursor = cursor
+	}
+	page.Data = items
+	return page, nil
+}
+
+// orderFields appends the id tiebreaker so that keyset pagination is stable
+func orderFields(sorts []SortField) []SortField {
+	for _, s := range sorts {
+		if s.Column == idColumn {
+			return sorts
+		}
+	}
+	return append(append([]SortField{}, sorts...), SortField{Name: idColumn, Field: Field{Column: idColumn, Kind: Number}})
+}
+
+// keysetCondition expands (c1, c2, id) > (v1, v2, v3) into OR-ed prefixes so each
+// column can have its own direction.
+func keysetCondition(sorts []SortField, after []interface{}) (string, []interface{}) {
+	fields := append(append([]SortField{}, sorts...), SortField{Field: Field{Column: idColumn}})
+	var (
+		ors  []string
+		args []interface{}
+	)
+	for i, f := range fields {
+		var ands []string
+		for j := 0; j < i; j++ {
+			ands = append(ands, fields[j].Column+" = ?")
+			args = append(args, after[j])
+		}
+		op := ">"
+		if f.Desc {
+			op = "<"
+		}
+		ands = append(ands, fmt.Sprintf("%s %s ?", f.Column, op))
+		args = append(args, after[i])
+		ors = append(ors, "("+strings.Join(ands, " AND ")+")")
+	}
+	return "(" + strings.Join(ors, " OR ") + ")", args
+}
+
+// cursorFor reads the sort column values of the last row on a page through the GORM schema
+func cursorFor[T any](db *gorm.DB, sorts []SortField, last T) (string, error) {
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(&last); err != nil {
+		return "", err
+	}
+
+	row := reflect.ValueOf(&last).Elem()
+	columns := make([]string, 0, len(sorts)+1)
+	for _, s := range sorts {
+		columns = append(columns, s.Column)
+	}
+	columns = append(columns, idColumn)
+
+	values := make([]interface{}, len(columns))
+	for i, column := range columns {
+		field := stmt.Schema.LookUpField(column)
+		if field == nil {
+			return "", fmt.Errorf("unknown column %q", column)
+		}
+		values[i], _ = field.ValueOf(context.Background(), row)
+	}
+	return encodeCursor(sorts, values)
+}
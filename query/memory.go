@@ -0,0 +1,216 @@
+package query
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm/schema"
+)
+
+// Matcher evaluates a filter that has an Expr against an in-memory record
+type Matcher[T any] func(item T, value interface{}) bool
+
+var schemaCache sync.Map
+
+// Slice runs a list query for T against records held in memory, with the same
+// filtering, ordering, pagination and cursors as Find. Columns are resolved through
+// the GORM schema so they mean the same as in SQL. Filters with an Expr cannot be
+// evaluated here; matchers supplies a predicate for each of them by filter name.
+func Slice[T any](items []T, p Params, matchers map[string]Matcher[T]) (Page[T], error) {
+	s, err := schema.Parse(new(T), &schemaCache, schema.NamingStrategy{})
+	if err != nil {
+		return Page[T]{}, err
+	}
+
+	var filtered []T
+	for _, item := range items {
+		ok, err := matches(s, item, p.Filters, matchers)
+		if err != nil {
+			return Page[T]{}, err
+		}
+		if ok {
+			filtered = append(filtered, item)
+		}
+	}
+	total := int64(len(filtered))
+
+	order := orderFields(p.Sort)
+	sort.SliceStable(filtered, func(i, j int) bool {
+		return compareRows(order, rowValues(s, filtered[i], order), rowValues(s, filtered[j], order)) < 0
+	})
+
+	// Cursors hold the sort values plus the id tiebreaker
+	keys := append(append([]SortField{}, p.Sort...), SortField{Field: Field{Column: idColumn, Kind: Number}})
+	if p.after != nil {
+		start := len(filtered)
+		for i, item := range filtered {
+			if compareRows(keys, rowValues(s, item, keys), p.after) > 0 {
+				start = i
+				break
+			}
+		}
+		filtered = filtered[start:]
+	} else if p.Offset > 0 {
+		if p.Offset >= len(filtered) {
+			filtered = nil
+		} else {
+			filtered = filtered[p.Offset:]
+		}
+	}
+
+	page := Page[T]{Total: total, Limit: p.Limit, Offset: p.Offset, Data: make([]T, 0, p.Limit)}
+	if len(filtered) > p.Limit {
+		filtered = filtered[:p.Limit]
+		cursor, err := encodeCursor(p.Sort, rowValues(s, filtered[len(filtered)-1], keys))
+		if err != nil {
+			return Page[T]{}, err
+		}
+		page.NextCursor = cursor
+	}
+	page.Data = append(page.Data, filtered...)
+	return page, nil
+}
+
+func matches[T any](s *schema.Schema, item T, conditions []Condition, matchers map[string]Matcher[T]) (bool, error) {
+	for _, c := range conditions {
+		if c.Expr != "" {
+			match, ok := matchers[c.Name]
+			if !ok {
+				return false, fmt.Errorf("filter %q has no in-memory matcher", c.Name)
+			}
+			if !match(item, c.Value) {
+				return false, nil
+			}
+			continue
+		}
+
+		field := s.LookUpField(c.Column)
+		if field == nil {
+			return false, fmt.Errorf("unknown column %q", c.Column)
+		}
+		value := columnValue(field, item)
+		if value == nil {
+			return false, nil // comparisons with NULL never match
+		}
+
+		if c.Op == Contains {
+			pattern, _ := c.Value.(string)
+			if !strings.Contains(strings.ToLower(fmt.Sprint(value)), strings.ToLower(unescapeLike(pattern))) {
+				return false, nil
+			}
+			continue
+		}
+
+		cmp := compareValues(value, c.Value)
+		var ok bool
+		switch c.Op {
+		case Eq:
+			ok = cmp == 0
+		case Gt:
+			ok = cmp > 0
+		case Gte:
+			ok = cmp >= 0
+		case Lt:
+			ok = cmp < 0
+		case Lte:
+			ok = cmp <= 0
+		}
+		if !ok {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func rowValues[T any](s *schema.Schema, item T, fields []SortField) []interface{} {
+	values := make([]interface{}, len(fields))
+	for i, f := range fields {
+		if field := s.LookUpField(f.Column); field != nil {
+			values[i] = columnValue(field, item)
+		}
+	}
+	return values
+}
+
+// compareRows orders two rows by fields, honouring each field's direction.
+// NULLs sort last ascending and first descending, as in PostgreSQL.
+func compareRows(fields []SortField, a, b []interface{}) int {
+	for i, f := range fields {
+		var cmp int
+		switch {
+		case a[i] == nil && b[i] == nil:
+			cmp = 0
+		case a[i] == nil:
+			cmp = 1
+		case b[i] == nil:
+			cmp = -1
+		default:
+			cmp = compareValues(a[i], b[i])
+		}
+		if f.Desc {
+			cmp = -cmp
+		}
+		if cmp != 0 {
+			return cmp
+		}
+	}
+	return 0
+}
+
+// columnValue reads a column of a record, dereferencing pointers; nil stands for NULL
+func columnValue[T any](field *schema.Field, item T) interface{} {
+	value, zero := field.ValueOf(context.Background(), reflect.ValueOf(&item).Elem())
+	rv := reflect.ValueOf(value)
+	if rv.Kind() == reflect.Ptr {
+		if zero || rv.IsNil() {
+			return nil
+		}
+		return rv.Elem().Interface()
+	}
+	return value
+}
+
+// compareValues compares numbers, strings and times regardless of their concrete Go types
+func compareValues(a, b interface{}) int {
+	if ta, ok := a.(time.Time); ok {
+		if tb, ok := b.(time.Time); ok {
+			return ta.Compare(tb)
+		}
+	}
+
+	na, aNum := toFloat(a)
+	nb, bNum := toFloat(b)
+	if aNum && bNum {
+		switch {
+		case na < nb:
+			return -1
+		case na > nb:
+			return 1
+		}
+		return 0
+	}
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), true
+	}
+	return 0, false
+}
+
+func unescapeLike(pattern string) string {
+	pattern = strings.TrimSuffix(strings.TrimPrefix(pattern, "%"), "%")
+	return strings.NewReplacer(`\\`, `\`, `\%`, `%`, `\_`, `_`).Replace(pattern)
+}
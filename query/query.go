@@ -0,0 +1,342 @@
+// Package query implements the shared pagination, sorting and filtering layer
+// used by every list endpoint. Query strings are parsed against a per-resource
+// Spec so that only whitelisted columns ever reach SQL.
+package query
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultLimit = 20
+	maxLimit     = 100
+)
+
+// Kind tells the parser how to convert a raw query value for a column
+type Kind int
+
+const (
+	String Kind = iota
+	Number
+	Time
+)
+
+// Op is the comparison a filter applies to its column
+type Op string
+
+const (
+	Eq       Op = "="
+	Gt       Op = ">"
+	Gte      Op = ">="
+	Lt       Op = "<"
+	Lte      Op = "<="
+	Contains Op = "ILIKE"
+)
+
+// Field is a sortable column
+type Field struct {
+	Column string
+	Kind   Kind
+}
+
+// Filter binds a query parameter to a comparison on a column. When Expr is set
+// it is used verbatim as the WHERE clause, with a single ? for the value, for
+// filters that need to look at related tables.
+type Filter struct {
+	Column string
+	Kind   Kind
+	Op     Op
+	Expr   string
+}
+
+// Spec whitelists what a list endpoint may sort and filter on
+type Spec struct {
+	Sortable    map[string]Field
+	Filters     map[string]Filter
+	DefaultSort string
+}
+
+// SortField is one parsed entry of the sort parameter
+type SortField struct {
+	Name string
+	Field
+	Desc bool
+}
+
+// Condition is one parsed filter ready to be applied. Name is the query
+// parameter it came from, or the column for conditions added by Restrict.
+type Condition struct {
+	Name   string
+	Column string
+	Op     Op
+	Expr   string
+	Value  interface{}
+}
+
+// Params is the parsed, validated form of a list request
+type Params struct {
+	Limit   int
+	Offset  int
+	Cursor  string
+	Sort    []SortField
+	Filters []Condition
+
+	// after holds the decoded cursor values, one per sort field plus the id tiebreaker
+	after []interface{}
+}
+
+// Page is the response envelope returned by list endpoints
+type Page[T any] struct {
+	Data       []T    `json:"data"`
+	Total      int64  `json:"total" example:"42"`
+	Limit      int    `json:"limit" example:"20"`
+	Offset     int    `json:"offset,omitempty" example:"0"`
+	NextCursor string `json:"next_cursor,omitempty" example:"eyJzIjoiLWNyZWF0ZWRfYXQiLCJ2IjpbXX0"`
+}
+
+// ErrInvalidCursor is returned when a cursor is malformed or was issued for a different sort
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// cursorPayload is what an opaque cursor decodes to
+type cursorPayload struct {
+	Sort   string            `json:"s"`
+	Values []json.RawMessage `json:"v"`
+}
+
+// Parse validates the limit, offset, cursor, sort and filter parameters against spec
+func Parse(values url.Values, spec Spec) (Params, error) {
+	p := Params{Limit: defaultLimit}
+
+	if raw := values.Get("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit < 1 {
+			return Params{}, fmt.Errorf("limit must be a positive integer")
+		}
+		if limit > maxLimit {
+			limit = maxLimit
+		}
+		p.Limit = limit
+	}
+
+	if raw := values.Get("offset"); raw != "" {
+		offset, err := strconv.Atoi(raw)
+		if err != nil || offset < 0 {
+			return Params{}, fmt.Errorf("offset must be a non-negative integer")
+		}
+		p.Offset = offset
+	}
+
+	sortParam := values.Get("sort")
+	if sortParam == "" {
+		sortParam = spec.DefaultSort
+	}
+	sorts, err := parseSort(sortParam, spec)
+	if err != nil {
+		return Params{}, err
+	}
+	p.Sort = sorts
+
+	for name, filter := range spec.Filters {
+		raw := values.Get(name)
+		if raw == "" {
+			continue
+		}
+		value, err := convert(raw, filter.Kind)
+		if err != nil {
+			return Params{}, fmt.Errorf("invalid value for %s: %w", name, err)
+		}
+		if filter.Op == Contains {
+			value = "%" + escapeLike(raw) + "%"
+		}
+		p.Filters = append(p.Filters, Condition{Name: name, Column: filter.Column, Op: filter.Op, Expr: filter.Expr, Value: value})
+	}
+
+	if cursor := values.Get("cursor"); cursor != "" {
+		if p.Offset != 0 {
+			return Params{}, fmt.Errorf("cursor and offset cannot be combined")
+		}
+		after, err := decodeCursor(cursor, p.Sort)
+		if err != nil {
+			return Params{}, err
+		}
+		p.Cursor = cursor
+		p.after = after
+	}
+
+	return p, nil
+}
+
+// Restrict replaces any client-supplied filter on column with an equality filter
+// on value, e.g. to scope a listing to the caller's own records.
+func (p *Params) Restrict(column string, value interface{}) {
+	filters := p.Filters[:0:0]
+	for _, f := range p.Filters {
+		if f.Column != column {
+			filters = append(filters, f)
+		}
+	}
+	p.Filters = append(filters, Condition{Name: column, Column: column, Op: Eq, Value: value})
+}
+
+func parseSort(raw string, spec Spec) ([]SortField, error) {
+	var sorts []SortField
+	seen := map[string]bool{}
+	for _, part := range strings.Split(raw, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		desc := strings.HasPrefix(part, "-")
+		name := strings.TrimPrefix(part, "-")
+		field, ok := spec.Sortable[name]
+		if !ok {
+			return nil, fmt.Errorf("cannot sort by %q", name)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("duplicate sort field %q", name)
+		}
+		seen[name] = true
+		sorts = append(sorts, SortField{Name: name, Field: field, Desc: desc})
+	}
+	return sorts, nil
+}
+
+// sortString renders sorts back into the canonical sort parameter
+func sortString(sorts []SortField) string {
+	parts := make([]string, len(sorts))
+	for i, s := range sorts {
+		if s.Desc {
+			parts[i] = "-" + s.Name
+		} else {
+			parts[i] = s.Name
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+func convert(raw string, kind Kind) (interface{}, error) {
+	switch kind {
+	case Number:
+		if n, err := strconv.ParseInt(raw, 10, 64); err == nil {
+			return n, nil
+		}
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return nil, errors.New("expected a number")
+		}
+		return f, nil
+	case Time:
+		if t, err := time.Parse(time.RFC3339Nano, raw); err == nil {
+			return t, nil
+		}
+		t, err := time.Parse(time.DateOnly, raw)
+		if err != nil {
+			return nil, errors.New("expected an RFC 3339 timestamp or YYYY-MM-DD date")
+		}
+		return t, nil
+	default:
+		return raw, nil
+	}
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+func encodeCursor(sorts []SortField, values []interface{}) (string, error) {
+	payload := cursorPayload{Sort: sortString(sorts)}
+	for _, v := range values {
+		if t, ok := v.(time.Time); ok {
+			v = t.UTC().Format(time.RFC3339Nano)
+		}
+		raw, err := json.Marshal(v)
+		if err != nil {
+			return "", err
+		}
+		payload.Values = append(payload.Values, raw)
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func decodeCursor(cursor string, sorts []SortField) ([]interface{}, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var payload cursorPayload
+	if err := json.Unmarshal(data, &payload); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	if payload.Sort != sortString(sorts) || len(payload.Values) != len(sorts)+1 {
+		return nil, ErrInvalidCursor
+	}
+
+	kinds := make([]Kind, 0, len(sorts)+1)
+	for _, s := range sorts {
+		kinds = append(kinds, s.Kind)
+	}
+	kinds = append(kinds, Number) // id tiebreaker
+
+	values := make([]interface{}, len(payload.Values))
+	for i, raw := range payload.Values {
+		var text string
+		if err := json.Unmarshal(raw, &text); err != nil {
+			text = string(raw)
+		}
+		v, err := convert(text, kinds[i])
+		if err != nil {
+			return nil, ErrInvalidCursor
+		}
+		values[i] = v
+	}
+	return values, nil
+}
+
+// LinkHeader builds an RFC 8288 Link header value for the page that was served from u
+func LinkHeader(u *url.URL, p Params, nextCursor string) string {
+	var links []string
+	link := func(rel string, edit func(q url.Values)) {
+		next := *u
+		q := next.Query()
+		edit(q)
+		next.RawQuery = q.Encode()
+		links = append(links, fmt.Sprintf(`<%s>; rel="%s"`, next.RequestURI(), rel))
+	}
+
+	if nextCursor != "" {
+		link("next", func(q url.Values) {
+			if p.Cursor != "" {
+				q.Set("cursor", nextCursor)
+				return
+			}
+			q.Set("offset", strconv.Itoa(p.Offset+p.Limit))
+		})
+	}
+	if p.Cursor == "" && p.Offset > 0 {
+		link("prev", func(q url.Values) {
+			prev := p.Offset - p.Limit
+			if prev < 0 {
+				prev = 0
+			}
+			q.Set("offset", strconv.Itoa(prev))
+		})
+	}
+	if p.Cursor != "" || p.Offset > 0 {
+		link("first", func(q url.Values) {
+			q.Del("cursor")
+			q.Del("offset")
+		})
+	}
+	return strings.Join(links, ", ")
+}
@@ -0,0 +1,72 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"book_order_app/models"
+
+	"gorm.io/gorm"
+)
+
+// APIKeyRepository stores the API keys users create for their scripts
+type APIKeyRepository interface {
+	CreateAPIKey(ctx context.Context, key *models.APIKey) error
+	// ListAPIKeys returns the keys of a user in creation order, expired ones included
+	ListAPIKeys(ctx context.Context, userID uint) ([]models.APIKey, error)
+	// GetAPIKey finds a key by the hash of its value, expired or not
+	GetAPIKey(ctx context.Context, hash string) (models.APIKey, error)
+	// DeleteAPIKey revokes a key for good. ErrAPIKeyNotFound is returned when the
+	// user holds no key with that id.
+	DeleteAPIKey(ctx context.Context, userID, id uint) error
+	// DeleteUserAPIKeys revokes every key of a user, returning how many there were
+	DeleteUserAPIKeys(ctx context.Context, userID uint) (int64, error)
+	// TouchAPIKey records when a key was last used
+	TouchAPIKey(ctx context.Context, id uint, usedAt time.Time) error
+}
+
+type gormAPIKeyRepository struct {
+	db *gorm.DB
+}
+
+func (r *gormAPIKeyRepository) CreateAPIKey(ctx context.Context, key *models.APIKey) error {
+	return r.db.WithContext(ctx).Create(key).Error
+}
+
+func (r *gormAPIKeyRepository) ListAPIKeys(ctx context.Context, userID uint) ([]models.APIKey, error) {
+	keys := []models.APIKey{}
+	err := r.db.WithContext(ctx).Where("user_id = ?", userID).Order("id").Find(&keys).Error
+	return keys, err
+}
+
+func (r *gormAPIKeyRepository) GetAPIKey(ctx context.Context, hash string) (models.APIKey, error) {
+	var key models.APIKey
+	if err := r.db.WithContext(ctx).Where("key_hash = ?", hash).First(&key).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return models.APIKey{}, ErrAPIKeyNotFound
+		}
+		return models.APIKey{}, err
+	}
+	return key, nil
+}
+
+func (r *gormAPIKeyRepository) DeleteAPIKey(ctx context.Context, userID, id uint) error {
+	res := r.db.WithContext(ctx).Where("id = ? AND user_id = ?", id, userID).Delete(&models.APIKey{})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+func (r *gormAPIKeyRepository) DeleteUserAPIKeys(ctx context.Context, userID uint) (int64, error) {
+	res := r.db.WithContext(ctx).Where("user_id = ?", userID).Delete(&models.APIKey{})
+	return res.RowsAffected, res.Error
+}
+
+func (r *gormAPIKeyRepository) TouchAPIKey(ctx context.Context, id uint, usedAt time.Time) error {
+	return r.db.WithContext(ctx).Model(&models.APIKey{}).Where("id = ?", id).Update("last_used_at", usedAt).Error
+}
@@ -0,0 +1,224 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"book_order_app/models"
+	"book_order_app/query"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// searchMinSimilarity is the pg_trgm word similarity a title or author needs
+	// to match when no full-text term does, so that "donovn" still finds Donovan.
+	searchMinSimilarity  = 0.3
+	searchHighlightStyle = "StartSel=<mark>, StopSel=</mark>, HighlightAll=true"
+)
+
+// BookRepository stores the catalog and each book's stock ledger
+type BookRepository interface {
+	List(ctx context.Context, params query.Params, includeDeleted bool) (query.Page[models.Book], error)
+	// Search ranks books whose title or author matches every term as a word prefix.
+	// Terms must already be lower-cased words.
+	Search(ctx context.Context, terms []string, params query.Params) (query.Page[models.BookSearchResult], error)
+	Get(ctx context.Context, id uint) (models.Book, error)
+	Create(ctx context.Context, book *models.Book) error
+	// UpdateDetails saves the title, author and price of book; stock is owned by the ledger
+	UpdateDetails(ctx context.Context, book *models.Book) error
+	// Delete soft-deletes a book unless orders that are not deleted still reference it
+	Delete(ctx context.Context, id uint) error
+	Restore(ctx context.Context, id uint) (models.Book, error)
+	// AdjustStock applies movement.Delta to the book's stock and appends the movement to the ledger
+	AdjustStock(ctx context.Context, movement models.StockMovement) (models.Book, error)
+	// ListMovements lists the stock ledger of a book, deleted or not
+	ListMovements(ctx context.Context, bookID uint, params query.Params) (query.Page[models.StockMovement], error)
+}
+
+type gormBookRepository struct {
+	db *gorm.DB
+}
+
+func (r *gormBookRepository) List(ctx context.Context, params query.Params, includeDeleted bool) (query.Page[models.Book], error) {
+	db := r.db.WithContext(ctx)
+	if includeDeleted {
+		db = db.Unscoped()
+	}
+	return query.Find[models.Book](db, params)
+}
+
+func (r *gormBookRepository) Search(ctx context.Context, terms []string, params query.Params) (query.Page[models.BookSearchResult], error) {
+	prefixes := make([]string, len(terms))
+	for i, term := range terms {
+		prefixes[i] = term + ":*"
+	}
+	tsQuery := strings.Join(prefixes, " & ")
+	phrase := strings.Join(terms, " ")
+
+	base := r.db.WithContext(ctx).Model(&models.Book{}).
+		Where("search_vector @@ to_tsquery('simple', ?) OR word_similarity(?, title) >= ? OR word_similarity(?, author) >= ?",
+			tsQuery, phrase, searchMinSimilarity, phrase, searchMinSimilarity).
+		Session(&gorm.Session{})
+
+	var total int64
+	if err := base.Count(&total).Error; err != nil {
+		return query.Page[models.BookSearchResult]{}, err
+	}
+
+	results := []models.BookSearchResult{}
+	err := base.
+		Select(`books.*,
+			ts_rank_cd(search_vector, to_tsquery('simple', ?)) + GREATEST(word_similarity(?, title), word_similarity(?, author)) AS rank,
+			ts_headline('simple', title, to_tsquery('simple', ?), ?) AS title_highlight,
+			ts_headline('simple', author, to_tsquery('simple', ?), ?) AS author_highlight`,
+			tsQuery, phrase, phrase, tsQuery, searchHighlightStyle, tsQuery, searchHighlightStyle).
+		Order("rank DESC, id").
+		Limit(params.Limit).
+		Offset(params.Offset).
+		Scan(&results).Error
+	if err != nil {
+		return query.Page[models.BookSearchResult]{}, err
+	}
+
+	return query.Page[models.BookSearchResult]{
+		Data:   results,
+		Total:  total,
+		Limit:  params.Limit,
+		Offset: params.Offset,
+	}, nil
+}
+
+func (r *gormBookRepository) Get(ctx context.Context, id uint) (models.Book, error) {
+	return findBook(r.db.WithContext(ctx), id)
+}
+
+func (r *gormBookRepository) Create(ctx context.Context, book *models.Book) error {
+	return r.db.WithContext(ctx).Create(book).Error
+}
+
+func (r *gormBookRepository) UpdateDetails(ctx context.Context, book *models.Book) error {
+	res := r.db.WithContext(ctx).Model(book).Select("Title", "Author", "Price").Updates(book)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrBookNotFound
+	}
+	return r.db.WithContext(ctx).First(book, book.ID).Error
+}
+
+func (r *gormBookRepository) Delete(ctx context.Context, id uint) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// Lock the row so no order can be placed against the book while we check
+		if _, err := findBook(tx.Clauses(clause.Locking{Strength: "UPDATE"}), id); err != nil {
+			return err
+		}
+
+		var openOrders int64
+		err := tx.Model(&models.OrderItem{}).
+			Joins("JOIN orders ON orders.id = order_items.order_id AND orders.deleted_at IS NULL").
+			Where("order_items.book_id = ? AND orders.status NOT IN ?", id, models.ClosedOrderStatuses).
+			Count(&openOrders).Error
+		if err != nil {
+			return err
+		}
+		if openOrders > 0 {
+			return ErrBookHasOrders
+		}
+
+		return tx.Delete(&models.Book{}, id).Error
+	})
+}
+
+func (r *gormBookRepository) Restore(ctx context.Context, id uint) (models.Book, error) {
+	var book models.Book
+	if err := restoreByID(r.db.WithContext(ctx), &book, id, ErrBookNotFound); err != nil {
+		return models.Book{}, err
+	}
+	return book, nil
+}
+
+func (r *gormBookRepository) AdjustStock(ctx context.Context, movement models.StockMovement) (models.Book, error) {
+	var book models.Book
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var err error
+		book, err = moveStock(tx, movement)
+		return err
+	})
+	return book, err
+}
+
+func (r *gormBookRepository) ListMovements(ctx context.Context, bookID uint, params query.Params) (query.Page[models.StockMovement], error) {
+	db := r.db.WithContext(ctx)
+
+	var count int64
+	if err := db.Unscoped().Model(&models.Book{}).Where("id = ?", bookID).Count(&count).Error; err != nil {
+		return query.Page[models.StockMovement]{}, err
+	}
+	if count == 0 {
+		return query.Page[models.StockMovement]{}, ErrBookNotFound
+	}
+
+	params.Restrict("book_id", bookID)
+	return query.Find[models.StockMovement](db, params)
+}
+
+func findBook(db *gorm.DB, id uint) (models.Book, error) {
+	var book models.Book
+	if err := db.First(&book, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return models.Book{}, ErrBookNotFound
+		}
+		return models.Book{}, err
+	}
+	return book, nil
+}
+
+// moveStock locks the book row, applies the movement's delta and appends it to the ledger.
+// It must run inside a transaction so the lock is held until commit.
+func moveStock(tx *gorm.DB, movement models.StockMovement) (models.Book, error) {
+	book, err := findBook(tx.Clauses(clause.Locking{Strength: "UPDATE"}), movement.BookID)
+	if err != nil {
+		return models.Book{}, err
+	}
+
+	if book.Stock+movement.Delta < 0 {
+		return models.Book{}, fmt.Errorf("%w for book %d", ErrInsufficientStock, book.ID)
+	}
+	book.Stock += movement.Delta
+	if err := tx.Model(&book).Update("stock", book.Stock).Error; err != nil {
+		return models.Book{}, err
+	}
+
+	movement.BalanceAfter = book.Stock
+	if err := tx.Create(&movement).Error; err != nil {
+		return models.Book{}, err
+	}
+	return book, nil
+}
+
+// moveOrderStock reserves (sign -1) or releases (sign +1) the stock of every line of an order.
+// Lines are processed in book id order so concurrent orders always lock rows in the same order.
+func moveOrderStock(tx *gorm.DB, order models.Order, sign int, reason models.StockReason) error {
+	items := append([]models.OrderItem(nil), order.Items...)
+	sort.Slice(items, func(i, j int) bool { return items[i].BookID < items[j].BookID })
+
+	for _, item := range items {
+		orderID := order.ID
+		_, err := moveStock(tx, models.StockMovement{
+			BookID:  item.BookID,
+			OrderID: &orderID,
+			Delta:   sign * item.Quantity,
+			Reason:  reason,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
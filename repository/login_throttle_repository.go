@@ -0,0 +1,77 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"book_order_app/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// LoginThrottleRepository counts failed logins per username and per client address
+type LoginThrottleRepository interface {
+	// GetLoginThrottle returns the throttle of a subject; a subject without recorded
+	// failures gets a zero throttle
+	GetLoginThrottle(ctx context.Context, scope models.LoginScope, subject string) (models.LoginThrottle, error)
+	// RecordLoginFailure counts a failed login of subject and returns the updated
+	// throttle. The count starts over when the previous failure is older than forgetAfter.
+	RecordLoginFailure(ctx context.Context, scope models.LoginScope, subject string, forgetAfter time.Duration) (models.LoginThrottle, error)
+	// LockLogin refuses logins of subject until the given time
+	LockLogin(ctx context.Context, scope models.LoginScope, subject string, until time.Time) error
+	// ClearLoginFailures forgets the failures and any lockout of subject
+	ClearLoginFailures(ctx context.Context, scope models.LoginScope, subject string) error
+}
+
+type gormLoginThrottleRepository struct {
+	db *gorm.DB
+}
+
+func (r *gormLoginThrottleRepository) GetLoginThrottle(ctx context.Context, scope models.LoginScope, subject string) (models.LoginThrottle, error) {
+	var throttle models.LoginThrottle
+	err := r.db.WithContext(ctx).Where("scope = ? AND subject = ?", scope, subject).First(&throttle).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return models.LoginThrottle{Scope: scope, Subject: subject}, nil
+	}
+	return throttle, err
+}
+
+func (r *gormLoginThrottleRepository) RecordLoginFailure(ctx context.Context, scope models.LoginScope, subject string, forgetAfter time.Duration) (models.LoginThrottle, error) {
+	var throttle models.LoginThrottle
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// One upsert, so concurrent failures are all counted
+		now := time.Now()
+		entry := models.LoginThrottle{Scope: scope, Subject: subject, Failures: 1, LastFailureAt: now}
+		err := tx.Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "scope"}, {Name: "subject"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{
+				"failures": gorm.Expr("CASE WHEN login_throttles.last_failure_at < ? THEN 1 ELSE login_throttles.failures + 1 END",
+					now.Add(-forgetAfter)),
+				"last_failure_at": now,
+				"updated_at":      now,
+			}),
+		}).Create(&entry).Error
+		if err != nil {
+			return err
+		}
+		return tx.Where("scope = ? AND subject = ?", scope, subject).First(&throttle).Error
+	})
+	if err != nil {
+		return models.LoginThrottle{}, err
+	}
+	return throttle, nil
+}
+
+func (r *gormLoginThrottleRepository) LockLogin(ctx context.Context, scope models.LoginScope, subject string, until time.Time) error {
+	return r.db.WithContext(ctx).Model(&models.LoginThrottle{}).
+		Where("scope = ? AND subject = ?", scope, subject).
+		Update("locked_until", until).Error
+}
+
+func (r *gormLoginThrottleRepository) ClearLoginFailures(ctx context.Context, scope models.LoginScope, subject string) error {
+	return r.db.WithContext(ctx).
+		Where("scope = ? AND subject = ?", scope, subject).
+		Delete(&models.LoginThrottle{}).Error
+}
@@ -0,0 +1,187 @@
+package repository
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"book_order_app/models"
+
+	"gorm.io/gorm"
+)
+
+// memoryStore holds every table of the in-memory repositories behind one lock, so
+// operations that touch several tables are atomic just like a database transaction.
+// Records are copied in and out; callers never share memory with the store.
+type memoryStore struct {
+	mu sync.Mutex
+
+	books     map[uint]models.Book
+	movements []models.StockMovement
+	orders    map[uint]models.Order
+	changes   []models.OrderStatusChange
+	users     map[uint]models.User
+
+	roles         map[models.UserRole]models.Role
+	roleChanges   []models.RoleChange
+	recoveryCodes map[uint]models.RecoveryCode
+
+	refreshTokens map[uint]models.RefreshToken
+	denylist      map[string]time.Time
+	oneTimeTokens map[uint]models.OneTimeToken
+
+	loginThrottles map[throttleKey]models.LoginThrottle
+	apiKeys        map[uint]models.APIKey
+
+	lastID map[string]uint
+	now    func() time.Time
+}
+
+// NewMemoryRepositories returns empty repositories that keep everything in memory
+func NewMemoryRepositories() Repositories {
+	s := &memoryStore{
+		books:  map[uint]models.Book{},
+		orders: map[uint]models.Order{},
+		users:  map[uint]models.User{},
+
+		roles:         map[models.UserRole]models.Role{},
+		recoveryCodes: map[uint]models.RecoveryCode{},
+
+		refreshTokens: map[uint]models.RefreshToken{},
+		denylist:      map[string]time.Time{},
+		oneTimeTokens: map[uint]models.OneTimeToken{},
+
+		loginThrottles: map[throttleKey]models.LoginThrottle{},
+		apiKeys:        map[uint]models.APIKey{},
+
+		lastID: map[string]uint{},
+		now:    time.Now,
+	}
+	return Repositories{
+		Books:  &memoryBookRepository{s},
+		Orders: &memoryOrderRepository{s},
+		Users:  &memoryUserRepository{s},
+		Roles:  &memoryRoleRepository{s},
+		Tokens: &memoryTokenRepository{s},
+
+		LoginThrottles: &memoryLoginThrottleRepository{s},
+		APIKeys:        &memoryAPIKeyRepository{s},
+		Purger:         &memoryPurgeRepository{s},
+	}
+}
+
+// nextID hands out ids per table, starting at 1 like a SERIAL column
+func (s *memoryStore) nextID(table string) uint {
+	s.lastID[table]++
+	return s.lastID[table]
+}
+
+func (s *memoryStore) deletedAt() gorm.DeletedAt {
+	return gorm.DeletedAt{Time: s.now(), Valid: true}
+}
+
+// liveBook returns a book that exists and is not soft-deleted
+func (s *memoryStore) liveBook(id uint) (models.Book, error) {
+	book, ok := s.books[id]
+	if !ok || book.DeletedAt.Valid {
+		return models.Book{}, ErrBookNotFound
+	}
+	return book, nil
+}
+
+// moveStock applies movements to the books' stock and appends them to the ledger.
+// Every movement is checked before any is applied, so a failure changes nothing.
+func (s *memoryStore) moveStock(movements ...models.StockMovement) (models.Book, error) {
+	if err := s.checkStock(movements); err != nil {
+		return models.Book{}, err
+	}
+
+	sort.SliceStable(movements, func(i, j int) bool { return movements[i].BookID < movements[j].BookID })
+	var book models.Book
+	for _, m := range movements {
+		book = s.books[m.BookID]
+		book.Stock += m.Delta
+		book.UpdatedAt = s.now()
+		s.books[m.BookID] = book
+
+		m.ID = s.nextID("stock_movements")
+		m.CreatedAt = s.now()
+		m.BalanceAfter = book.Stock
+		s.movements = append(s.movements, m)
+	}
+	return book, nil
+}
+
+// checkStock reports the error moveStock would return, visiting books in id order
+// like the row locks of the GORM repository do
+func (s *memoryStore) checkStock(movements []models.StockMovement) error {
+	sorted := append([]models.StockMovement(nil), movements...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].BookID < sorted[j].BookID })
+
+	balances := map[uint]int{}
+	for _, m := range sorted {
+		book, err := s.liveBook(m.BookID)
+		if err != nil {
+			return err
+		}
+		if _, ok := balances[m.BookID]; !ok {
+			balances[m.BookID] = book.Stock
+		}
+		if balances[m.BookID]+m.Delta < 0 {
+			return fmt.Errorf("%w for book %d", ErrInsufficientStock, m.BookID)
+		}
+		balances[m.BookID] += m.Delta
+	}
+	return nil
+}
+
+// orderStock builds the movements that reserve (sign -1) or release (sign +1) an order's stock
+func orderStock(order models.Order, sign int, reason models.StockReason) []models.StockMovement {
+	movements := make([]models.StockMovement, len(order.Items))
+	for i, item := range order.Items {
+		orderID := order.ID
+		movements[i] = models.StockMovement{
+			BookID:  item.BookID,
+			OrderID: &orderID,
+			Delta:   sign * item.Quantity,
+			Reason:  reason,
+		}
+	}
+	return movements
+}
+
+func cloneOrder(order models.Order) models.Order {
+	order.Items = append([]models.OrderItem{}, order.Items...)
+	return order
+}
+
+// sortedByID returns the values of a table in id order
+func sortedByID[T any](table map[uint]T, include func(T) bool) []T {
+	ids := make([]uint, 0, len(table))
+	for id := range table {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	values := make([]T, 0, len(ids))
+	for _, id := range ids {
+		if include(table[id]) {
+			values = append(values, table[id])
+		}
+	}
+	return values
+}
+
+// idEquals compares an id with a filter value parsed by the query package
+func idEquals(id uint, value interface{}) bool {
+	switch v := value.(type) {
+	case int64:
+		return v >= 0 && uint(v) == id
+	case float64:
+		return v == float64(id)
+	case uint:
+		return v == id
+	}
+	return false
+}
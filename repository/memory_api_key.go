@@ -0,0 +1,88 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"book_order_app/models"
+)
+
+type memoryAPIKeyRepository struct {
+	s *memoryStore
+}
+
+func (r *memoryAPIKeyRepository) CreateAPIKey(ctx context.Context, key *models.APIKey) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	key.ID = r.s.nextID("api_keys")
+	key.CreatedAt = r.s.now()
+	key.Scopes = append([]string(nil), key.Scopes...)
+	r.s.apiKeys[key.ID] = *key
+	return nil
+}
+
+func (r *memoryAPIKeyRepository) ListAPIKeys(ctx context.Context, userID uint) ([]models.APIKey, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	keys := sortedByID(r.s.apiKeys, func(k models.APIKey) bool { return k.UserID == userID })
+	for i := range keys {
+		keys[i] = cloneAPIKey(keys[i])
+	}
+	return keys, nil
+}
+
+func (r *memoryAPIKeyRepository) GetAPIKey(ctx context.Context, hash string) (models.APIKey, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	for _, key := range r.s.apiKeys {
+		if key.KeyHash == hash {
+			return cloneAPIKey(key), nil
+		}
+	}
+	return models.APIKey{}, ErrAPIKeyNotFound
+}
+
+func (r *memoryAPIKeyRepository) DeleteAPIKey(ctx context.Context, userID, id uint) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	key, ok := r.s.apiKeys[id]
+	if !ok || key.UserID != userID {
+		return ErrAPIKeyNotFound
+	}
+	delete(r.s.apiKeys, id)
+	return nil
+}
+
+func (r *memoryAPIKeyRepository) DeleteUserAPIKeys(ctx context.Context, userID uint) (int64, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	var deleted int64
+	for id, key := range r.s.apiKeys {
+		if key.UserID == userID {
+			delete(r.s.apiKeys, id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+func (r *memoryAPIKeyRepository) TouchAPIKey(ctx context.Context, id uint, usedAt time.Time) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	if key, ok := r.s.apiKeys[id]; ok {
+		key.LastUsedAt = &usedAt
+		r.s.apiKeys[id] = key
+	}
+	return nil
+}
+
+func cloneAPIKey(key models.APIKey) models.APIKey {
+	key.Scopes = append([]string(nil), key.Scopes...)
+	return key
+}
@@ -0,0 +1,194 @@
+package repository
+
+import (
+	"context"
+	"regexp"
+	"sort"
+	"strings"
+
+	"book_order_app/models"
+	"book_order_app/query"
+
+	"gorm.io/gorm"
+)
+
+var wordPattern = regexp.MustCompile(`[\p{L}\p{N}]+`)
+
+type memoryBookRepository struct {
+	s *memoryStore
+}
+
+func (r *memoryBookRepository) List(ctx context.Context, params query.Params, includeDeleted bool) (query.Page[models.Book], error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	books := sortedByID(r.s.books, func(b models.Book) bool { return includeDeleted || !b.DeletedAt.Valid })
+	return query.Slice(books, params, nil)
+}
+
+// Search matches every term as a word prefix like the full-text query does. The
+// trigram typo tolerance of the GORM repository has no in-memory equivalent.
+func (r *memoryBookRepository) Search(ctx context.Context, terms []string, params query.Params) (query.Page[models.BookSearchResult], error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	results := []models.BookSearchResult{}
+	for _, book := range sortedByID(r.s.books, func(b models.Book) bool { return !b.DeletedAt.Valid }) {
+		words := wordPattern.FindAllString(strings.ToLower(book.Title+" "+book.Author), -1)
+		if !everyTermMatches(words, terms) {
+			continue
+		}
+		matched := 0
+		for _, word := range words {
+			if hasAnyPrefix(word, terms) {
+				matched++
+			}
+		}
+		results = append(results, models.BookSearchResult{
+			Book:            book,
+			Rank:            float64(matched) / float64(len(words)),
+			TitleHighlight:  highlight(book.Title, terms),
+			AuthorHighlight: highlight(book.Author, terms),
+		})
+	}
+	sort.SliceStable(results, func(i, j int) bool { return results[i].Rank > results[j].Rank })
+
+	page := query.Page[models.BookSearchResult]{Data: []models.BookSearchResult{}, Total: int64(len(results)), Limit: params.Limit, Offset: params.Offset}
+	if params.Offset < len(results) {
+		results = results[params.Offset:]
+		if len(results) > params.Limit {
+			results = results[:params.Limit]
+		}
+		page.Data = results
+	}
+	return page, nil
+}
+
+func (r *memoryBookRepository) Get(ctx context.Context, id uint) (models.Book, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	return r.s.liveBook(id)
+}
+
+func (r *memoryBookRepository) Create(ctx context.Context, book *models.Book) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	book.ID = r.s.nextID("books")
+	book.CreatedAt = r.s.now()
+	book.UpdatedAt = book.CreatedAt
+	r.s.books[book.ID] = *book
+	return nil
+}
+
+func (r *memoryBookRepository) UpdateDetails(ctx context.Context, book *models.Book) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	existing, err := r.s.liveBook(book.ID)
+	if err != nil {
+		return err
+	}
+	existing.Title = book.Title
+	existing.Author = book.Author
+	existing.Price = book.Price
+	existing.UpdatedAt = r.s.now()
+	r.s.books[existing.ID] = existing
+	*book = existing
+	return nil
+}
+
+func (r *memoryBookRepository) Delete(ctx context.Context, id uint) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	book, err := r.s.liveBook(id)
+	if err != nil {
+		return err
+	}
+	for _, order := range r.s.orders {
+		if order.DeletedAt.Valid || !order.Status.IsOpen() {
+			continue
+		}
+		for _, item := range order.Items {
+			if item.BookID == id {
+				return ErrBookHasOrders
+			}
+		}
+	}
+
+	book.DeletedAt = r.s.deletedAt()
+	r.s.books[id] = book
+	return nil
+}
+
+func (r *memoryBookRepository) Restore(ctx context.Context, id uint) (models.Book, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	book, ok := r.s.books[id]
+	if !ok {
+		return models.Book{}, ErrBookNotFound
+	}
+	if !book.DeletedAt.Valid {
+		return models.Book{}, ErrNotDeleted
+	}
+	book.DeletedAt = gorm.DeletedAt{}
+	book.UpdatedAt = r.s.now()
+	r.s.books[id] = book
+	return book, nil
+}
+
+func (r *memoryBookRepository) AdjustStock(ctx context.Context, movement models.StockMovement) (models.Book, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	return r.s.moveStock(movement)
+}
+
+func (r *memoryBookRepository) ListMovements(ctx context.Context, bookID uint, params query.Params) (query.Page[models.StockMovement], error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	if _, ok := r.s.books[bookID]; !ok {
+		return query.Page[models.StockMovement]{}, ErrBookNotFound
+	}
+	params.Restrict("book_id", bookID)
+	return query.Slice(r.s.movements, params, nil)
+}
+
+func hasAnyPrefix(word string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(word, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func everyTermMatches(words, terms []string) bool {
+	for _, term := range terms {
+		found := false
+		for _, word := range words {
+			if strings.HasPrefix(word, term) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// highlight wraps every word matching a term in <mark>, like ts_headline with HighlightAll
+func highlight(text string, terms []string) string {
+	return wordPattern.ReplaceAllStringFunc(text, func(word string) string {
+		if hasAnyPrefix(strings.ToLower(word), terms) {
+			return "<mark>" + word + "</mark>"
+		}
+		return word
+	})
+}
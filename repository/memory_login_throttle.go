@@ -0,0 +1,69 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"book_order_app/models"
+)
+
+type memoryLoginThrottleRepository struct {
+	s *memoryStore
+}
+
+// throttleKey identifies a throttle the way the unique index on (scope, subject) does
+type throttleKey struct {
+	scope   models.LoginScope
+	subject string
+}
+
+func (r *memoryLoginThrottleRepository) GetLoginThrottle(ctx context.Context, scope models.LoginScope, subject string) (models.LoginThrottle, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	if throttle, ok := r.s.loginThrottles[throttleKey{scope, subject}]; ok {
+		return throttle, nil
+	}
+	return models.LoginThrottle{Scope: scope, Subject: subject}, nil
+}
+
+func (r *memoryLoginThrottleRepository) RecordLoginFailure(ctx context.Context, scope models.LoginScope, subject string, forgetAfter time.Duration) (models.LoginThrottle, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	now := r.s.now()
+	key := throttleKey{scope, subject}
+	throttle, ok := r.s.loginThrottles[key]
+	if !ok {
+		throttle = models.LoginThrottle{ID: r.s.nextID("login_throttles"), CreatedAt: now, Scope: scope, Subject: subject}
+	}
+	if throttle.LastFailureAt.Before(now.Add(-forgetAfter)) {
+		throttle.Failures = 0
+	}
+	throttle.Failures++
+	throttle.LastFailureAt = now
+	throttle.UpdatedAt = now
+	r.s.loginThrottles[key] = throttle
+	return throttle, nil
+}
+
+func (r *memoryLoginThrottleRepository) LockLogin(ctx context.Context, scope models.LoginScope, subject string, until time.Time) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	key := throttleKey{scope, subject}
+	if throttle, ok := r.s.loginThrottles[key]; ok {
+		throttle.LockedUntil = &until
+		throttle.UpdatedAt = r.s.now()
+		r.s.loginThrottles[key] = throttle
+	}
+	return nil
+}
+
+func (r *memoryLoginThrottleRepository) ClearLoginFailures(ctx context.Context, scope models.LoginScope, subject string) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	delete(r.s.loginThrottles, throttleKey{scope, subject})
+	return nil
+}
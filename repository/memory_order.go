@@ -0,0 +1,186 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"book_order_app/models"
+	"book_order_app/query"
+
+	"gorm.io/gorm"
+)
+
+// orderMatchers evaluates the order filters that are SQL expressions
+var orderMatchers = map[string]query.Matcher[models.Order]{
+	"book_id": func(order models.Order, value interface{}) bool {
+		for _, item := range order.Items {
+			if idEquals(item.BookID, value) {
+				return true
+			}
+		}
+		return false
+	},
+}
+
+type memoryOrderRepository struct {
+	s *memoryStore
+}
+
+func (r *memoryOrderRepository) List(ctx context.Context, params query.Params, includeDeleted bool) (query.Page[models.Order], error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	orders := sortedByID(r.s.orders, func(o models.Order) bool { return includeDeleted || !o.DeletedAt.Valid })
+	for i := range orders {
+		orders[i] = cloneOrder(orders[i])
+	}
+	return query.Slice(orders, params, orderMatchers)
+}
+
+func (r *memoryOrderRepository) Get(ctx context.Context, id uint) (models.Order, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	order, err := r.liveOrder(id)
+	if err != nil {
+		return models.Order{}, err
+	}
+	return cloneOrder(order), nil
+}
+
+func (r *memoryOrderRepository) Create(ctx context.Context, order *models.Order) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	for i := range order.Items {
+		book, err := r.s.liveBook(order.Items[i].BookID)
+		if err != nil {
+			return err
+		}
+		order.Items[i].UnitPrice = book.Price
+	}
+	order.Status = models.OrderStatusPending
+	order.CalculateTotals()
+
+	// Nothing is stored unless every line can be filled
+	if err := r.s.checkStock(orderStock(*order, -1, models.StockReasonOrderPlaced)); err != nil {
+		return err
+	}
+
+	created := cloneOrder(*order)
+	created.ID = r.s.nextID("orders")
+	created.CreatedAt = r.s.now()
+	created.UpdatedAt = created.CreatedAt
+	for i := range created.Items {
+		created.Items[i].ID = r.s.nextID("order_items")
+		created.Items[i].OrderID = created.ID
+		created.Items[i].CreatedAt = created.CreatedAt
+		created.Items[i].UpdatedAt = created.CreatedAt
+	}
+	r.s.orders[created.ID] = created
+	r.addChange(models.OrderStatusChange{OrderID: created.ID, ToStatus: created.Status})
+	if _, err := r.s.moveStock(orderStock(created, -1, models.StockReasonOrderPlaced)...); err != nil {
+		return err
+	}
+
+	*order = cloneOrder(created)
+	return nil
+}
+
+func (r *memoryOrderRepository) Delete(ctx context.Context, id uint) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	order, err := r.liveOrder(id)
+	if err != nil {
+		return err
+	}
+	if order.Status.HoldsStock() {
+		if _, err := r.s.moveStock(orderStock(order, 1, models.StockReasonOrderCancelled)...); err != nil {
+			return err
+		}
+	}
+
+	order.DeletedAt = r.s.deletedAt()
+	r.s.orders[id] = order
+	return nil
+}
+
+func (r *memoryOrderRepository) Restore(ctx context.Context, id uint) (models.Order, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	order, ok := r.s.orders[id]
+	if !ok {
+		return models.Order{}, ErrOrderNotFound
+	}
+	if !order.DeletedAt.Valid {
+		return models.Order{}, ErrNotDeleted
+	}
+	if order.Status.HoldsStock() {
+		if _, err := r.s.moveStock(orderStock(order, -1, models.StockReasonOrderPlaced)...); err != nil {
+			return models.Order{}, err
+		}
+	}
+
+	order.DeletedAt = gorm.DeletedAt{}
+	order.UpdatedAt = r.s.now()
+	r.s.orders[id] = order
+	return cloneOrder(order), nil
+}
+
+func (r *memoryOrderRepository) Transition(ctx context.Context, id uint, to models.OrderStatus, actorID uint, note string) (models.Order, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	order, err := r.liveOrder(id)
+	if err != nil {
+		return models.Order{}, err
+	}
+
+	from := order.Status
+	if !from.CanTransitionTo(to) {
+		return models.Order{}, fmt.Errorf("%w: %s -> %s", ErrInvalidTransition, from, to)
+	}
+	if from.HoldsStock() && !to.HoldsStock() && to != models.OrderStatusShipped {
+		if _, err := r.s.moveStock(orderStock(order, 1, models.StockReasonOrderCancelled)...); err != nil {
+			return models.Order{}, err
+		}
+	}
+
+	order.Status = to
+	order.UpdatedAt = r.s.now()
+	r.s.orders[id] = order
+	r.addChange(models.OrderStatusChange{OrderID: id, FromStatus: from, ToStatus: to, ChangedBy: &actorID, Note: note})
+	return cloneOrder(order), nil
+}
+
+func (r *memoryOrderRepository) History(ctx context.Context, id uint) ([]models.OrderStatusChange, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	if _, ok := r.s.orders[id]; !ok {
+		return nil, ErrOrderNotFound
+	}
+	history := []models.OrderStatusChange{}
+	for _, change := range r.s.changes {
+		if change.OrderID == id {
+			history = append(history, change)
+		}
+	}
+	return history, nil
+}
+
+func (r *memoryOrderRepository) liveOrder(id uint) (models.Order, error) {
+	order, ok := r.s.orders[id]
+	if !ok || order.DeletedAt.Valid {
+		return models.Order{}, ErrOrderNotFound
+	}
+	return order, nil
+}
+
+func (r *memoryOrderRepository) addChange(change models.OrderStatusChange) {
+	change.ID = r.s.nextID("order_status_changes")
+	change.CreatedAt = r.s.now()
+	r.s.changes = append(r.s.changes, change)
+}
@@ -0,0 +1,135 @@
+package repository
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"book_order_app/models"
+
+	"gorm.io/gorm"
+)
+
+type memoryPurgeRepository struct {
+	s *memoryStore
+}
+
+func (r *memoryPurgeRepository) Purge(ctx context.Context, deletedBefore, forgetBefore time.Time) (PurgeResult, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	var result PurgeResult
+	expired := func(deleted gorm.DeletedAt) bool {
+		return deleted.Valid && deleted.Time.Before(deletedBefore)
+	}
+
+	for id, order := range r.s.orders {
+		if expired(order.DeletedAt) {
+			delete(r.s.orders, id)
+			result.Orders++
+		}
+	}
+	r.s.changes = slices.DeleteFunc(r.s.changes, func(c models.OrderStatusChange) bool {
+		_, kept := r.s.orders[c.OrderID]
+		return !kept
+	})
+	// The ledger keeps its entries, like ON DELETE SET NULL on fk_stock_movements_order
+	for i, m := range r.s.movements {
+		if m.OrderID == nil {
+			continue
+		}
+		if _, kept := r.s.orders[*m.OrderID]; !kept {
+			r.s.movements[i].OrderID = nil
+		}
+	}
+
+	for id, book := range r.s.books {
+		if expired(book.DeletedAt) && !r.s.bookOrdered(id) {
+			delete(r.s.books, id)
+			result.Books++
+		}
+	}
+	r.s.movements = slices.DeleteFunc(r.s.movements, func(m models.StockMovement) bool {
+		_, kept := r.s.books[m.BookID]
+		return !kept
+	})
+
+	purgedUsers := map[uint]bool{}
+	for id, user := range r.s.users {
+		if expired(user.DeletedAt) && !r.s.userOrdered(id) {
+			delete(r.s.users, id)
+			purgedUsers[id] = true
+			result.Users++
+		}
+	}
+	r.s.roleChanges = slices.DeleteFunc(r.s.roleChanges, func(c models.RoleChange) bool {
+		return purgedUsers[c.UserID]
+	})
+	for id, code := range r.s.recoveryCodes {
+		if purgedUsers[code.UserID] {
+			delete(r.s.recoveryCodes, id)
+		}
+	}
+	for id, key := range r.s.apiKeys {
+		if purgedUsers[key.UserID] {
+			delete(r.s.apiKeys, id)
+		}
+	}
+
+	// Expired refresh tokens, denylist entries, one-time tokens and API keys can no longer be used
+	now := r.s.now()
+	for id, token := range r.s.refreshTokens {
+		if token.ExpiresAt.Before(now) {
+			delete(r.s.refreshTokens, id)
+			result.Tokens++
+		}
+	}
+	for id, until := range r.s.denylist {
+		if until.Before(now) {
+			delete(r.s.denylist, id)
+			result.Tokens++
+		}
+	}
+	for id, token := range r.s.oneTimeTokens {
+		if token.ExpiresAt.Before(now) {
+			delete(r.s.oneTimeTokens, id)
+			result.Tokens++
+		}
+	}
+	for id, key := range r.s.apiKeys {
+		if key.ExpiresAt != nil && key.ExpiresAt.Before(now) {
+			delete(r.s.apiKeys, id)
+			result.Tokens++
+		}
+	}
+
+	for key, throttle := range r.s.loginThrottles {
+		if throttle.LastFailureAt.Before(forgetBefore) {
+			delete(r.s.loginThrottles, key)
+			result.LoginThrottles++
+		}
+	}
+	return result, nil
+}
+
+// bookOrdered reports whether any order, deleted or not, has an item of the book
+func (s *memoryStore) bookOrdered(bookID uint) bool {
+	for _, order := range s.orders {
+		for _, item := range order.Items {
+			if item.BookID == bookID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// userOrdered reports whether any order, deleted or not, belongs to the user
+func (s *memoryStore) userOrdered(userID uint) bool {
+	for _, order := range s.orders {
+		if order.UserID != nil && *order.UserID == userID {
+			return true
+		}
+	}
+	return false
+}
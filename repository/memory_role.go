@@ -0,0 +1,84 @@
+package repository
+
+import (
+	"context"
+	"sort"
+
+	"book_order_app/models"
+)
+
+type memoryRoleRepository struct {
+	s *memoryStore
+}
+
+func (r *memoryRoleRepository) ListRoles(ctx context.Context) ([]models.Role, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	roles := make([]models.Role, 0, len(r.s.roles))
+	for _, role := range r.s.roles {
+		roles = append(roles, cloneRole(role))
+	}
+	sort.Slice(roles, func(i, j int) bool { return roles[i].Name < roles[j].Name })
+	return roles, nil
+}
+
+func (r *memoryRoleRepository) GetRole(ctx context.Context, name models.UserRole) (models.Role, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	role, ok := r.s.roles[name]
+	if !ok {
+		return models.Role{}, ErrRoleNotFound
+	}
+	return cloneRole(role), nil
+}
+
+func (r *memoryRoleRepository) CreateRole(ctx context.Context, role *models.Role) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	if _, ok := r.s.roles[role.Name]; ok {
+		return ErrRoleExists
+	}
+	role.CreatedAt = r.s.now()
+	role.UpdatedAt = role.CreatedAt
+	r.s.roles[role.Name] = cloneRole(*role)
+	return nil
+}
+
+func (r *memoryRoleRepository) UpdateRole(ctx context.Context, name models.UserRole, description string, permissions []string) (models.Role, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	role, ok := r.s.roles[name]
+	if !ok {
+		return models.Role{}, ErrRoleNotFound
+	}
+	role.Description = description
+	role.Permissions = append([]string(nil), permissions...)
+	role.UpdatedAt = r.s.now()
+	r.s.roles[name] = role
+	return cloneRole(role), nil
+}
+
+func (r *memoryRoleRepository) DeleteRole(ctx context.Context, name models.UserRole) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	if _, ok := r.s.roles[name]; !ok {
+		return ErrRoleNotFound
+	}
+	for _, user := range r.s.users {
+		if user.Role == name {
+			return ErrRoleInUse
+		}
+	}
+	delete(r.s.roles, name)
+	return nil
+}
+
+func cloneRole(role models.Role) models.Role {
+	role.Permissions = append([]string{}, role.Permissions...)
+	return role
+}
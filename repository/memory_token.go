@@ -0,0 +1,151 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"book_order_app/models"
+)
+
+type memoryTokenRepository struct {
+	s *memoryStore
+}
+
+func (r *memoryTokenRepository) CreateRefreshToken(ctx context.Context, token *models.RefreshToken) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	r.s.storeRefreshToken(token)
+	return nil
+}
+
+func (r *memoryTokenRepository) GetRefreshToken(ctx context.Context, hash string) (models.RefreshToken, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	for _, token := range r.s.refreshTokens {
+		if token.TokenHash == hash {
+			return token, nil
+		}
+	}
+	return models.RefreshToken{}, ErrRefreshTokenNotFound
+}
+
+func (r *memoryTokenRepository) ReplaceRefreshToken(ctx context.Context, currentID uint, next *models.RefreshToken) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	current, ok := r.s.refreshTokens[currentID]
+	if !ok || current.RevokedAt != nil {
+		return ErrRefreshTokenRevoked
+	}
+	r.s.storeRefreshToken(next)
+
+	now := r.s.now()
+	current.RevokedAt = &now
+	current.ReplacedByID = &next.ID
+	r.s.refreshTokens[currentID] = current
+	return nil
+}
+
+func (r *memoryTokenRepository) RevokeFamily(ctx context.Context, family string, denyUntil time.Time) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	r.s.revokeFamily(family, denyUntil)
+	return nil
+}
+
+func (r *memoryTokenRepository) RevokeUserFamilies(ctx context.Context, userID uint, denyUntil time.Time) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	now := r.s.now()
+	for _, token := range sortedByID(r.s.refreshTokens, func(t models.RefreshToken) bool {
+		return t.UserID == userID && t.RevokedAt == nil && t.ExpiresAt.After(now)
+	}) {
+		r.s.revokeFamily(token.FamilyID, denyUntil)
+	}
+	return nil
+}
+
+func (r *memoryTokenRepository) Denylist(ctx context.Context, id string, until time.Time) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	r.s.denylist[id] = until
+	return nil
+}
+
+func (r *memoryTokenRepository) IsDenylisted(ctx context.Context, ids ...string) (bool, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	now := r.s.now()
+	for _, id := range ids {
+		if until, ok := r.s.denylist[id]; ok && until.After(now) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (r *memoryTokenRepository) CreateOneTimeToken(ctx context.Context, token *models.OneTimeToken) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	now := r.s.now()
+	for id, existing := range r.s.oneTimeTokens {
+		if existing.UserID == token.UserID && existing.Purpose == token.Purpose && existing.UsedAt == nil {
+			existing.UsedAt = &now
+			r.s.oneTimeTokens[id] = existing
+		}
+	}
+	token.ID = r.s.nextID("one_time_tokens")
+	token.CreatedAt = now
+	r.s.oneTimeTokens[token.ID] = *token
+	return nil
+}
+
+func (r *memoryTokenRepository) GetOneTimeToken(ctx context.Context, purpose models.TokenPurpose, hash string) (models.OneTimeToken, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	for _, token := range r.s.oneTimeTokens {
+		if token.Purpose == purpose && token.TokenHash == hash {
+			return token, nil
+		}
+	}
+	return models.OneTimeToken{}, ErrOneTimeTokenNotFound
+}
+
+func (r *memoryTokenRepository) UseOneTimeToken(ctx context.Context, id uint) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	token, ok := r.s.oneTimeTokens[id]
+	if !ok || token.UsedAt != nil {
+		return ErrOneTimeTokenUsed
+	}
+	now := r.s.now()
+	token.UsedAt = &now
+	r.s.oneTimeTokens[id] = token
+	return nil
+}
+
+func (s *memoryStore) storeRefreshToken(token *models.RefreshToken) {
+	token.ID = s.nextID("refresh_tokens")
+	token.CreatedAt = s.now()
+	s.refreshTokens[token.ID] = *token
+}
+
+func (s *memoryStore) revokeFamily(family string, denyUntil time.Time) {
+	now := s.now()
+	for id, token := range s.refreshTokens {
+		if token.FamilyID == family && token.RevokedAt == nil {
+			token.RevokedAt = &now
+			s.refreshTokens[id] = token
+		}
+	}
+	s.denylist[family] = denyUntil
+}
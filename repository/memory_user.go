@@ -0,0 +1,390 @@
+package repository
+
+import (
+	"context"
+
+	"book_order_app/models"
+	"book_order_app/query"
+
+	"gorm.io/gorm"
+)
+
+type memoryUserRepository struct {
+	s *memoryStore
+}
+
+func (r *memoryUserRepository) Create(ctx context.Context, user *models.User, actorID *uint, note string) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	for _, existing := range r.s.users {
+		if existing.Username == user.Username {
+			return ErrUsernameTaken
+		}
+	}
+	if user.Email != nil {
+		for _, existing := range r.s.users {
+			if existing.Email != nil && *existing.Email == *user.Email {
+				return ErrEmailTaken
+			}
+		}
+		email := *user.Email
+		user.Email = &email
+	}
+
+	// Run the same hook GORM runs, so the password is hashed
+	if err := user.BeforeCreate(nil); err != nil {
+		return err
+	}
+	if user.Role == "" {
+		user.Role = models.RoleUser
+	}
+	user.ID = r.s.nextID("users")
+	user.CreatedAt = r.s.now()
+	user.UpdatedAt = user.CreatedAt
+	r.s.users[user.ID] = *user
+	r.addRoleChange(models.RoleChange{UserID: user.ID, ToRole: user.Role, ChangedBy: actorID, Note: note})
+	return nil
+}
+
+func (r *memoryUserRepository) GetByID(ctx context.Context, id uint) (models.User, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	return r.liveUser(id)
+}
+
+func (r *memoryUserRepository) GetByIDWithDeleted(ctx context.Context, id uint) (models.User, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	user, ok := r.s.users[id]
+	if !ok {
+		return models.User{}, ErrUserNotFound
+	}
+	return user, nil
+}
+
+func (r *memoryUserRepository) GetByUsername(ctx context.Context, username string) (models.User, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	for _, user := range r.s.users {
+		if user.Username == username && !user.DeletedAt.Valid {
+			return user, nil
+		}
+	}
+	return models.User{}, ErrUserNotFound
+}
+
+func (r *memoryUserRepository) HasRole(ctx context.Context, role models.UserRole) (bool, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	for _, user := range r.s.users {
+		if user.Role == role && !user.DeletedAt.Valid {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (r *memoryUserRepository) List(ctx context.Context, params query.Params, includeDeleted bool) (query.Page[models.User], error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	users := sortedByID(r.s.users, func(u models.User) bool { return includeDeleted || !u.DeletedAt.Valid })
+	return query.Slice(users, params, nil)
+}
+
+func (r *memoryUserRepository) Restore(ctx context.Context, id uint) (models.User, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	user, ok := r.s.users[id]
+	if !ok {
+		return models.User{}, ErrUserNotFound
+	}
+	if !user.DeletedAt.Valid {
+		return models.User{}, ErrNotDeleted
+	}
+	user.DeletedAt = gorm.DeletedAt{}
+	user.UpdatedAt = r.s.now()
+	r.s.users[id] = user
+	return user, nil
+}
+
+func (r *memoryUserRepository) RoleHistory(ctx context.Context, id uint) ([]models.RoleChange, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	if _, ok := r.s.users[id]; !ok {
+		return nil, ErrUserNotFound
+	}
+	history := []models.RoleChange{}
+	for _, change := range r.s.roleChanges {
+		if change.UserID == id {
+			history = append(history, change)
+		}
+	}
+	return history, nil
+}
+
+func (r *memoryUserRepository) SetRole(ctx context.Context, id uint, role models.UserRole, actorID uint, note string) (models.User, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	user, err := r.liveUser(id)
+	if err != nil {
+		return models.User{}, err
+	}
+	if user.Role == role {
+		return user, nil
+	}
+	if role != models.RoleAdmin {
+		if err := r.keepAnAdmin(user); err != nil {
+			return models.User{}, err
+		}
+	}
+
+	r.addRoleChange(models.RoleChange{UserID: id, FromRole: user.Role, ToRole: role, ChangedBy: &actorID, Note: note})
+	user.Role = role
+	user.UpdatedAt = r.s.now()
+	r.s.users[id] = user
+	return user, nil
+}
+
+func (r *memoryUserRepository) SetDisabled(ctx context.Context, id uint, disabled bool) (models.User, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	user, err := r.liveUser(id)
+	if err != nil {
+		return models.User{}, err
+	}
+	if user.IsDisabled() == disabled {
+		return user, nil
+	}
+	user.DisabledAt = nil
+	if disabled {
+		if err := r.keepAnAdmin(user); err != nil {
+			return models.User{}, err
+		}
+		now := r.s.now()
+		user.DisabledAt = &now
+	}
+	user.UpdatedAt = r.s.now()
+	r.s.users[id] = user
+	return user, nil
+}
+
+func (r *memoryUserRepository) SetPassword(ctx context.Context, id uint, password string, resetRequired bool) (models.User, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	user, err := r.liveUser(id)
+	if err != nil {
+		return models.User{}, err
+	}
+	user.Password = password
+	if err := user.HashPassword(); err != nil {
+		return models.User{}, err
+	}
+	user.PasswordResetRequired = resetRequired
+	user.UpdatedAt = r.s.now()
+	r.s.users[id] = user
+	return user, nil
+}
+
+func (r *memoryUserRepository) UpdateProfile(ctx context.Context, user *models.User) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	stored, err := r.liveUser(user.ID)
+	if err != nil {
+		return err
+	}
+	if user.Email != nil {
+		for _, other := range r.s.users {
+			if other.ID != user.ID && other.Email != nil && *other.Email == *user.Email {
+				return ErrEmailTaken
+			}
+		}
+	}
+
+	stored.DisplayName = user.DisplayName
+	stored.Email = nil
+	if user.Email != nil {
+		email := *user.Email
+		stored.Email = &email
+	}
+	stored.EmailVerifiedAt = user.EmailVerifiedAt
+	stored.ShippingAddress = user.ShippingAddress
+	stored.UpdatedAt = r.s.now()
+	r.s.users[user.ID] = stored
+	*user = stored
+	return nil
+}
+
+func (r *memoryUserRepository) VerifyEmail(ctx context.Context, id uint, email string) (models.User, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	user, err := r.liveUser(id)
+	if err != nil {
+		return models.User{}, err
+	}
+	if user.Email == nil || *user.Email != email {
+		return models.User{}, ErrUserNotFound
+	}
+	if user.EmailVerifiedAt == nil {
+		now := r.s.now()
+		user.EmailVerifiedAt = &now
+		user.UpdatedAt = now
+		r.s.users[id] = user
+	}
+	return user, nil
+}
+
+func (r *memoryUserRepository) Delete(ctx context.Context, id uint) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	user, err := r.liveUser(id)
+	if err != nil {
+		return err
+	}
+	if err := r.keepAnAdmin(user); err != nil {
+		return err
+	}
+	user.DeletedAt = r.s.deletedAt()
+	r.s.users[id] = user
+	return nil
+}
+
+func (r *memoryUserRepository) StartMFAEnrollment(ctx context.Context, id uint, secret string) (models.User, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	user, err := r.liveUser(id)
+	if err != nil {
+		return models.User{}, err
+	}
+	if user.MFAEnabled() {
+		return models.User{}, ErrMFAEnabled
+	}
+	user.TOTPSecret = secret
+	user.TOTPLastStep = 0
+	user.UpdatedAt = r.s.now()
+	r.s.users[id] = user
+	return user, nil
+}
+
+func (r *memoryUserRepository) EnableMFA(ctx context.Context, id uint, step int64, codeHashes []string) (models.User, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	user, err := r.liveUser(id)
+	if err != nil {
+		return models.User{}, err
+	}
+	now := r.s.now()
+	user.MFAEnabledAt = &now
+	user.TOTPLastStep = step
+	user.UpdatedAt = now
+	r.s.users[id] = user
+	r.replaceRecoveryCodes(id, codeHashes)
+	return user, nil
+}
+
+func (r *memoryUserRepository) DisableMFA(ctx context.Context, id uint) (models.User, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	user, err := r.liveUser(id)
+	if err != nil {
+		return models.User{}, err
+	}
+	user.MFAEnabledAt = nil
+	user.TOTPSecret = ""
+	user.TOTPLastStep = 0
+	user.UpdatedAt = r.s.now()
+	r.s.users[id] = user
+	r.replaceRecoveryCodes(id, nil)
+	return user, nil
+}
+
+func (r *memoryUserRepository) UseTOTPStep(ctx context.Context, id uint, step int64) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	user, ok := r.s.users[id]
+	if !ok || user.TOTPLastStep >= step {
+		return ErrMFACodeUsed
+	}
+	user.TOTPLastStep = step
+	r.s.users[id] = user
+	return nil
+}
+
+func (r *memoryUserRepository) UseRecoveryCode(ctx context.Context, id uint, codeHash string) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	for codeID, code := range r.s.recoveryCodes {
+		if code.UserID == id && code.CodeHash == codeHash && code.UsedAt == nil {
+			now := r.s.now()
+			code.UsedAt = &now
+			r.s.recoveryCodes[codeID] = code
+			return nil
+		}
+	}
+	return ErrMFACodeUsed
+}
+
+func (r *memoryUserRepository) ReplaceRecoveryCodes(ctx context.Context, id uint, codeHashes []string) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	r.replaceRecoveryCodes(id, codeHashes)
+	return nil
+}
+
+func (r *memoryUserRepository) replaceRecoveryCodes(id uint, codeHashes []string) {
+	for codeID, code := range r.s.recoveryCodes {
+		if code.UserID == id {
+			delete(r.s.recoveryCodes, codeID)
+		}
+	}
+	for _, hash := range codeHashes {
+		code := models.RecoveryCode{ID: r.s.nextID("recovery_codes"), CreatedAt: r.s.now(), UserID: id, CodeHash: hash}
+		r.s.recoveryCodes[code.ID] = code
+	}
+}
+
+func (r *memoryUserRepository) liveUser(id uint) (models.User, error) {
+	user, ok := r.s.users[id]
+	if !ok || user.DeletedAt.Valid {
+		return models.User{}, ErrUserNotFound
+	}
+	return user, nil
+}
+
+// keepAnAdmin returns ErrLastAdmin when user is the only active admin
+func (r *memoryUserRepository) keepAnAdmin(user models.User) error {
+	if user.Role != models.RoleAdmin || user.IsDisabled() {
+		return nil
+	}
+	for _, other := range r.s.users {
+		if other.ID != user.ID && other.Role == models.RoleAdmin && !other.IsDisabled() && !other.DeletedAt.Valid {
+			return nil
+		}
+	}
+	return ErrLastAdmin
+}
+
+func (r *memoryUserRepository) addRoleChange(change models.RoleChange) {
+	change.ID = r.s.nextID("user_role_changes")
+	change.CreatedAt = r.s.now()
+	r.s.roleChanges = append(r.s.roleChanges, change)
+}
@@ -0,0 +1,187 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"book_order_app/models"
+	"book_order_app/query"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// OrderRepository stores orders with their items and status history, keeping
+// book stock reserved for as long as an order's status holds it
+type OrderRepository interface {
+	List(ctx context.Context, params query.Params, includeDeleted bool) (query.Page[models.Order], error)
+	Get(ctx context.Context, id uint) (models.Order, error)
+	// Create prices every line at the current book price, computes the totals, stores the
+	// order as pending and reserves its stock. ErrBookNotFound is returned when a line
+	// references a missing book and ErrInsufficientStock when a line cannot be filled.
+	Create(ctx context.Context, order *models.Order) error
+	// Delete soft-deletes an order and releases the stock it still holds
+	Delete(ctx context.Context, id uint) error
+	// Restore brings a soft-deleted order back, reserving its stock again if its status holds stock
+	Restore(ctx context.Context, id uint) (models.Order, error)
+	// Transition moves an order to a new status when the lifecycle allows it, releasing
+	// reserved stock when the order leaves a stock-holding status without shipping,
+	// and records the change in the status history
+	Transition(ctx context.Context, id uint, to models.OrderStatus, actorID uint, note string) (models.Order, error)
+	// History returns the status changes of an order, deleted or not, oldest first
+	History(ctx context.Context, id uint) ([]models.OrderStatusChange, error)
+}
+
+type gormOrderRepository struct {
+	db *gorm.DB
+}
+
+func (r *gormOrderRepository) List(ctx context.Context, params query.Params, includeDeleted bool) (query.Page[models.Order], error) {
+	db := r.db.WithContext(ctx)
+	if includeDeleted {
+		db = db.Unscoped()
+	}
+	return query.Find[models.Order](db.Preload("Items"), params)
+}
+
+func (r *gormOrderRepository) Get(ctx context.Context, id uint) (models.Order, error) {
+	var order models.Order
+	if err := r.db.WithContext(ctx).Preload("Items").First(&order, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return models.Order{}, ErrOrderNotFound
+		}
+		return models.Order{}, err
+	}
+	return order, nil
+}
+
+func (r *gormOrderRepository) Create(ctx context.Context, order *models.Order) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		bookIDs := make([]uint, len(order.Items))
+		for i, item := range order.Items {
+			bookIDs[i] = item.BookID
+		}
+
+		// Lock the books in id order so they cannot be deleted or oversold until the order is committed
+		var books []models.Book
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id IN ?", bookIDs).Order("id").Find(&books).Error; err != nil {
+			return err
+		}
+		prices := make(map[uint]float64, len(books))
+		for _, book := range books {
+			prices[book.ID] = book.Price
+		}
+
+		for i := range order.Items {
+			price, ok := prices[order.Items[i].BookID]
+			if !ok {
+				return ErrBookNotFound
+			}
+			order.Items[i].UnitPrice = price
+		}
+		order.Status = models.OrderStatusPending
+		order.CalculateTotals()
+
+		if err := tx.Create(order).Error; err != nil {
+			return err
+		}
+		if err := tx.Create(&models.OrderStatusChange{OrderID: order.ID, ToStatus: order.Status}).Error; err != nil {
+			return err
+		}
+		return moveOrderStock(tx, *order, -1, models.StockReasonOrderPlaced)
+	})
+}
+
+func (r *gormOrderRepository) Delete(ctx context.Context, id uint) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var order models.Order
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Preload("Items").First(&order, id).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrOrderNotFound
+			}
+			return err
+		}
+
+		if err := tx.Delete(&order).Error; err != nil {
+			return err
+		}
+		if !order.Status.HoldsStock() {
+			return nil
+		}
+		return moveOrderStock(tx, order, 1, models.StockReasonOrderCancelled)
+	})
+}
+
+func (r *gormOrderRepository) Restore(ctx context.Context, id uint) (models.Order, error) {
+	var order models.Order
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := restoreByID(tx, &order, id, ErrOrderNotFound); err != nil {
+			return err
+		}
+		if err := tx.Preload("Items").First(&order, id).Error; err != nil {
+			return err
+		}
+		if !order.Status.HoldsStock() {
+			return nil
+		}
+		return moveOrderStock(tx, order, -1, models.StockReasonOrderPlaced)
+	})
+	if err != nil {
+		return models.Order{}, err
+	}
+	return order, nil
+}
+
+func (r *gormOrderRepository) Transition(ctx context.Context, id uint, to models.OrderStatus, actorID uint, note string) (models.Order, error) {
+	var order models.Order
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Preload("Items").First(&order, id).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrOrderNotFound
+			}
+			return err
+		}
+
+		from := order.Status
+		if !from.CanTransitionTo(to) {
+			return fmt.Errorf("%w: %s -> %s", ErrInvalidTransition, from, to)
+		}
+
+		if err := tx.Model(&order).Update("status", to).Error; err != nil {
+			return err
+		}
+		order.Status = to
+		change := models.OrderStatusChange{OrderID: order.ID, FromStatus: from, ToStatus: to, ChangedBy: &actorID, Note: note}
+		if err := tx.Create(&change).Error; err != nil {
+			return err
+		}
+
+		if from.HoldsStock() && !to.HoldsStock() && to != models.OrderStatusShipped {
+			return moveOrderStock(tx, order, 1, models.StockReasonOrderCancelled)
+		}
+		return nil
+	})
+	if err != nil {
+		return models.Order{}, err
+	}
+	return order, nil
+}
+
+func (r *gormOrderRepository) History(ctx context.Context, id uint) ([]models.OrderStatusChange, error) {
+	db := r.db.WithContext(ctx)
+
+	var count int64
+	if err := db.Unscoped().Model(&models.Order{}).Where("id = ?", id).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if count == 0 {
+		return nil, ErrOrderNotFound
+	}
+
+	history := []models.OrderStatusChange{}
+	if err := db.Where("order_id = ?", id).Order("id").Find(&history).Error; err != nil {
+		return nil, err
+	}
+	return history, nil
+}
@@ -0,0 +1,111 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"book_order_app/models"
+
+	"gorm.io/gorm"
+)
+
+// PurgeResult reports how many rows a purge run permanently removed
+type PurgeResult struct {
+	Orders int64 `json:"orders"`
+	Books  int64 `json:"books"`
+	Users  int64 `json:"users"`
+	Tokens int64 `json:"tokens"`
+	// LoginThrottles counts the failed-login records that aged out
+	LoginThrottles int64 `json:"login_throttles"`
+}
+
+// PurgeRepository permanently removes records that can no longer be restored or used
+type PurgeRepository interface {
+	// Purge hard-deletes, in one transaction, the records soft-deleted before
+	// deletedBefore, the tokens and API keys that have expired and the login
+	// throttles whose last failure was before forgetBefore. Orders with their items
+	// and status history go first so that the books and users they reference can be
+	// removed in the same run. Books still referenced by a kept order are skipped to
+	// satisfy fk_order_items_book, users likewise for fk_orders_user, a purged book
+	// takes its stock ledger with it and a purged user its role history, recovery
+	// codes and API keys.
+	Purge(ctx context.Context, deletedBefore, forgetBefore time.Time) (PurgeResult, error)
+}
+
+type gormPurgeRepository struct {
+	db *gorm.DB
+}
+
+func (r *gormPurgeRepository) Purge(ctx context.Context, deletedBefore, forgetBefore time.Time) (PurgeResult, error) {
+	var result PurgeResult
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		expiredOrders := tx.Unscoped().Model(&models.Order{}).Select("id").Where("deleted_at < ?", deletedBefore)
+		if err := tx.Where("order_id IN (?)", expiredOrders).Delete(&models.OrderItem{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("order_id IN (?)", expiredOrders).Delete(&models.OrderStatusChange{}).Error; err != nil {
+			return err
+		}
+
+		res := tx.Unscoped().Where("deleted_at < ?", deletedBefore).Delete(&models.Order{})
+		if res.Error != nil {
+			return res.Error
+		}
+		result.Orders = res.RowsAffected
+
+		expiredBooks := tx.Unscoped().Model(&models.Book{}).
+			Select("id").
+			Where("deleted_at < ?", deletedBefore).
+			Where("NOT EXISTS (SELECT 1 FROM order_items WHERE order_items.book_id = books.id)")
+		if err := tx.Where("book_id IN (?)", expiredBooks).Delete(&models.StockMovement{}).Error; err != nil {
+			return err
+		}
+
+		res = tx.Unscoped().Where("id IN (?)", expiredBooks).Delete(&models.Book{})
+		if res.Error != nil {
+			return res.Error
+		}
+		result.Books = res.RowsAffected
+
+		expiredUsers := tx.Unscoped().Model(&models.User{}).
+			Select("id").
+			Where("deleted_at < ?", deletedBefore).
+			Where("NOT EXISTS (SELECT 1 FROM orders WHERE orders.user_id = users.id)")
+		if err := tx.Where("user_id IN (?)", expiredUsers).Delete(&models.RoleChange{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id IN (?)", expiredUsers).Delete(&models.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id IN (?)", expiredUsers).Delete(&models.APIKey{}).Error; err != nil {
+			return err
+		}
+
+		res = tx.Unscoped().Where("id IN (?)", expiredUsers).Delete(&models.User{})
+		if res.Error != nil {
+			return res.Error
+		}
+		result.Users = res.RowsAffected
+
+		// Expired refresh tokens, denylist entries, one-time tokens and API keys can no longer be used
+		now := time.Now()
+		for _, expired := range []interface{}{&models.RefreshToken{}, &models.RevokedToken{}, &models.OneTimeToken{}, &models.APIKey{}} {
+			res = tx.Where("expires_at < ?", now).Delete(expired)
+			if res.Error != nil {
+				return res.Error
+			}
+			result.Tokens += res.RowsAffected
+		}
+
+		res = tx.Where("last_failure_at < ?", forgetBefore).Delete(&models.LoginThrottle{})
+		if res.Error != nil {
+			return res.Error
+		}
+		result.LoginThrottles = res.RowsAffected
+		return nil
+	})
+	if err != nil {
+		return PurgeResult{}, err
+	}
+	return result, nil
+}
@@ -0,0 +1,71 @@
+// Package repository is the persistence layer behind the services. Every repository
+// has a GORM implementation backed by PostgreSQL and a thread-safe in-memory one for
+// tests; the shared contract suite keeps their behavior identical.
+package repository
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrBookNotFound      = errors.New("book not found")
+	ErrBookHasOrders     = errors.New("book is referenced by open orders")
+	ErrOrderNotFound     = errors.New("order not found")
+	ErrInvalidTransition = errors.New("invalid order status transition")
+	ErrInsufficientStock = errors.New("insufficient stock")
+	ErrUserNotFound      = errors.New("user not found")
+	ErrUsernameTaken     = errors.New("username already exists")
+	ErrEmailTaken        = errors.New("email address is already in use")
+	ErrRoleNotFound      = errors.New("role not found")
+	ErrRoleExists        = errors.New("role already exists")
+	// ErrRoleInUse is returned when deleting a role that users still have
+	ErrRoleInUse = errors.New("role is still assigned to users")
+	// ErrLastAdmin is returned when a change would leave no active admin account
+	ErrLastAdmin = errors.New("the last active admin cannot be demoted, disabled or deleted")
+	// ErrMFAEnabled is returned when starting an MFA enrollment on an account that has MFA already
+	ErrMFAEnabled = errors.New("two-factor authentication is already enabled")
+	// ErrMFACodeUsed is returned when a TOTP step or recovery code was accepted before
+	ErrMFACodeUsed = errors.New("code already used")
+
+	ErrRefreshTokenNotFound = errors.New("refresh token not found")
+	// ErrRefreshTokenRevoked is returned when replacing a refresh token that was already rotated or revoked
+	ErrRefreshTokenRevoked = errors.New("refresh token already revoked")
+
+	ErrOneTimeTokenNotFound = errors.New("one-time token not found")
+	// ErrOneTimeTokenUsed is returned when using a one-time token that was used or superseded already
+	ErrOneTimeTokenUsed = errors.New("one-time token already used")
+
+	ErrAPIKeyNotFound = errors.New("api key not found")
+
+	// ErrNotDeleted is returned when restoring a record that is not in the trash
+	ErrNotDeleted = errors.New("record is not deleted")
+)
+
+// Repositories groups the stores the services are built on
+type Repositories struct {
+	Books          BookRepository
+	Orders         OrderRepository
+	Users          UserRepository
+	Roles          RoleRepository
+	Tokens         TokenRepository
+	LoginThrottles LoginThrottleRepository
+	APIKeys        APIKeyRepository
+	Purger         PurgeRepository
+}
+
+// NewGormRepositories returns repositories backed by db
+func NewGormRepositories(db *gorm.DB) Repositories {
+	return Repositories{
+		Books:  &gormBookRepository{db: db},
+		Orders: &gormOrderRepository{db: db},
+		Users:  &gormUserRepository{db: db},
+		Roles:  &gormRoleRepository{db: db},
+		Tokens: &gormTokenRepository{db: db},
+
+		LoginThrottles: &gormLoginThrottleRepository{db: db},
+		APIKeys:        &gormAPIKeyRepository{db: db},
+		Purger:         &gormPurgeRepository{db: db},
+	}
+}
@@ -0,0 +1,90 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"book_order_app/models"
+
+	"gorm.io/gorm"
+)
+
+// RoleRepository stores the roles users are given and the permissions they grant
+type RoleRepository interface {
+	// ListRoles returns every role ordered by name
+	ListRoles(ctx context.Context) ([]models.Role, error)
+	GetRole(ctx context.Context, name models.UserRole) (models.Role, error)
+	// CreateRole stores a new role; ErrRoleExists is returned when the name is taken
+	CreateRole(ctx context.Context, role *models.Role) error
+	// UpdateRole replaces the description and permissions of a role
+	UpdateRole(ctx context.Context, name models.UserRole, description string, permissions []string) (models.Role, error)
+	// DeleteRole removes a role. ErrRoleInUse is returned while any user, including a
+	// soft-deleted one that could be restored, has it.
+	DeleteRole(ctx context.Context, name models.UserRole) error
+}
+
+type gormRoleRepository struct {
+	db *gorm.DB
+}
+
+func (r *gormRoleRepository) ListRoles(ctx context.Context) ([]models.Role, error) {
+	roles := []models.Role{}
+	err := r.db.WithContext(ctx).Order("name").Find(&roles).Error
+	return roles, err
+}
+
+func (r *gormRoleRepository) GetRole(ctx context.Context, name models.UserRole) (models.Role, error) {
+	return r.first(r.db.WithContext(ctx), name)
+}
+
+func (r *gormRoleRepository) CreateRole(ctx context.Context, role *models.Role) error {
+	err := r.db.WithContext(ctx).Create(role).Error
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		return ErrRoleExists
+	}
+	return err
+}
+
+func (r *gormRoleRepository) UpdateRole(ctx context.Context, name models.UserRole, description string, permissions []string) (models.Role, error) {
+	var role models.Role
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var err error
+		if role, err = r.first(tx, name); err != nil {
+			return err
+		}
+		role.Description = description
+		role.Permissions = permissions
+		return tx.Model(&role).Select("description", "permissions", "updated_at").Updates(&role).Error
+	})
+	if err != nil {
+		return models.Role{}, err
+	}
+	return role, nil
+}
+
+func (r *gormRoleRepository) DeleteRole(ctx context.Context, name models.UserRole) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if _, err := r.first(tx, name); err != nil {
+			return err
+		}
+		var count int64
+		if err := tx.Unscoped().Model(&models.User{}).Where("role = ?", name).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrRoleInUse
+		}
+		return tx.Where("name = ?", name).Delete(&models.Role{}).Error
+	})
+}
+
+func (r *gormRoleRepository) first(db *gorm.DB, name models.UserRole) (models.Role, error) {
+	var role models.Role
+	if err := db.Where("name = ?", name).First(&role).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return models.Role{}, ErrRoleNotFound
+		}
+		return models.Role{}, err
+	}
+	return role, nil
+}
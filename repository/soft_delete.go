@@ -0,0 +1,29 @@
+package repository
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+// restoreByID clears deleted_at on a soft-deleted row and reloads it into dest.
+// notFound is returned when no row, deleted or not, has the given id.
+func restoreByID(db *gorm.DB, dest interface{}, id uint, notFound error) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		res := tx.Unscoped().Model(dest).Where("id = ? AND deleted_at IS NOT NULL", id).Update("deleted_at", nil)
+		if res.Error != nil {
+			return res.Error
+		}
+
+		if err := tx.First(dest, id).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return notFound
+			}
+			return err
+		}
+		if res.RowsAffected == 0 {
+			return ErrNotDeleted
+		}
+		return nil
+	})
+}
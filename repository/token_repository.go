@@ -0,0 +1,178 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"book_order_app/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// TokenRepository stores refresh token families, the access token denylist and
+// one-time tokens
+type TokenRepository interface {
+	CreateRefreshToken(ctx context.Context, token *models.RefreshToken) error
+	// GetRefreshToken finds a refresh token by the hash of its value, revoked or not
+	GetRefreshToken(ctx context.Context, hash string) (models.RefreshToken, error)
+	// ReplaceRefreshToken revokes the live token currentID and stores next as its
+	// replacement. ErrRefreshTokenRevoked is returned, and nothing is stored, when
+	// currentID was revoked already, e.g. by a concurrent refresh.
+	ReplaceRefreshToken(ctx context.Context, currentID uint, next *models.RefreshToken) error
+	// RevokeFamily revokes every live refresh token of a family and denylists the family id until denyUntil
+	RevokeFamily(ctx context.Context, family string, denyUntil time.Time) error
+	// RevokeUserFamilies revokes every family of a user that still holds a live refresh token
+	RevokeUserFamilies(ctx context.Context, userID uint, denyUntil time.Time) error
+	// Denylist records a token or session id until the given time
+	Denylist(ctx context.Context, id string, until time.Time) error
+	// IsDenylisted reports whether any of the ids is on the denylist and has not expired
+	IsDenylisted(ctx context.Context, ids ...string) (bool, error)
+
+	// CreateOneTimeToken stores a one-time token and marks every unused token the
+	// user holds for the same purpose as used, so only the newest one works
+	CreateOneTimeToken(ctx context.Context, token *models.OneTimeToken) error
+	// GetOneTimeToken finds a token of the given purpose by the hash of its value, used or not
+	GetOneTimeToken(ctx context.Context, purpose models.TokenPurpose, hash string) (models.OneTimeToken, error)
+	// UseOneTimeToken marks a token as used. ErrOneTimeTokenUsed is returned when it
+	// was used already, e.g. by a concurrent request.
+	UseOneTimeToken(ctx context.Context, id uint) error
+}
+
+type gormTokenRepository struct {
+	db *gorm.DB
+}
+
+func (r *gormTokenRepository) CreateRefreshToken(ctx context.Context, token *models.RefreshToken) error {
+	return r.db.WithContext(ctx).Create(token).Error
+}
+
+func (r *gormTokenRepository) GetRefreshToken(ctx context.Context, hash string) (models.RefreshToken, error) {
+	var token models.RefreshToken
+	if err := r.db.WithContext(ctx).Where("token_hash = ?", hash).First(&token).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return models.RefreshToken{}, ErrRefreshTokenNotFound
+		}
+		return models.RefreshToken{}, err
+	}
+	return token, nil
+}
+
+func (r *gormTokenRepository) ReplaceRefreshToken(ctx context.Context, currentID uint, next *models.RefreshToken) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(next).Error; err != nil {
+			return err
+		}
+
+		// The row lock taken by the update makes a concurrent refresh of the same
+		// token wait, then find it revoked and roll back
+		res := tx.Model(&models.RefreshToken{}).
+			Where("id = ? AND revoked_at IS NULL", currentID).
+			Updates(map[string]interface{}{
+				"revoked_at":     time.Now(),
+				"replaced_by_id": next.ID,
+			})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return ErrRefreshTokenRevoked
+		}
+		return nil
+	})
+}
+
+func (r *gormTokenRepository) RevokeFamily(ctx context.Context, family string, denyUntil time.Time) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return revokeFamily(tx, family, denyUntil)
+	})
+}
+
+func (r *gormTokenRepository) RevokeUserFamilies(ctx context.Context, userID uint, denyUntil time.Time) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var families []string
+		err := tx.Model(&models.RefreshToken{}).
+			Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, time.Now()).
+			Distinct().
+			Pluck("family_id", &families).Error
+		if err != nil {
+			return err
+		}
+		for _, family := range families {
+			if err := revokeFamily(tx, family, denyUntil); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (r *gormTokenRepository) Denylist(ctx context.Context, id string, until time.Time) error {
+	return denylist(r.db.WithContext(ctx), id, until)
+}
+
+func (r *gormTokenRepository) IsDenylisted(ctx context.Context, ids ...string) (bool, error) {
+	var count int64
+	err := r.db.WithContext(ctx).Model(&models.RevokedToken{}).
+		Where("token_id IN ? AND expires_at > ?", ids, time.Now()).
+		Count(&count).Error
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+func (r *gormTokenRepository) CreateOneTimeToken(ctx context.Context, token *models.OneTimeToken) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&models.OneTimeToken{}).
+			Where("user_id = ? AND purpose = ? AND used_at IS NULL", token.UserID, token.Purpose).
+			Update("used_at", time.Now()).Error
+		if err != nil {
+			return err
+		}
+		return tx.Create(token).Error
+	})
+}
+
+func (r *gormTokenRepository) GetOneTimeToken(ctx context.Context, purpose models.TokenPurpose, hash string) (models.OneTimeToken, error) {
+	var token models.OneTimeToken
+	if err := r.db.WithContext(ctx).Where("purpose = ? AND token_hash = ?", purpose, hash).First(&token).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return models.OneTimeToken{}, ErrOneTimeTokenNotFound
+		}
+		return models.OneTimeToken{}, err
+	}
+	return token, nil
+}
+
+func (r *gormTokenRepository) UseOneTimeToken(ctx context.Context, id uint) error {
+	res := r.db.WithContext(ctx).Model(&models.OneTimeToken{}).
+		Where("id = ? AND used_at IS NULL", id).
+		Update("used_at", time.Now())
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrOneTimeTokenUsed
+	}
+	return nil
+}
+
+func revokeFamily(tx *gorm.DB, family string, denyUntil time.Time) error {
+	err := tx.Model(&models.RefreshToken{}).
+		Where("family_id = ? AND revoked_at IS NULL", family).
+		Update("revoked_at", time.Now()).Error
+	if err != nil {
+		return err
+	}
+	return denylist(tx, family, denyUntil)
+}
+
+func denylist(db *gorm.DB, id string, until time.Time) error {
+	entry := models.RevokedToken{TokenID: id, ExpiresAt: until}
+	return db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "token_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"expires_at"}),
+	}).Create(&entry).Error
+}
@@ -0,0 +1,450 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"book_order_app/models"
+	"book_order_app/query"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// UserRepository stores user accounts
+type UserRepository interface {
+	// Create stores a new user, hashing its password, and records its role as the first
+	// entry of its role history, granted by actorID (nil when nobody is signed in).
+	// ErrUsernameTaken is returned when any user, including a soft-deleted one, already
+	// holds the username, ErrEmailTaken when one holds the email address.
+	Create(ctx context.Context, user *models.User, actorID *uint, note string) error
+	GetByID(ctx context.Context, id uint) (models.User, error)
+	// GetByIDWithDeleted is GetByID that also finds soft-deleted users
+	GetByIDWithDeleted(ctx context.Context, id uint) (models.User, error)
+	GetByUsername(ctx context.Context, username string) (models.User, error)
+	// HasRole reports whether a live user has the given role
+	HasRole(ctx context.Context, role models.UserRole) (bool, error)
+	List(ctx context.Context, params query.Params, includeDeleted bool) (query.Page[models.User], error)
+	Restore(ctx context.Context, id uint) (models.User, error)
+	// RoleHistory returns the role changes of a user, deleted or not, oldest first
+	RoleHistory(ctx context.Context, id uint) ([]models.RoleChange, error)
+	// SetRole changes a user's role and records the change. Setting the current role
+	// changes nothing. ErrLastAdmin is returned when demoting the last active admin.
+	SetRole(ctx context.Context, id uint, role models.UserRole, actorID uint, note string) (models.User, error)
+	// SetDisabled disables or re-enables an account. ErrLastAdmin is returned when
+	// disabling the last active admin.
+	SetDisabled(ctx context.Context, id uint, disabled bool) (models.User, error)
+	// SetPassword hashes and stores a new password and records whether the user must
+	// choose another one
+	SetPassword(ctx context.Context, id uint, password string, resetRequired bool) (models.User, error)
+	// UpdateProfile stores the display name, email, email verification and shipping
+	// address of user. ErrEmailTaken is returned when another user, deleted or not,
+	// has the email.
+	UpdateProfile(ctx context.Context, user *models.User) error
+	// VerifyEmail marks email as verified for the user, as long as it is still the
+	// user's address; ErrUserNotFound is returned otherwise
+	VerifyEmail(ctx context.Context, id uint, email string) (models.User, error)
+	// Delete soft-deletes a user. ErrLastAdmin is returned when deleting the last active admin.
+	Delete(ctx context.Context, id uint) error
+
+	// StartMFAEnrollment stores a new TOTP secret for a user who has not enabled MFA
+	// yet; ErrMFAEnabled is returned otherwise
+	StartMFAEnrollment(ctx context.Context, id uint, secret string) (models.User, error)
+	// EnableMFA turns MFA on, recording step as the last TOTP step used, and
+	// replaces the user's recovery codes with the given hashes
+	EnableMFA(ctx context.Context, id uint, step int64, codeHashes []string) (models.User, error)
+	// DisableMFA turns MFA off and removes the secret and every recovery code
+	DisableMFA(ctx context.Context, id uint) (models.User, error)
+	// UseTOTPStep records step as used. ErrMFACodeUsed is returned unless it is later
+	// than the last step used, so each code works once.
+	UseTOTPStep(ctx context.Context, id uint, step int64) error
+	// UseRecoveryCode marks the unused recovery code with the hash as used;
+	// ErrMFACodeUsed is returned when the user has none
+	UseRecoveryCode(ctx context.Context, id uint, codeHash string) error
+	// ReplaceRecoveryCodes swaps every recovery code of a user for the given hashes
+	ReplaceRecoveryCodes(ctx context.Context, id uint, codeHashes []string) error
+}
+
+type gormUserRepository struct {
+	db *gorm.DB
+}
+
+func (r *gormUserRepository) Create(ctx context.Context, user *models.User, actorID *uint, note string) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Unscoped().Model(&models.User{}).Where("username = ?", user.Username).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrUsernameTaken
+		}
+		if user.Email != nil {
+			if err := tx.Unscoped().Model(&models.User{}).Where("email = ?", *user.Email).Count(&count).Error; err != nil {
+				return err
+			}
+			if count > 0 {
+				return ErrEmailTaken
+			}
+		}
+
+		if user.Role == "" {
+			user.Role = models.RoleUser
+		}
+		// The password is hashed by the BeforeCreate hook
+		if err := tx.Create(user).Error; err != nil {
+			if errors.Is(err, gorm.ErrDuplicatedKey) {
+				return ErrUsernameTaken
+			}
+			return err
+		}
+		return tx.Create(&models.RoleChange{UserID: user.ID, ToRole: user.Role, ChangedBy: actorID, Note: note}).Error
+	})
+}
+
+func (r *gormUserRepository) GetByID(ctx context.Context, id uint) (models.User, error) {
+	return r.first(r.db.WithContext(ctx).Where("id = ?", id))
+}
+
+func (r *gormUserRepository) GetByIDWithDeleted(ctx context.Context, id uint) (models.User, error) {
+	return r.first(r.db.WithContext(ctx).Unscoped().Where("id = ?", id))
+}
+
+func (r *gormUserRepository) GetByUsername(ctx context.Context, username string) (models.User, error) {
+	return r.first(r.db.WithContext(ctx).Where("username = ?", username))
+}
+
+func (r *gormUserRepository) HasRole(ctx context.Context, role models.UserRole) (bool, error) {
+	var count int64
+	if err := r.db.WithContext(ctx).Model(&models.User{}).Where("role = ?", role).Count(&count).Error; err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+func (r *gormUserRepository) List(ctx context.Context, params query.Params, includeDeleted bool) (query.Page[models.User], error) {
+	db := r.db.WithContext(ctx)
+	if includeDeleted {
+		db = db.Unscoped()
+	}
+	return query.Find[models.User](db, params)
+}
+
+func (r *gormUserRepository) Restore(ctx context.Context, id uint) (models.User, error) {
+	var user models.User
+	if err := restoreByID(r.db.WithContext(ctx), &user, id, ErrUserNotFound); err != nil {
+		return models.User{}, err
+	}
+	return user, nil
+}
+
+func (r *gormUserRepository) RoleHistory(ctx context.Context, id uint) ([]models.RoleChange, error) {
+	db := r.db.WithContext(ctx)
+
+	var count int64
+	if err := db.Unscoped().Model(&models.User{}).Where("id = ?", id).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if count == 0 {
+		return nil, ErrUserNotFound
+	}
+
+	history := []models.RoleChange{}
+	if err := db.Where("user_id = ?", id).Order("id").Find(&history).Error; err != nil {
+		return nil, err
+	}
+	return history, nil
+}
+
+func (r *gormUserRepository) SetRole(ctx context.Context, id uint, role models.UserRole, actorID uint, note string) (models.User, error) {
+	var user models.User
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var err error
+		if user, err = r.lockForUpdate(tx, id); err != nil {
+			return err
+		}
+		if user.Role == role {
+			return nil
+		}
+		if role != models.RoleAdmin {
+			if err := keepAnAdmin(tx, user); err != nil {
+				return err
+			}
+		}
+
+		change := models.RoleChange{UserID: id, FromRole: user.Role, ToRole: role, ChangedBy: &actorID, Note: note}
+		if err := tx.Model(&user).Update("role", role).Error; err != nil {
+			return err
+		}
+		if err := tx.Create(&change).Error; err != nil {
+			return err
+		}
+		user, err = r.first(tx.Where("id = ?", id))
+		return err
+	})
+	if err != nil {
+		return models.User{}, err
+	}
+	return user, nil
+}
+
+func (r *gormUserRepository) SetDisabled(ctx context.Context, id uint, disabled bool) (models.User, error) {
+	var user models.User
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var err error
+		if user, err = r.lockForUpdate(tx, id); err != nil {
+			return err
+		}
+		if user.IsDisabled() == disabled {
+			return nil
+		}
+		var disabledAt *time.Time
+		if disabled {
+			if err := keepAnAdmin(tx, user); err != nil {
+				return err
+			}
+			now := time.Now()
+			disabledAt = &now
+		}
+		if err := tx.Model(&user).Update("disabled_at", disabledAt).Error; err != nil {
+			return err
+		}
+		user, err = r.first(tx.Where("id = ?", id))
+		return err
+	})
+	if err != nil {
+		return models.User{}, err
+	}
+	return user, nil
+}
+
+func (r *gormUserRepository) SetPassword(ctx context.Context, id uint, password string, resetRequired bool) (models.User, error) {
+	user := models.User{ID: id, Password: password}
+	if err := user.HashPassword(); err != nil {
+		return models.User{}, err
+	}
+
+	db := r.db.WithContext(ctx)
+	res := db.Model(&models.User{ID: id}).Updates(map[string]interface{}{
+		"password":                user.Password,
+		"password_reset_required": resetRequired,
+	})
+	if res.Error != nil {
+		return models.User{}, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return models.User{}, ErrUserNotFound
+	}
+	return r.first(db.Where("id = ?", id))
+}
+
+func (r *gormUserRepository) UpdateProfile(ctx context.Context, user *models.User) error {
+	db := r.db.WithContext(ctx)
+
+	if user.Email != nil {
+		var count int64
+		if err := db.Unscoped().Model(&models.User{}).Where("email = ? AND id <> ?", *user.Email, user.ID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrEmailTaken
+		}
+	}
+
+	res := db.Model(user).Select("DisplayName", "Email", "EmailVerifiedAt", "ShippingAddress").Updates(user)
+	if res.Error != nil {
+		if errors.Is(res.Error, gorm.ErrDuplicatedKey) {
+			return ErrEmailTaken
+		}
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrUserNotFound
+	}
+	updated, err := r.first(db.Where("id = ?", user.ID))
+	if err != nil {
+		return err
+	}
+	*user = updated
+	return nil
+}
+
+func (r *gormUserRepository) VerifyEmail(ctx context.Context, id uint, email string) (models.User, error) {
+	db := r.db.WithContext(ctx)
+	res := db.Model(&models.User{}).
+		Where("id = ? AND email = ?", id, email).
+		Update("email_verified_at", gorm.Expr("COALESCE(email_verified_at, ?)", time.Now()))
+	if res.Error != nil {
+		return models.User{}, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return models.User{}, ErrUserNotFound
+	}
+	return r.first(db.Where("id = ?", id))
+}
+
+func (r *gormUserRepository) Delete(ctx context.Context, id uint) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		user, err := r.lockForUpdate(tx, id)
+		if err != nil {
+			return err
+		}
+		if err := keepAnAdmin(tx, user); err != nil {
+			return err
+		}
+		return tx.Delete(&models.User{}, id).Error
+	})
+}
+
+// lockForUpdate loads a live user and locks its row until the transaction ends
+func (r *gormUserRepository) StartMFAEnrollment(ctx context.Context, id uint, secret string) (models.User, error) {
+	var user models.User
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var err error
+		if user, err = r.lockForUpdate(tx, id); err != nil {
+			return err
+		}
+		if user.MFAEnabled() {
+			return ErrMFAEnabled
+		}
+		if err := tx.Model(&user).Updates(map[string]interface{}{"totp_secret": secret, "totp_last_step": 0}).Error; err != nil {
+			return err
+		}
+		user, err = r.first(tx.Where("id = ?", id))
+		return err
+	})
+	if err != nil {
+		return models.User{}, err
+	}
+	return user, nil
+}
+
+func (r *gormUserRepository) EnableMFA(ctx context.Context, id uint, step int64, codeHashes []string) (models.User, error) {
+	var user models.User
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var err error
+		if user, err = r.lockForUpdate(tx, id); err != nil {
+			return err
+		}
+		err = tx.Model(&user).Updates(map[string]interface{}{"mfa_enabled_at": time.Now(), "totp_last_step": step}).Error
+		if err != nil {
+			return err
+		}
+		if err := replaceRecoveryCodes(tx, id, codeHashes); err != nil {
+			return err
+		}
+		user, err = r.first(tx.Where("id = ?", id))
+		return err
+	})
+	if err != nil {
+		return models.User{}, err
+	}
+	return user, nil
+}
+
+func (r *gormUserRepository) DisableMFA(ctx context.Context, id uint) (models.User, error) {
+	var user models.User
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var err error
+		if user, err = r.lockForUpdate(tx, id); err != nil {
+			return err
+		}
+		err = tx.Model(&user).Updates(map[string]interface{}{"mfa_enabled_at": nil, "totp_secret": "", "totp_last_step": 0}).Error
+		if err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", id).Delete(&models.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		user, err = r.first(tx.Where("id = ?", id))
+		return err
+	})
+	if err != nil {
+		return models.User{}, err
+	}
+	return user, nil
+}
+
+func (r *gormUserRepository) UseTOTPStep(ctx context.Context, id uint, step int64) error {
+	res := r.db.WithContext(ctx).Model(&models.User{}).
+		Where("id = ? AND totp_last_step < ?", id, step).
+		Update("totp_last_step", step)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrMFACodeUsed
+	}
+	return nil
+}
+
+func (r *gormUserRepository) UseRecoveryCode(ctx context.Context, id uint, codeHash string) error {
+	res := r.db.WithContext(ctx).Model(&models.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", id, codeHash).
+		Update("used_at", time.Now())
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrMFACodeUsed
+	}
+	return nil
+}
+
+func (r *gormUserRepository) ReplaceRecoveryCodes(ctx context.Context, id uint, codeHashes []string) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return replaceRecoveryCodes(tx, id, codeHashes)
+	})
+}
+
+func replaceRecoveryCodes(tx *gorm.DB, id uint, codeHashes []string) error {
+	if err := tx.Where("user_id = ?", id).Delete(&models.RecoveryCode{}).Error; err != nil {
+		return err
+	}
+	codes := make([]models.RecoveryCode, len(codeHashes))
+	for i, hash := range codeHashes {
+		codes[i] = models.RecoveryCode{UserID: id, CodeHash: hash}
+	}
+	if len(codes) == 0 {
+		return nil
+	}
+	return tx.Create(&codes).Error
+}
+
+func (r *gormUserRepository) lockForUpdate(tx *gorm.DB, id uint) (models.User, error) {
+	return r.first(tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", id))
+}
+
+// keepAnAdmin returns ErrLastAdmin when user is the only active admin. Every active
+// admin row is locked, so concurrent demotions of two admins cannot both succeed.
+func keepAnAdmin(tx *gorm.DB, user models.User) error {
+	if user.Role != models.RoleAdmin || user.IsDisabled() {
+		return nil
+	}
+	var ids []uint
+	err := tx.Model(&models.User{}).
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("role = ? AND disabled_at IS NULL", models.RoleAdmin).
+		Order("id").
+		Pluck("id", &ids).Error
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if id != user.ID {
+			return nil
+		}
+	}
+	return ErrLastAdmin
+}
+
+func (r *gormUserRepository) first(db *gorm.DB) (models.User, error) {
+	var user models.User
+	if err := db.First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return models.User{}, ErrUserNotFound
+		}
+		return models.User{}, err
+	}
+	return user, nil
+}
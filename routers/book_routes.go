@@ -3,16 +3,31 @@ package routers
 import (
 	"book_order_app/controllers"
 	"book_order_app/middleware"
+	"book_order_app/models"
 
 	"github.com/gin-gonic/gin"
 )
 
-func RegisterBookRoutes(rg *gin.RouterGroup) {
-	bookController := controllers.InitializeBookController()
+func RegisterBookRoutes(rg *gin.RouterGroup, svc Services) {
+	bookController := controllers.InitializeBookController(svc.Books)
+	inventoryController := controllers.InitializeInventoryController(svc.Inventory)
 	books := rg.Group("/books")
 	{
 		books.GET("", bookController.GetBooks)
+		books.GET("/search", bookController.SearchBooks)
 		books.GET("/:bookId", bookController.GetBookById)
-		books.POST("", middleware.AuthMiddleware(), middleware.RequireRole("admin"), bookController.AddBook)
+		books.POST("", svc.Auth.Authenticate(), svc.Auth.RequirePermission(models.PermBooksWrite), middleware.RequireScope("books"), bookController.AddBook)
+		books.PUT("/:bookId", svc.Auth.Authenticate(), svc.Auth.RequirePermission(models.PermBooksWrite), middleware.RequireScope("books"), bookController.UpdateBook)
+		books.PATCH("/:bookId", svc.Auth.Authenticate(), svc.Auth.RequirePermission(models.PermBooksWrite), middleware.RequireScope("books"), bookController.PatchBook)
+		books.DELETE("/:bookId", svc.Auth.Authenticate(), svc.Auth.RequirePermission(models.PermBooksWrite), middleware.RequireScope("books"), bookController.DeleteBook)
+	}
+
+	// Trash and inventory management for staff
+	adminBooks := rg.Group("/admin/books", svc.Auth.Authenticate(), middleware.RequireScope("books"))
+	{
+		adminBooks.GET("", svc.Auth.RequirePermission(models.PermBooksWrite), bookController.ListBooksAdmin)
+		adminBooks.POST("/:bookId/restore", svc.Auth.RequirePermission(models.PermBooksWrite), bookController.RestoreBook)
+		adminBooks.POST("/:bookId/stock", svc.Auth.RequirePermission(models.PermInventoryWrite), inventoryController.AdjustStock)
+		adminBooks.GET("/:bookId/stock-movements", svc.Auth.RequirePermission(models.PermInventoryRead), inventoryController.GetStockMovements)
 	}
 }
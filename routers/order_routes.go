@@ -2,15 +2,34 @@ package routers
 
 import (
 	"book_order_app/controllers"
+	"book_order_app/middleware"
+	"book_order_app/models"
 
 	"github.com/gin-gonic/gin"
 )
 
-func RegisterOrderRoutes(rg *gin.RouterGroup) {
-	orderController := controllers.InitializeOrderController()
-	orders := rg.Group("/orders")
+func RegisterOrderRoutes(rg *gin.RouterGroup, svc Services) {
+	orderController := controllers.InitializeOrderController(svc.Orders)
+	orders := rg.Group("/orders", svc.Auth.Authenticate(), middleware.RequireScope("orders"))
 	{
 		orders.GET("", orderController.GetOrders)
 		orders.POST("", orderController.PlaceOrder)
+		orders.GET("/:orderId", orderController.GetOrder)
+		orders.POST("/:orderId/cancel", orderController.CancelOrder)
+		orders.POST("/:orderId/ship", svc.Auth.RequirePermission(models.PermOrdersFulfill), orderController.ShipOrder)
+		orders.POST("/:orderId/deliver", svc.Auth.RequirePermission(models.PermOrdersFulfill), orderController.DeliverOrder)
+		orders.POST("/:orderId/refund", svc.Auth.RequirePermission(models.PermOrdersRefund), orderController.RefundOrder)
+	}
+
+	rg.GET("/users/me/orders", svc.Auth.Authenticate(), middleware.RequireScope("orders"), orderController.GetMyOrders)
+
+	// Lifecycle and trash management for staff
+	adminOrders := rg.Group("/admin/orders", svc.Auth.Authenticate(), middleware.RequireScope("orders"))
+	{
+		adminOrders.GET("", svc.Auth.RequirePermission(models.PermOrdersReadAny), orderController.ListOrdersAdmin)
+		adminOrders.DELETE("/:orderId", svc.Auth.RequirePermission(models.PermOrdersManage), orderController.DeleteOrder)
+		adminOrders.POST("/:orderId/restore", svc.Auth.RequirePermission(models.PermOrdersManage), orderController.RestoreOrder)
+		adminOrders.POST("/:orderId/status", svc.Auth.RequirePermission(models.PermOrdersManage), orderController.UpdateOrderStatus)
+		adminOrders.GET("/:orderId/history", svc.Auth.RequirePermission(models.PermOrdersReadAny), orderController.GetOrderHistory)
 	}
 }
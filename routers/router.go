@@ -1,18 +1,78 @@
 package routers
 
 import (
+	"book_order_app/config"
+	"book_order_app/controllers"
+	"book_order_app/mailer"
+	"book_order_app/middleware"
+	"book_order_app/repository"
+	"book_order_app/services"
+
 	"github.com/gin-gonic/gin"
 	swaggerFiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
 )
 
-func RegisterRoutes(r *gin.Engine) {
+// Services are the dependencies the routes are wired with. They are built once
+// at startup and can be swapped for other implementations in tests.
+type Services struct {
+	// Auth authenticates the requests of the routes that need it
+	Auth *middleware.Auth
+	// AccessTokens signs access tokens and publishes the keys to verify them
+	AccessTokens *middleware.AccessTokens
+
+	Books          services.BookService
+	Orders         services.OrderService
+	Inventory      services.InventoryService
+	Users          services.UserService
+	Tokens         services.TokenService
+	PasswordResets services.PasswordResetService
+	Verifications  services.EmailVerificationService
+	MFA            services.MFAService
+	APIKeys        services.APIKeyService
+	Roles          services.RoleService
+	Purge          services.PurgeService
+}
+
+// NewServices builds every service on top of repos as cfg sets them up, signing
+// access tokens with keys and sending mail through mail
+func NewServices(cfg *config.Config, keys *middleware.KeySet, repos repository.Repositories, mail mailer.Mailer) Services {
+	access := middleware.NewAccessTokens(cfg.Auth, keys)
+	svc := Services{
+		AccessTokens:   access,
+		Books:          services.NewBookService(repos.Books),
+		Orders:         services.NewOrderService(cfg, repos.Orders, repos.Users),
+		Inventory:      services.NewInventoryService(repos.Books),
+		Users:          services.NewUserService(cfg, repos.Users, repos.Roles, repos.LoginThrottles),
+		Tokens:         services.NewTokenService(cfg, access, repos.Tokens, repos.Users),
+		PasswordResets: services.NewPasswordResetService(cfg, repos.Tokens, repos.Users, mail),
+		Verifications:  services.NewEmailVerificationService(cfg, repos.Tokens, repos.Users, mail),
+		MFA:            services.NewMFAService(cfg, repos.Users, repos.Roles, repos.Tokens, repos.LoginThrottles),
+		APIKeys:        services.NewAPIKeyService(repos.APIKeys, repos.Users),
+		Roles:          services.NewRoleService(repos.Roles, repos.Users),
+		Purge:          services.NewPurgeService(cfg, repos.Purger),
+	}
+	// Revoked access tokens are rejected on every route, API keys are accepted in
+	// place of access tokens, and callers get the permissions their role grants at
+	// the time of the request
+	svc.Auth = middleware.NewAuth(access, svc.Tokens, svc.APIKeys, svc.Roles, cfg.Auth.RequireAdminMFA)
+	return svc
+}
+
+func RegisterRoutes(r *gin.Engine, svc Services) {
+	// Every request gets an id, and errors are rendered as application/problem+json
+	r.Use(middleware.RequestID(), controllers.ErrorHandler())
+	r.NoRoute(controllers.NoRoute)
+
 	// Swagger endpoint
 	r.GET("/swagger-ui/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
 
+	// Public keys for verifying access tokens elsewhere
+	r.GET("/.well-known/jwks.json", controllers.InitializeWellKnownController(svc.AccessTokens).JWKS)
+
 	api := r.Group("/api/v1")
 
-	RegisterBookRoutes(api)
-	RegisterOrderRoutes(api)
-  RegisterUserRoutes(api)
+	RegisterBookRoutes(api, svc)
+	RegisterOrderRoutes(api, svc)
+	RegisterUserRoutes(api, svc)
 }
@@ -0,0 +1,1269 @@
+package routers_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"database/sql/driver"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"book_order_app/config"
+	"book_order_app/controllers"
+	"book_order_app/mailer"
+	"book_order_app/middleware"
+	"book_order_app/models"
+	"book_order_app/query"
+	"book_order_app/repository"
+	"book_order_app/routers"
+	"book_order_app/totp"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Run with -update after an intended response change and review the diff of testdata
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	os.Exit(m.Run())
+}
+
+// server is the API wired to a fresh in-memory store seeded with an admin, a
+// regular user and one book with five copies in stock
+type server struct {
+	t      *testing.T
+	router *gin.Engine
+	repos  repository.Repositories
+	tokens map[string]models.AuthResponse
+	// apiKeys are sent in place of a token for the names they are stored under
+	apiKeys map[string]string
+	// mailDir holds every mail the server sent, one .eml file each
+	mailDir   string
+	mailsRead int
+}
+
+var seedUsers = []models.User{
+	{Username: "admin", Password: "admin-password", Role: models.RoleAdmin},
+	{Username: "reader", Password: "reader-password", Role: models.RoleUser},
+}
+
+func newServer(t *testing.T) *server {
+	t.Helper()
+	return newServerWith(t, config.Default(), repository.NewMemoryRepositories())
+}
+
+// newServerWith seeds repos and serves the API from them as cfg sets it up, so
+// tests can change the configuration or wrap a store to make it fail
+func newServerWith(t *testing.T, cfg *config.Config, repos repository.Repositories) *server {
+	t.Helper()
+	ctx := context.Background()
+
+	for _, user := range seedUsers {
+		if err := repos.Users.Create(ctx, &user, nil, ""); err != nil {
+			t.Fatalf("seed user: %v", err)
+		}
+	}
+	book := models.Book{Title: "The Go Programming Language", Author: "Alan A. A. Donovan", Price: 29.99}
+	if err := repos.Books.Create(ctx, &book); err != nil {
+		t.Fatalf("seed book: %v", err)
+	}
+	movement := models.StockMovement{BookID: book.ID, Delta: 5, Reason: models.StockReasonRestock}
+	if _, err := repos.Books.AdjustStock(ctx, movement); err != nil {
+		t.Fatalf("seed stock: %v", err)
+	}
+
+	s := &server{t: t, repos: repos, tokens: map[string]models.AuthResponse{}, apiKeys: map[string]string{}, mailDir: t.TempDir()}
+	s.restart(cfg)
+	for _, user := range seedUsers {
+		s.tokens[user.Username] = s.login(user.Username, user.Password)
+	}
+	return s
+}
+
+// restart serves the API from the same store as cfg now sets it up, as after a
+// redeploy with a new configuration
+func (s *server) restart(cfg *config.Config) {
+	s.t.Helper()
+	keys, err := middleware.LoadKeySet(cfg.Auth)
+	if err != nil {
+		s.t.Fatalf("load keys: %v", err)
+	}
+	r := gin.New()
+	svc := routers.NewServices(cfg, keys, s.repos, mailer.NewFileMailer("test@example.com", s.mailDir))
+	if err := svc.Roles.SeedBuiltIn(context.Background()); err != nil {
+		s.t.Fatalf("seed roles: %v", err)
+	}
+	routers.RegisterRoutes(r, svc)
+	s.router = r
+}
+
+// do sends a request as the named seed user; an empty name sends no credentials
+func (s *server) do(method, path, body, as string) *httptest.ResponseRecorder {
+	s.t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	switch as {
+	case "":
+	case "forged":
+		req.Header.Set("Authorization", "Bearer not.a.jwt")
+	case "forged_key":
+		req.Header.Set(middleware.APIKeyHeader, models.APIKeyPrefix+"unknown")
+	default:
+		if key, ok := s.apiKeys[as]; ok {
+			req.Header.Set(middleware.APIKeyHeader, key)
+			break
+		}
+		auth, ok := s.tokens[as]
+		if !ok {
+			s.t.Fatalf("no session for %q", as)
+		}
+		req.Header.Set("Authorization", "Bearer "+auth.Token)
+	}
+
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	return w
+}
+
+func (s *server) login(username, password string) models.AuthResponse {
+	s.t.Helper()
+	body, _ := json.Marshal(models.LoginRequest{Username: username, Password: password})
+	w := s.do(http.MethodPost, "/api/v1/users/login", string(body), "")
+	if w.Code != http.StatusOK {
+		s.t.Fatalf("login %s: %d %s", username, w.Code, w.Body)
+	}
+	var resp models.AuthResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		s.t.Fatal(err)
+	}
+	return resp
+}
+
+func TestRoutes(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		as     string
+		status int
+	}{
+		// Accounts
+		{"register", "POST", "/api/v1/users/register", `{"username":"newbie","password":"secret123"}`, "", 201},
+		{"register_cannot_choose_admin", "POST", "/api/v1/users/register", `{"username":"newbie","password":"secret123","role":"admin"}`, "", 201},
+		{"register_duplicate_username", "POST", "/api/v1/users/register", `{"username":"reader","password":"secret123"}`, "", 409},
+		{"register_short_password", "POST", "/api/v1/users/register", `{"username":"newbie","password":"123"}`, "", 400},
+		{"register_malformed_json", "POST", "/api/v1/users/register", `{"username":`, "", 400},
+		{"login", "POST", "/api/v1/users/login", `{"username":"reader","password":"reader-password"}`, "", 200},
+		{"login_wrong_password", "POST", "/api/v1/users/login", `{"username":"reader","password":"guess"}`, "", 401},
+		{"login_unknown_user", "POST", "/api/v1/users/login", `{"username":"nobody","password":"guess"}`, "", 401},
+		{"login_missing_password", "POST", "/api/v1/users/login", `{"username":"reader"}`, "", 400},
+		{"profile", "GET", "/api/v1/users/profile", "", "reader", 200},
+		{"profile_without_token", "GET", "/api/v1/users/profile", "", "", 401},
+		{"profile_forged_token", "GET", "/api/v1/users/profile", "", "forged", 401},
+		{"register_with_email", "POST", "/api/v1/users/register", `{"username":"newbie","password":"secret123","email":" Newbie@Example.com "}`, "", 201},
+		{"register_invalid_email", "POST", "/api/v1/users/register", `{"username":"newbie","password":"secret123","email":"newbie"}`, "", 400},
+		{"verify_email_unknown_token", "POST", "/api/v1/users/verify-email", `{"token":"unknown"}`, "", 400},
+		{"resend_verification_without_email", "POST", "/api/v1/users/verify-email/resend", "", "reader", 400},
+		{"register_password_is_username", "POST", "/api/v1/users/register", `{"username":"newbie123","password":"NEWBIE123"}`, "", 400},
+		{"update_profile", "PATCH", "/api/v1/users/profile", `{"display_name":" Reader ","email":"Reader@Example.com","shipping_address":"1 Main Street"}`, "reader", 200},
+		{"update_profile_invalid_email", "PATCH", "/api/v1/users/profile", `{"email":"Reader <reader@example.com>"}`, "reader", 400},
+		{"update_profile_without_token", "PATCH", "/api/v1/users/profile", `{"display_name":"Reader"}`, "", 401},
+		{"change_password", "POST", "/api/v1/users/password", `{"current_password":"reader-password","new_password":"a much longer secret"}`, "reader", 200},
+		{"change_password_wrong_current", "POST", "/api/v1/users/password", `{"current_password":"guess","new_password":"a much longer secret"}`, "reader", 400},
+		{"change_password_too_short", "POST", "/api/v1/users/password", `{"current_password":"reader-password","new_password":"short"}`, "reader", 400},
+		{"change_password_unchanged", "POST", "/api/v1/users/password", `{"current_password":"reader-password","new_password":"reader-password"}`, "reader", 400},
+		{"refresh_unknown_token", "POST", "/api/v1/users/refresh", `{"refresh_token":"unknown"}`, "", 401},
+		{"forgot_password", "POST", "/api/v1/users/password/forgot", `{"username":"reader"}`, "", 202},
+		{"forgot_password_unknown_user", "POST", "/api/v1/users/password/forgot", `{"username":"nobody"}`, "", 202},
+		{"forgot_password_missing_username", "POST", "/api/v1/users/password/forgot", `{}`, "", 400},
+		{"reset_password_unknown_token", "POST", "/api/v1/users/password/reset", `{"token":"unknown","new_password":"a much longer secret"}`, "", 400},
+		{"mfa_login_unknown_token", "POST", "/api/v1/users/login/mfa", `{"mfa_token":"unknown","code":"123456"}`, "", 401},
+		{"mfa_confirm_before_start", "POST", "/api/v1/users/mfa/totp/confirm", `{"code":"123456"}`, "reader", 409},
+		{"mfa_disable_not_enabled", "POST", "/api/v1/users/mfa/disable", `{"code":"123456"}`, "reader", 409},
+		{"create_api_key", "POST", "/api/v1/users/me/api-keys", `{"name":" warehouse sync ","scopes":["books:write","orders:read"]}`, "reader", 201},
+		{"create_api_key_unknown_scope", "POST", "/api/v1/users/me/api-keys", `{"name":"sync","scopes":["books:delete"]}`, "reader", 400},
+		{"create_api_key_expired", "POST", "/api/v1/users/me/api-keys", `{"name":"sync","expires_at":"2000-01-01T00:00:00Z"}`, "reader", 400},
+		{"list_api_keys", "GET", "/api/v1/users/me/api-keys", "", "reader", 200},
+		{"revoke_api_key_missing", "DELETE", "/api/v1/users/me/api-keys/99", "", "reader", 404},
+		{"profile_unknown_api_key", "GET", "/api/v1/users/profile", "", "forged_key", 401},
+
+		// Books
+		{"list_books", "GET", "/api/v1/books", "", "", 200},
+		{"list_books_unknown_sort", "GET", "/api/v1/books?sort=isbn", "", "", 400},
+		{"get_book_missing", "GET", "/api/v1/books/99", "", "", 404},
+		{"create_book_as_admin", "POST", "/api/v1/books", `{"title":"Concrete Mathematics","author":"Donald Knuth","price":59.5}`, "admin", 201},
+		{"create_book_as_user", "POST", "/api/v1/books", `{"title":"Concrete Mathematics","author":"Donald Knuth","price":59.5}`, "reader", 403},
+		{"create_book_without_token", "POST", "/api/v1/books", `{"title":"Concrete Mathematics","author":"Donald Knuth","price":59.5}`, "", 401},
+		{"create_book_missing_price", "POST", "/api/v1/books", `{"title":"Concrete Mathematics","author":"Donald Knuth"}`, "admin", 400},
+		{"create_book_negative_price", "POST", "/api/v1/books", `{"title":"Concrete Mathematics","author":"Donald Knuth","price":-5}`, "admin", 400},
+		{"update_book", "PUT", "/api/v1/books/1", `{"title":"The Go Programming Language","author":"Alan A. A. Donovan, Brian W. Kernighan","price":34.99}`, "admin", 200},
+		{"update_book_missing_title", "PUT", "/api/v1/books/1", `{"author":"Alan A. A. Donovan","price":34.99}`, "admin", 400},
+		{"patch_book", "PATCH", "/api/v1/books/1", `{"price":34.99}`, "admin", 200},
+		{"patch_book_empty_title", "PATCH", "/api/v1/books/1", `{"title":""}`, "admin", 400},
+		{"patch_book_empty_author", "PATCH", "/api/v1/books/1", `{"author":""}`, "admin", 400},
+		{"patch_book_zero_price", "PATCH", "/api/v1/books/1", `{"price":0}`, "admin", 400},
+		{"patch_book_negative_price", "PATCH", "/api/v1/books/1", `{"price":-5}`, "admin", 400},
+		{"patch_book_null_title", "PATCH", "/api/v1/books/1", `{"title":null}`, "admin", 400},
+		{"delete_book", "DELETE", "/api/v1/books/1", "", "admin", 204},
+		{"delete_book_missing", "DELETE", "/api/v1/books/99", "", "admin", 404},
+		{"delete_book_as_user", "DELETE", "/api/v1/books/1", "", "reader", 403},
+
+		// Orders
+		{"place_order", "POST", "/api/v1/orders", `{"items":[{"book_id":1,"quantity":2}]}`, "reader", 201},
+		{"place_order_missing_book", "POST", "/api/v1/orders", `{"items":[{"book_id":1,"quantity":1},{"book_id":42,"quantity":1}]}`, "reader", 404},
+		{"place_order_insufficient_stock", "POST", "/api/v1/orders", `{"items":[{"book_id":1,"quantity":6}]}`, "reader", 409},
+		{"place_order_no_items", "POST", "/api/v1/orders", `{"items":[]}`, "reader", 400},
+		{"place_order_zero_quantity", "POST", "/api/v1/orders", `{"items":[{"book_id":1,"quantity":0}]}`, "reader", 400},
+		{"place_order_duplicate_books", "POST", "/api/v1/orders", `{"items":[{"book_id":1,"quantity":1},{"book_id":1,"quantity":1}]}`, "reader", 400},
+		{"place_order_quantity_not_a_number", "POST", "/api/v1/orders", `{"items":[{"book_id":1,"quantity":"two"}]}`, "reader", 400},
+		{"place_order_without_token", "POST", "/api/v1/orders", `{"items":[{"book_id":1,"quantity":1}]}`, "", 401},
+		{"get_order_missing", "GET", "/api/v1/orders/99", "", "reader", 404},
+		{"ship_order_as_user", "POST", "/api/v1/orders/1/ship", "", "reader", 403},
+		{"refund_order_as_user", "POST", "/api/v1/orders/1/refund", "", "reader", 403},
+
+		// Admin
+		{"admin_list_users", "GET", "/api/v1/admin/users?sort=username", "", "admin", 200},
+		{"admin_list_users_as_user", "GET", "/api/v1/admin/users", "", "reader", 403},
+		{"admin_create_admin", "POST", "/api/v1/admin/users", `{"username":"staff","password":"secret123","role":"admin"}`, "admin", 201},
+		{"admin_create_user_as_user", "POST", "/api/v1/admin/users", `{"username":"staff","password":"secret123","role":"admin"}`, "reader", 403},
+		{"admin_create_user_unknown_role", "POST", "/api/v1/admin/users", `{"username":"staff","password":"secret123","role":"owner"}`, "admin", 400},
+		{"admin_create_user_duplicate_username", "POST", "/api/v1/admin/users", `{"username":"reader","password":"secret123","role":"user"}`, "admin", 409},
+		{"admin_role_history", "GET", "/api/v1/admin/users/2/role-history", "", "admin", 200},
+		{"admin_role_history_missing_user", "GET", "/api/v1/admin/users/99/role-history", "", "admin", 404},
+		{"admin_list_users_search", "GET", "/api/v1/admin/users?username=READ", "", "admin", 200},
+		{"admin_change_role", "PUT", "/api/v1/admin/users/2/role", `{"role":"admin","note":"store manager"}`, "admin", 200},
+		{"admin_change_role_unknown_role", "PUT", "/api/v1/admin/users/2/role", `{"role":"owner"}`, "admin", 400},
+		{"admin_demote_last_admin", "PUT", "/api/v1/admin/users/1/role", `{"role":"user"}`, "admin", 409},
+		{"admin_change_role_as_user", "PUT", "/api/v1/admin/users/2/role", `{"role":"admin"}`, "reader", 403},
+		{"admin_disable_user", "POST", "/api/v1/admin/users/2/disable", "", "admin", 200},
+		{"admin_disable_last_admin", "POST", "/api/v1/admin/users/1/disable", "", "admin", 409},
+		{"admin_enable_missing_user", "POST", "/api/v1/admin/users/99/enable", "", "admin", 404},
+		{"admin_delete_user", "DELETE", "/api/v1/admin/users/2", "", "admin", 204},
+		{"admin_delete_last_admin", "DELETE", "/api/v1/admin/users/1", "", "admin", 409},
+		{"admin_reset_password_as_user", "POST", "/api/v1/admin/users/2/reset-password", "", "reader", 403},
+		{"admin_reset_mfa_not_enabled", "POST", "/api/v1/admin/users/2/mfa/reset", "", "admin", 409},
+		{"admin_unlock_user", "POST", "/api/v1/admin/users/2/unlock", "", "admin", 200},
+		{"admin_unlock_missing_user", "POST", "/api/v1/admin/users/99/unlock", "", "admin", 404},
+		{"admin_restore_live_book", "POST", "/api/v1/admin/books/1/restore", "", "admin", 409},
+		{"admin_adjust_stock_below_zero", "POST", "/api/v1/admin/books/1/stock", `{"delta":-6,"reason":"damaged"}`, "admin", 409},
+		{"admin_restore_invalid_id", "POST", "/api/v1/admin/books/abc/restore", "", "admin", 400},
+		{"admin_list_roles", "GET", "/api/v1/admin/roles", "", "admin", 200},
+		{"admin_list_roles_as_user", "GET", "/api/v1/admin/roles", "", "reader", 403},
+		{"admin_get_missing_role", "GET", "/api/v1/admin/roles/owner", "", "admin", 404},
+		{"admin_create_role", "POST", "/api/v1/admin/roles", `{"name":"catalog","description":"Maintains the catalog","permissions":["books:write","inventory:write"]}`, "admin", 201},
+		{"admin_create_role_unknown_permission", "POST", "/api/v1/admin/roles", `{"name":"catalog","permissions":["books:delete"]}`, "admin", 400},
+		{"admin_create_role_invalid_name", "POST", "/api/v1/admin/roles", `{"name":"Catalog Team","permissions":[]}`, "admin", 400},
+		{"admin_create_role_existing", "POST", "/api/v1/admin/roles", `{"name":"support","permissions":[]}`, "admin", 409},
+		{"admin_update_role", "PUT", "/api/v1/admin/roles/support", `{"description":"Customer support","permissions":["orders:read:any","users:read"]}`, "admin", 200},
+		{"admin_update_admin_role", "PUT", "/api/v1/admin/roles/admin", `{"permissions":[]}`, "admin", 409},
+		{"admin_delete_builtin_role", "DELETE", "/api/v1/admin/roles/support", "", "admin", 409},
+		{"admin_list_permissions", "GET", "/api/v1/admin/permissions", "", "admin", 200},
+
+		{"jwks_shared_secret", "GET", "/.well-known/jwks.json", "", "", 200},
+		{"unknown_route", "GET", "/api/v1/authors", "", "", 404},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newServer(t)
+			w := s.do(tt.method, tt.path, tt.body, tt.as)
+			if w.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if w.Code >= 400 {
+				if ct := w.Header().Get("Content-Type"); ct != controllers.ProblemContentType {
+					t.Errorf("Content-Type %q, want %q", ct, controllers.ProblemContentType)
+				}
+				if id := w.Header().Get(middleware.RequestIDHeader); id == "" {
+					t.Errorf("missing %s header", middleware.RequestIDHeader)
+				}
+			}
+			assertGolden(t, tt.name, w)
+		})
+	}
+}
+
+// TestSession follows a session from login through refresh to logout
+func TestSession(t *testing.T) {
+	s := newServer(t)
+	first := s.tokens["reader"]
+
+	w := s.do("POST", "/api/v1/users/refresh", `{"refresh_token":"`+first.RefreshToken+`"}`, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("refresh: %d %s", w.Code, w.Body)
+	}
+	var rotated models.AuthResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &rotated); err != nil {
+		t.Fatal(err)
+	}
+	if rotated.RefreshToken == first.RefreshToken {
+		t.Fatal("refresh token was not rotated")
+	}
+	s.tokens["reader"] = rotated
+
+	steps := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{"profile with the rotated token", "GET", "/api/v1/users/profile", "", 200},
+		{"logout", "POST", "/api/v1/users/logout", "", 204},
+		{"profile after logout", "GET", "/api/v1/users/profile", "", 401},
+		{"refresh after logout", "POST", "/api/v1/users/refresh", `{"refresh_token":"` + rotated.RefreshToken + `"}`, 401},
+		{"replay of the first refresh token", "POST", "/api/v1/users/refresh", `{"refresh_token":"` + first.RefreshToken + `"}`, 401},
+	}
+	for _, step := range steps {
+		w := s.do(step.method, step.path, step.body, "reader")
+		if w.Code != step.status {
+			t.Fatalf("%s: status %d, want %d: %s", step.name, w.Code, step.status, w.Body)
+		}
+	}
+}
+
+// TestPasswordChange checks that a new password signs the user out everywhere
+// except in the session the change returns
+func TestPasswordChange(t *testing.T) {
+	s := newServer(t)
+	old := s.tokens["reader"]
+
+	w := s.do("POST", "/api/v1/users/password", `{"current_password":"reader-password","new_password":"a much longer secret"}`, "reader")
+	if w.Code != http.StatusOK {
+		t.Fatalf("change password: %d %s", w.Code, w.Body)
+	}
+	var fresh models.AuthResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &fresh); err != nil {
+		t.Fatal(err)
+	}
+
+	if w := s.do("GET", "/api/v1/users/profile", "", "reader"); w.Code != http.StatusUnauthorized {
+		t.Errorf("old access token: status %d, want 401", w.Code)
+	}
+	if w := s.do("POST", "/api/v1/users/refresh", `{"refresh_token":"`+old.RefreshToken+`"}`, ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("old refresh token: status %d, want 401", w.Code)
+	}
+	s.tokens["reader"] = fresh
+	if w := s.do("GET", "/api/v1/users/profile", "", "reader"); w.Code != http.StatusOK {
+		t.Errorf("new access token: status %d, want 200", w.Code)
+	}
+	if w := s.do("POST", "/api/v1/users/login", `{"username":"reader","password":"reader-password"}`, ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("old password: status %d, want 401", w.Code)
+	}
+	s.login("reader", "a much longer secret")
+}
+
+// TestPasswordReset follows a forgotten password from the reset mail to the new
+// password, checking that the token works once and the old sessions end
+func TestPasswordReset(t *testing.T) {
+	s := newServer(t)
+	old := s.tokens["reader"]
+	if w := s.do("PATCH", "/api/v1/users/profile", `{"email":"reader@example.com"}`, "reader"); w.Code != http.StatusOK {
+		t.Fatalf("set email: %d %s", w.Code, w.Body)
+	}
+	s.waitForToken("reader@example.com") // the verification mail
+
+	if w := s.do("POST", "/api/v1/users/password/forgot", `{"username":"reader"}`, ""); w.Code != http.StatusAccepted {
+		t.Fatalf("forgot: %d %s", w.Code, w.Body)
+	}
+	token := s.waitForToken("reader@example.com")
+
+	steps := []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{"password against the policy", `{"token":"` + token + `","new_password":"short"}`, 400},
+		{"reset", `{"token":"` + token + `","new_password":"a much longer secret"}`, 204},
+		{"token used twice", `{"token":"` + token + `","new_password":"another long secret"}`, 400},
+	}
+	for _, step := range steps {
+		w := s.do("POST", "/api/v1/users/password/reset", step.body, "")
+		if w.Code != step.status {
+			t.Fatalf("%s: status %d, want %d: %s", step.name, w.Code, step.status, w.Body)
+		}
+	}
+
+	if w := s.do("GET", "/api/v1/users/profile", "", "reader"); w.Code != http.StatusUnauthorized {
+		t.Errorf("old access token: status %d, want 401", w.Code)
+	}
+	if w := s.do("POST", "/api/v1/users/refresh", `{"refresh_token":"`+old.RefreshToken+`"}`, ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("old refresh token: status %d, want 401", w.Code)
+	}
+	if w := s.do("POST", "/api/v1/users/login", `{"username":"reader","password":"reader-password"}`, ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("old password: status %d, want 401", w.Code)
+	}
+	s.login("reader", "a much longer secret")
+}
+
+// waitForToken waits for the next mail the server sends, which goes out in the
+// background, checks its recipient and returns the token it carries
+func (s *server) waitForToken(to string) string {
+	s.t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		files, err := filepath.Glob(filepath.Join(s.mailDir, "*.eml"))
+		if err != nil {
+			s.t.Fatal(err)
+		}
+		if len(files) > s.mailsRead+1 {
+			s.t.Fatalf("%d mails sent, want %d", len(files), s.mailsRead+1)
+		}
+		if len(files) == s.mailsRead+1 {
+			s.mailsRead++
+			data, err := os.ReadFile(files[len(files)-1])
+			if err != nil {
+				s.t.Fatal(err)
+			}
+			mail := string(data)
+			if !strings.Contains(mail, "To: "+to+"\r\n") {
+				s.t.Fatalf("mail not addressed to %s:\n%s", to, mail)
+			}
+			_, token, ok := strings.Cut(mail, "use this token to ")
+			if ok {
+				_, token, ok = strings.Cut(token, ":\r\n\r\n")
+			}
+			if !ok {
+				s.t.Fatalf("mail has no token:\n%s", mail)
+			}
+			token, _, _ = strings.Cut(token, "\r\n")
+			return token
+		}
+		if time.Now().After(deadline) {
+			s.t.Fatal("no mail sent")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestEmailVerification follows a new account from registration through email
+// verification to its first order while verified addresses are required
+func TestEmailVerification(t *testing.T) {
+	cfg := config.Default()
+	cfg.Auth.RequireVerifiedEmail = true
+	s := newServerWith(t, cfg, repository.NewMemoryRepositories())
+	if w := s.do("POST", "/api/v1/users/register", `{"username":"newbie","password":"secret123","email":"Newbie@Example.com"}`, ""); w.Code != http.StatusCreated {
+		t.Fatalf("register: %d %s", w.Code, w.Body)
+	}
+	token := s.waitForToken("newbie@example.com")
+	s.tokens["newbie"] = s.login("newbie", "secret123")
+
+	steps := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{"order before verifying", "POST", "/api/v1/orders", `{"items":[{"book_id":1,"quantity":1}]}`, 403},
+		{"verify with a wrong token", "POST", "/api/v1/users/verify-email", `{"token":"unknown"}`, 400},
+		{"verify", "POST", "/api/v1/users/verify-email", `{"token":"` + token + `"}`, 200},
+		{"verify twice", "POST", "/api/v1/users/verify-email", `{"token":"` + token + `"}`, 400},
+		{"resend once verified", "POST", "/api/v1/users/verify-email/resend", "", 409},
+		{"order once verified", "POST", "/api/v1/orders", `{"items":[{"book_id":1,"quantity":1}]}`, 201},
+		{"change the address", "PATCH", "/api/v1/users/profile", `{"email":"new@example.com"}`, 200},
+		{"order with the new address unverified", "POST", "/api/v1/orders", `{"items":[{"book_id":1,"quantity":1}]}`, 403},
+	}
+	for _, step := range steps {
+		w := s.do(step.method, step.path, step.body, "newbie")
+		if w.Code != step.status {
+			t.Fatalf("%s: status %d, want %d: %s", step.name, w.Code, step.status, w.Body)
+		}
+	}
+
+	// The address change mailed a new link; a resend replaces it
+	first := s.waitForToken("new@example.com")
+	if w := s.do("POST", "/api/v1/users/verify-email/resend", "", "newbie"); w.Code != http.StatusAccepted {
+		t.Fatalf("resend: %d %s", w.Code, w.Body)
+	}
+	second := s.waitForToken("new@example.com")
+	if w := s.do("POST", "/api/v1/users/verify-email", `{"token":"`+first+`"}`, ""); w.Code != http.StatusBadRequest {
+		t.Errorf("superseded token: status %d, want 400", w.Code)
+	}
+	if w := s.do("POST", "/api/v1/users/verify-email", `{"token":"`+second+`"}`, ""); w.Code != http.StatusOK {
+		t.Errorf("resent token: status %d, want 200: %s", w.Code, w.Body)
+	}
+}
+
+// TestMFA turns on two-factor authentication for the reader and signs in with
+// authenticator and recovery codes, each accepted only once
+func TestMFA(t *testing.T) {
+	s := newServer(t)
+
+	w := s.do("POST", "/api/v1/users/mfa/totp", "", "reader")
+	if w.Code != http.StatusOK {
+		t.Fatalf("start enrollment: %d %s", w.Code, w.Body)
+	}
+	var enrollment models.TOTPEnrollment
+	decode(t, w, &enrollment)
+	if !strings.HasPrefix(enrollment.ProvisioningURI, "otpauth://totp/Book%20Order%20App:reader?") {
+		t.Errorf("provisioning uri %q", enrollment.ProvisioningURI)
+	}
+
+	step := totp.Step(time.Now())
+	if w := s.do("POST", "/api/v1/users/mfa/totp/confirm", `{"code":"abcdef"}`, "reader"); w.Code != http.StatusBadRequest {
+		t.Fatalf("confirm with a wrong code: status %d, want 400", w.Code)
+	}
+	w = s.do("POST", "/api/v1/users/mfa/totp/confirm", `{"code":"`+totpCode(t, enrollment.Secret, step)+`"}`, "reader")
+	if w.Code != http.StatusOK {
+		t.Fatalf("confirm: %d %s", w.Code, w.Body)
+	}
+	var enabled models.MFAEnabledResponse
+	decode(t, w, &enabled)
+	if len(enabled.RecoveryCodes) != 10 || enabled.User.MFAEnabledAt == nil {
+		t.Fatalf("confirm response: %s", w.Body)
+	}
+	if w := s.do("GET", "/api/v1/users/profile", "", "reader"); w.Code != http.StatusUnauthorized {
+		t.Fatalf("session from before mfa: status %d, want 401", w.Code)
+	}
+	s.tokens["reader"] = enabled.AuthResponse
+	if w := s.do("GET", "/api/v1/users/profile", "", "reader"); w.Code != http.StatusOK {
+		t.Fatalf("session from confirm: status %d, want 200", w.Code)
+	}
+
+	steps := []struct {
+		name   string
+		code   string
+		status int
+	}{
+		{"code already used to confirm", totpCode(t, enrollment.Secret, step), 401},
+		{"next code", totpCode(t, enrollment.Secret, step+1), 200},
+		{"next code again", totpCode(t, enrollment.Secret, step+1), 401},
+		{"recovery code typed loosely", " " + strings.ToUpper(strings.ReplaceAll(enabled.RecoveryCodes[0], "-", " ")), 200},
+		{"recovery code again", enabled.RecoveryCodes[0], 401},
+	}
+	for _, step := range steps {
+		challenge := s.mfaChallenge("reader", "reader-password")
+		body := `{"mfa_token":"` + challenge.MFAToken + `","code":"` + step.code + `"}`
+		if w := s.do("POST", "/api/v1/users/login/mfa", body, ""); w.Code != step.status {
+			t.Fatalf("%s: status %d, want %d: %s", step.name, w.Code, step.status, w.Body)
+		}
+		// Each challenge allows one attempt
+		if w := s.do("POST", "/api/v1/users/login/mfa", body, ""); w.Code != http.StatusUnauthorized {
+			t.Fatalf("%s: reused challenge: status %d, want 401", step.name, w.Code)
+		}
+	}
+
+	w = s.do("POST", "/api/v1/users/mfa/recovery-codes", `{"code":"`+enabled.RecoveryCodes[1]+`"}`, "reader")
+	if w.Code != http.StatusOK {
+		t.Fatalf("regenerate recovery codes: %d %s", w.Code, w.Body)
+	}
+	var regenerated models.RecoveryCodesResponse
+	decode(t, w, &regenerated)
+	if w := s.do("POST", "/api/v1/users/mfa/disable", `{"code":"`+enabled.RecoveryCodes[2]+`"}`, "reader"); w.Code != http.StatusBadRequest {
+		t.Fatalf("disable with a replaced recovery code: status %d, want 400", w.Code)
+	}
+	w = s.do("POST", "/api/v1/users/mfa/disable", `{"code":"`+regenerated.RecoveryCodes[0]+`"}`, "reader")
+	if w.Code != http.StatusOK {
+		t.Fatalf("disable: %d %s", w.Code, w.Body)
+	}
+	s.login("reader", "reader-password")
+}
+
+// TestAdminMFAPolicy checks that admins need an MFA session once the policy is
+// on, that other staff do not, and that an admin can reset a user's MFA
+func TestAdminMFAPolicy(t *testing.T) {
+	cfg := config.Default()
+	cfg.Auth.RequireAdminMFA = true
+	s := newServerWith(t, cfg, repository.NewMemoryRepositories())
+	if w := s.do("GET", "/api/v1/admin/users", "", "admin"); w.Code != http.StatusForbidden {
+		t.Fatalf("admin without mfa: status %d, want 403: %s", w.Code, w.Body)
+	}
+	s.tokens["admin"] = s.enableMFA("admin")
+	if w := s.do("GET", "/api/v1/admin/users", "", "admin"); w.Code != http.StatusOK {
+		t.Fatalf("admin with mfa: status %d, want 200: %s", w.Code, w.Body)
+	}
+	s.createUser("stocker", "warehouse")
+	if w := s.do("POST", "/api/v1/admin/books/1/stock", `{"delta":1,"reason":"restock"}`, "stocker"); w.Code != http.StatusOK {
+		t.Fatalf("warehouse without mfa: status %d, want 200: %s", w.Code, w.Body)
+	}
+
+	// The policy leaves regular users alone, and an admin can take a lost MFA away
+	s.tokens["reader"] = s.enableMFA("reader")
+	if w := s.do("POST", "/api/v1/admin/users/2/mfa/reset", "", "admin"); w.Code != http.StatusOK {
+		t.Fatalf("reset mfa: %d %s", w.Code, w.Body)
+	}
+	if w := s.do("GET", "/api/v1/users/profile", "", "reader"); w.Code != http.StatusUnauthorized {
+		t.Fatalf("session after mfa reset: status %d, want 401", w.Code)
+	}
+	s.login("reader", "reader-password")
+}
+
+// enableMFA enrolls the named seed user and returns the session that replaces its others
+func (s *server) enableMFA(as string) models.AuthResponse {
+	s.t.Helper()
+	w := s.do("POST", "/api/v1/users/mfa/totp", "", as)
+	if w.Code != http.StatusOK {
+		s.t.Fatalf("start enrollment for %s: %d %s", as, w.Code, w.Body)
+	}
+	var enrollment models.TOTPEnrollment
+	decode(s.t, w, &enrollment)
+	code := totpCode(s.t, enrollment.Secret, totp.Step(time.Now()))
+	w = s.do("POST", "/api/v1/users/mfa/totp/confirm", `{"code":"`+code+`"}`, as)
+	if w.Code != http.StatusOK {
+		s.t.Fatalf("confirm enrollment for %s: %d %s", as, w.Code, w.Body)
+	}
+	var enabled models.MFAEnabledResponse
+	decode(s.t, w, &enabled)
+	return enabled.AuthResponse
+}
+
+// mfaChallenge logs in with a password and expects the second step to be asked for
+func (s *server) mfaChallenge(username, password string) models.MFAChallengeResponse {
+	s.t.Helper()
+	body, _ := json.Marshal(models.LoginRequest{Username: username, Password: password})
+	w := s.do(http.MethodPost, "/api/v1/users/login", string(body), "")
+	if w.Code != http.StatusAccepted {
+		s.t.Fatalf("login %s: status %d, want 202: %s", username, w.Code, w.Body)
+	}
+	var challenge models.MFAChallengeResponse
+	decode(s.t, w, &challenge)
+	if !challenge.MFARequired || challenge.MFAToken == "" {
+		s.t.Fatalf("login %s: no challenge in %s", username, w.Body)
+	}
+	return challenge
+}
+
+func totpCode(t *testing.T, secret string, step int64) string {
+	t.Helper()
+	code, err := totp.Code(secret, step)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+func decode(t *testing.T, w *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+		t.Fatalf("decode %s: %v", w.Body, err)
+	}
+}
+
+// TestLoginLockout locks a username out after repeated failed logins, then the
+// client address after failures spread over many usernames
+func TestLoginLockout(t *testing.T) {
+	cfg := config.Default()
+	cfg.Auth.LoginMaxFailures = 3
+	cfg.Auth.LoginMaxFailuresPerIP = 5
+	s := newServerWith(t, cfg, repository.NewMemoryRepositories())
+	wrong := `{"username":"reader","password":"guess"}`
+	right := `{"username":"reader","password":"reader-password"}`
+	steps := []struct {
+		name       string
+		body       string
+		status     int
+		retryAfter string
+	}{
+		{"first failure", wrong, 401, ""},
+		{"second failure", wrong, 401, ""},
+		{"failure that locks", wrong, 429, "60"},
+		{"right password while locked", right, 429, "60"},
+		{"other case while locked", `{"username":"READER","password":"reader-password"}`, 429, "60"},
+	}
+	for _, step := range steps {
+		w := s.do("POST", "/api/v1/users/login", step.body, "")
+		if w.Code != step.status {
+			t.Fatalf("%s: status %d, want %d: %s", step.name, w.Code, step.status, w.Body)
+		}
+		if got := w.Header().Get("Retry-After"); got != step.retryAfter {
+			t.Errorf("%s: Retry-After %q, want %q", step.name, got, step.retryAfter)
+		}
+	}
+
+	if w := s.do("POST", "/api/v1/admin/users/2/unlock", "", "admin"); w.Code != http.StatusOK {
+		t.Fatalf("unlock: %d %s", w.Code, w.Body)
+	}
+	s.login("reader", "reader-password")
+
+	// The address counted every failure above; two more lock it out for every username
+	for _, name := range []string{"nobody", "someone"} {
+		s.do("POST", "/api/v1/users/login", `{"username":"`+name+`","password":"guess"}`, "")
+	}
+	if w := s.do("POST", "/api/v1/users/login", `{"username":"admin","password":"admin-password"}`, ""); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("login from a locked out address: status %d, want 429: %s", w.Code, w.Body)
+	}
+}
+
+// TestSigningKeys signs with an Ed25519 key, rotates to an RSA key and retires
+// the first one, and rejects tokens signed any way but with a current key
+func TestSigningKeys(t *testing.T) {
+	dir := t.TempDir()
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edFile := writeKey(t, dir, "ed25519.pem", edKey)
+	rsaFile := writeKey(t, dir, "rsa.pem", rsaKey)
+
+	s := newServer(t)
+	s.restart(keysConfig(edFile))
+	first := s.login("reader", "reader-password")
+	s.tokens["first"] = first
+	if w := s.do("GET", "/api/v1/users/profile", "", "first"); w.Code != http.StatusOK {
+		t.Fatalf("profile with an Ed25519 token: %d %s", w.Code, w.Body)
+	}
+	edKid := tokenHeader(t, first.Token)["kid"]
+	if jwks := s.jwks(); len(jwks.Keys) != 1 || jwks.Keys[0].Alg != "EdDSA" || jwks.Keys[0].Kid != edKid {
+		t.Fatalf("jwks %+v, want the Ed25519 key %s", jwks, edKid)
+	}
+
+	// Rotate: sign with the RSA key while tokens of the old key stay valid
+	s.restart(keysConfig(rsaFile, edFile))
+	second := s.login("reader", "reader-password")
+	s.tokens["second"] = second
+	if alg := tokenHeader(t, second.Token)["alg"]; alg != "RS256" {
+		t.Fatalf("alg %v after rotation, want RS256", alg)
+	}
+	for _, as := range []string{"first", "second"} {
+		if w := s.do("GET", "/api/v1/users/profile", "", as); w.Code != http.StatusOK {
+			t.Fatalf("profile with the %s token during rotation: %d %s", as, w.Code, w.Body)
+		}
+	}
+	if jwks := s.jwks(); len(jwks.Keys) != 2 || jwks.Keys[0].Alg != "RS256" || jwks.Keys[1].Kid != edKid {
+		t.Fatalf("jwks %+v, want the RSA key then the Ed25519 key", jwks)
+	}
+
+	// Retire the old key
+	s.restart(keysConfig(rsaFile))
+	if w := s.do("GET", "/api/v1/users/profile", "", "first"); w.Code != http.StatusUnauthorized {
+		t.Fatalf("profile with a token of a retired key: status %d, want 401", w.Code)
+	}
+
+	rsaKid := tokenHeader(t, second.Token)["kid"].(string)
+	claims := func(issuer, audience string) middleware.Claims {
+		return middleware.Claims{UserID: 2, Username: "reader", Role: string(models.RoleUser), SessionID: "forged",
+			RegisteredClaims: jwt.RegisteredClaims{
+				Issuer:    issuer,
+				Audience:  jwt.ClaimStrings{audience},
+				ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+			}}
+	}
+	auth := config.Default().Auth
+	iss, aud := auth.JWTIssuer, auth.JWTAudience
+	publicPEM, err := os.ReadFile(writeKey(t, dir, "rsa.pub.pem", &rsaKey.PublicKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged := []struct {
+		name   string
+		method jwt.SigningMethod
+		claims middleware.Claims
+		key    interface{}
+	}{
+		{"alg none", jwt.SigningMethodNone, claims(iss, aud), jwt.UnsafeAllowNoneSignatureType},
+		{"shared secret", jwt.SigningMethodHS256, claims(iss, aud), []byte(auth.JWTSecret)},
+		{"public key as HMAC secret", jwt.SigningMethodHS256, claims(iss, aud), publicPEM},
+		{"other issuer", jwt.SigningMethodRS256, claims("someone-else", aud), rsaKey},
+		{"other audience", jwt.SigningMethodRS256, claims(iss, "another-api"), rsaKey},
+	}
+	for _, f := range forged {
+		token := jwt.NewWithClaims(f.method, f.claims)
+		token.Header["kid"] = rsaKid
+		signed, err := token.SignedString(f.key)
+		if err != nil {
+			t.Fatalf("%s: %v", f.name, err)
+		}
+		s.tokens[f.name] = models.AuthResponse{Token: signed}
+		if w := s.do("GET", "/api/v1/users/profile", "", f.name); w.Code != http.StatusUnauthorized {
+			t.Errorf("%s: status %d, want 401", f.name, w.Code)
+		}
+	}
+}
+
+// keysConfig signs tokens with the key in the first file and verifies them with all
+func keysConfig(files ...string) *config.Config {
+	cfg := config.Default()
+	cfg.Auth.JWTPrivateKeyFile, cfg.Auth.JWTVerificationKeyFiles = files[0], files[1:]
+	return cfg
+}
+
+func writeKey(t *testing.T, dir, name string, key interface{}) string {
+	t.Helper()
+	var block pem.Block
+	var err error
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		block.Type = "PUBLIC KEY"
+		block.Bytes, err = x509.MarshalPKIXPublicKey(key)
+	default:
+		block.Type = "PRIVATE KEY"
+		block.Bytes, err = x509.MarshalPKCS8PrivateKey(key)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, pem.EncodeToMemory(&block), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func (s *server) jwks() middleware.JWKS {
+	s.t.Helper()
+	w := s.do("GET", "/.well-known/jwks.json", "", "")
+	var jwks middleware.JWKS
+	decode(s.t, w, &jwks)
+	return jwks
+}
+
+func tokenHeader(t *testing.T, token string) map[string]interface{} {
+	t.Helper()
+	parsed, _, err := jwt.NewParser().ParseUnverified(token, &middleware.Claims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed.Header
+}
+
+// TestAPIKeys uses API keys in place of sessions: within their scopes, never for
+// the account's credentials, and only while the key and its owner are active
+func TestAPIKeys(t *testing.T) {
+	s := newServer(t)
+	s.apiKeys["reader_key"] = s.createAPIKey("reader", `{"name":"orders"}`).Key
+	admin := s.createAPIKey("admin", `{"name":"warehouse","scopes":["books:write","orders:read"]}`)
+	s.apiKeys["admin_key"] = admin.Key
+
+	steps := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		as     string
+		status int
+	}{
+		{"profile with a key", "GET", "/api/v1/users/profile", "", "reader_key", 200},
+		{"order with an unscoped key", "POST", "/api/v1/orders", `{"items":[{"book_id":1,"quantity":1}]}`, "reader_key", 201},
+		{"key listing needs a session", "GET", "/api/v1/users/me/api-keys", "", "reader_key", 403},
+		{"password change needs a session", "POST", "/api/v1/users/password", `{"current_password":"reader-password","new_password":"a much longer secret"}`, "reader_key", 403},
+		{"key keeps to its owner's role", "GET", "/api/v1/admin/orders", "", "reader_key", 403},
+		{"stock with books:write", "POST", "/api/v1/admin/books/1/stock", `{"delta":3,"reason":"restock"}`, "admin_key", 200},
+		{"orders with orders:read", "GET", "/api/v1/admin/orders", "", "admin_key", 200},
+		{"shipping without orders:write", "POST", "/api/v1/orders/1/ship", "", "admin_key", 403},
+		{"users without a users scope", "GET", "/api/v1/admin/users", "", "admin_key", 403},
+		{"revoke", "DELETE", fmt.Sprintf("/api/v1/users/me/api-keys/%d", admin.ID), "", "admin", 204},
+		{"revoked key", "GET", "/api/v1/admin/orders", "", "admin_key", 401},
+	}
+	for _, step := range steps {
+		w := s.do(step.method, step.path, step.body, step.as)
+		if w.Code != step.status {
+			t.Fatalf("%s: status %d, want %d: %s", step.name, w.Code, step.status, w.Body)
+		}
+	}
+
+	var keys []models.APIKey
+	decode(t, s.do("GET", "/api/v1/users/me/api-keys", "", "reader"), &keys)
+	if len(keys) != 1 || keys[0].LastUsedAt == nil {
+		t.Fatalf("keys %+v, want the used key", keys)
+	}
+
+	if w := s.do("POST", "/api/v1/admin/users/2/disable", "", "admin"); w.Code != http.StatusOK {
+		t.Fatalf("disable: %d %s", w.Code, w.Body)
+	}
+	if w := s.do("GET", "/api/v1/users/profile", "", "reader_key"); w.Code != http.StatusUnauthorized {
+		t.Fatalf("key of a disabled user: status %d, want 401", w.Code)
+	}
+}
+
+// TestAPIKeysRevokedWithCredentials checks that resetting a password or MFA, or
+// changing the password, leaves no API key of the account working
+func TestAPIKeysRevokedWithCredentials(t *testing.T) {
+	s := newServer(t)
+	s.apiKeys["reader_key"] = s.createAPIKey("reader", `{"name":"sync"}`).Key
+	s.apiKeys["admin_key"] = s.createAPIKey("admin", `{"name":"sync"}`).Key
+	w := s.do("POST", "/api/v1/admin/users/2/reset-password", "", "admin")
+	if w.Code != http.StatusOK {
+		t.Fatalf("reset password: %d %s", w.Code, w.Body)
+	}
+	if w := s.do("GET", "/api/v1/users/profile", "", "reader_key"); w.Code != http.StatusUnauthorized {
+		t.Fatalf("key after a password reset: status %d, want 401", w.Code)
+	}
+
+	var reset models.PasswordResetResponse
+	decode(t, w, &reset)
+	s.tokens["reader"] = s.login("reader", reset.TemporaryPassword)
+	body := `{"current_password":"` + reset.TemporaryPassword + `","new_password":"reader-password"}`
+	if w := s.do("POST", "/api/v1/users/password", body, "reader"); w.Code != http.StatusOK {
+		t.Fatalf("choose a new password: %d %s", w.Code, w.Body)
+	}
+	s.tokens["reader"] = s.login("reader", "reader-password")
+	s.tokens["reader"] = s.enableMFA("reader")
+	s.apiKeys["reader_key"] = s.createAPIKey("reader", `{"name":"sync"}`).Key
+	if w := s.do("POST", "/api/v1/admin/users/2/mfa/reset", "", "admin"); w.Code != http.StatusOK {
+		t.Fatalf("reset mfa: %d %s", w.Code, w.Body)
+	}
+	if w := s.do("GET", "/api/v1/users/profile", "", "reader_key"); w.Code != http.StatusUnauthorized {
+		t.Fatalf("key after an MFA reset: status %d, want 401", w.Code)
+	}
+
+	body = `{"current_password":"admin-password","new_password":"a much longer secret"}`
+	if w := s.do("POST", "/api/v1/users/password", body, "admin"); w.Code != http.StatusOK {
+		t.Fatalf("change password: %d %s", w.Code, w.Body)
+	}
+	if w := s.do("GET", "/api/v1/users/profile", "", "admin_key"); w.Code != http.StatusUnauthorized {
+		t.Fatalf("key after a password change: status %d, want 401", w.Code)
+	}
+}
+
+func (s *server) createAPIKey(as, body string) models.CreatedAPIKeyResponse {
+	s.t.Helper()
+	w := s.do("POST", "/api/v1/users/me/api-keys", body, as)
+	if w.Code != http.StatusCreated {
+		s.t.Fatalf("create api key: %d %s", w.Code, w.Body)
+	}
+	var created models.CreatedAPIKeyResponse
+	decode(s.t, w, &created)
+	if !strings.HasPrefix(created.Key, created.Prefix) {
+		s.t.Fatalf("key %q does not start with its prefix %q", created.Key, created.Prefix)
+	}
+	return created
+}
+
+// TestRoles gives staff the built-in and custom roles and checks what each may do
+func TestRoles(t *testing.T) {
+	s := newServer(t)
+	for _, role := range []string{
+		`{"name":"catalog","permissions":["books:write"]}`,
+		`{"name":"hr","permissions":["users:read","users:manage"]}`,
+		`{"name":"lead","permissions":["users:read","roles:manage"]}`,
+	} {
+		if w := s.do("POST", "/api/v1/admin/roles", role, "admin"); w.Code != http.StatusCreated {
+			t.Fatalf("create role: %d %s", w.Code, w.Body)
+		}
+	}
+	// Users 3 to 8; boss is a second admin, in the trash
+	for _, staff := range [][2]string{{"packer", "warehouse"}, {"helper", "support"}, {"editor", "catalog"}, {"recruiter", "hr"}, {"lead", "lead"}, {"boss", "admin"}} {
+		s.createUser(staff[0], staff[1])
+	}
+	if w := s.do("DELETE", "/api/v1/admin/users/8", "", "admin"); w.Code != http.StatusNoContent {
+		t.Fatalf("delete boss: %d %s", w.Code, w.Body)
+	}
+	if w := s.do("POST", "/api/v1/orders", `{"items":[{"book_id":1,"quantity":1}]}`, "reader"); w.Code != http.StatusCreated {
+		t.Fatalf("place order: %d %s", w.Code, w.Body)
+	}
+
+	steps := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		as     string
+		status int
+	}{
+		{"warehouse reads every order", "GET", "/api/v1/admin/orders", "", "packer", 200},
+		{"warehouse cannot set any status", "POST", "/api/v1/admin/orders/1/status", `{"status":"paid"}`, "packer", 403},
+		{"pay", "POST", "/api/v1/admin/orders/1/status", `{"status":"paid"}`, "admin", 200},
+		{"fulfill", "POST", "/api/v1/admin/orders/1/status", `{"status":"fulfilled"}`, "admin", 200},
+		{"warehouse ships", "POST", "/api/v1/orders/1/ship", "", "packer", 200},
+		{"warehouse delivers", "POST", "/api/v1/orders/1/deliver", "", "packer", 200},
+		{"warehouse adjusts stock", "POST", "/api/v1/admin/books/1/stock", `{"delta":2,"reason":"restock"}`, "packer", 200},
+		{"warehouse reads the stock ledger", "GET", "/api/v1/admin/books/1/stock-movements", "", "packer", 200},
+		{"warehouse cannot add books", "POST", "/api/v1/books", `{"title":"SICP","author":"Abelson","price":40}`, "packer", 403},
+		{"warehouse cannot refund", "POST", "/api/v1/orders/1/refund", "", "packer", 403},
+		{"warehouse cannot list users", "GET", "/api/v1/admin/users", "", "packer", 403},
+		{"support reads any order", "GET", "/api/v1/orders/1", "", "helper", 200},
+		{"support cannot cancel others' orders", "POST", "/api/v1/orders/1/cancel", "", "helper", 403},
+		{"support refunds", "POST", "/api/v1/orders/1/refund", `{"note":"arrived damaged"}`, "helper", 200},
+		{"support lists users", "GET", "/api/v1/admin/users", "", "helper", 200},
+		{"support cannot disable users", "POST", "/api/v1/admin/users/2/disable", "", "helper", 403},
+		{"support cannot manage roles", "GET", "/api/v1/admin/roles", "", "helper", 403},
+		{"custom role adds books", "POST", "/api/v1/books", `{"title":"SICP","author":"Abelson","price":40}`, "editor", 201},
+		{"revoke books:write", "PUT", "/api/v1/admin/roles/catalog", `{"permissions":[]}`, "admin", 200},
+		{"change applies to issued tokens", "POST", "/api/v1/books", `{"title":"TAOCP","author":"Knuth","price":90}`, "editor", 403},
+		{"role in use", "DELETE", "/api/v1/admin/roles/catalog", "", "admin", 409},
+		{"grant a role without more permissions", "POST", "/api/v1/admin/users", `{"username":"intern","password":"secret123","role":"user"}`, "recruiter", 201},
+		{"grant a role with more permissions", "POST", "/api/v1/admin/users", `{"username":"intern2","password":"secret123","role":"warehouse"}`, "recruiter", 403},
+		{"take a role with more permissions", "PUT", "/api/v1/admin/users/3/role", `{"role":"user"}`, "recruiter", 403},
+		{"reset an admin's password", "POST", "/api/v1/admin/users/1/reset-password", "", "recruiter", 403},
+		{"reset an admin's MFA", "POST", "/api/v1/admin/users/1/mfa/reset", "", "recruiter", 403},
+		{"disable an admin", "POST", "/api/v1/admin/users/1/disable", "", "recruiter", 403},
+		{"unlock an admin", "POST", "/api/v1/admin/users/1/unlock", "", "recruiter", 403},
+		{"delete an admin", "DELETE", "/api/v1/admin/users/1", "", "recruiter", 403},
+		{"restore an admin", "POST", "/api/v1/admin/users/8/restore", "", "recruiter", 403},
+		{"reset a customer's password", "POST", "/api/v1/admin/users/2/reset-password", "", "recruiter", 200},
+		{"define a role with more permissions", "POST", "/api/v1/admin/roles", `{"name":"hr2","permissions":["users:manage"]}`, "lead", 403},
+		{"widen a role beyond own permissions", "PUT", "/api/v1/admin/roles/hr", `{"permissions":["users:read"]}`, "lead", 403},
+		{"define a role within own permissions", "POST", "/api/v1/admin/roles", `{"name":"auditor","permissions":["users:read"]}`, "lead", 201},
+		{"take the custom role away", "PUT", "/api/v1/admin/users/5/role", `{"role":"user"}`, "admin", 200},
+		{"delete the unused role", "DELETE", "/api/v1/admin/roles/catalog", "", "admin", 204},
+		{"deleted role", "GET", "/api/v1/admin/roles/catalog", "", "admin", 404},
+	}
+	for _, step := range steps {
+		w := s.do(step.method, step.path, step.body, step.as)
+		if w.Code != step.status {
+			t.Fatalf("%s: status %d, want %d: %s", step.name, w.Code, step.status, w.Body)
+		}
+	}
+}
+
+// createUser creates a user with role through the admin API and signs them in
+func (s *server) createUser(username, role string) {
+	s.t.Helper()
+	body := fmt.Sprintf(`{"username":%q,"password":"secret123","role":%q}`, username, role)
+	if w := s.do("POST", "/api/v1/admin/users", body, "admin"); w.Code != http.StatusCreated {
+		s.t.Fatalf("create user %s: %d %s", username, w.Code, w.Body)
+	}
+	s.tokens[username] = s.login(username, "secret123")
+}
+
+// TestAccountManagement follows the reader's account through the admin actions
+// that sign it out everywhere
+func TestAccountManagement(t *testing.T) {
+	s := newServer(t)
+
+	steps := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		as     string
+		status int
+	}{
+		{"disable", "POST", "/api/v1/admin/users/2/disable", "", "admin", 200},
+		{"profile while disabled", "GET", "/api/v1/users/profile", "", "reader", 401},
+		{"login while disabled", "POST", "/api/v1/users/login", `{"username":"reader","password":"reader-password"}`, "", 403},
+		{"refresh while disabled", "POST", "/api/v1/users/refresh", `{"refresh_token":"` + s.tokens["reader"].RefreshToken + `"}`, "", 401},
+		{"enable", "POST", "/api/v1/admin/users/2/enable", "", "admin", 200},
+		{"login after enable", "POST", "/api/v1/users/login", `{"username":"reader","password":"reader-password"}`, "", 200},
+	}
+	for _, step := range steps {
+		w := s.do(step.method, step.path, step.body, step.as)
+		if w.Code != step.status {
+			t.Fatalf("%s: status %d, want %d: %s", step.name, w.Code, step.status, w.Body)
+		}
+	}
+
+	// A promotion takes effect with the next sign-in; tokens carrying the old role are revoked
+	s.tokens["reader"] = s.login("reader", "reader-password")
+	if w := s.do("PUT", "/api/v1/admin/users/2/role", `{"role":"admin"}`, "admin"); w.Code != http.StatusOK {
+		t.Fatalf("change role: %d %s", w.Code, w.Body)
+	}
+	if w := s.do("GET", "/api/v1/admin/users", "", "reader"); w.Code != http.StatusUnauthorized {
+		t.Fatalf("old token after role change: status %d, want 401", w.Code)
+	}
+	s.tokens["reader"] = s.login("reader", "reader-password")
+	if w := s.do("GET", "/api/v1/admin/users", "", "reader"); w.Code != http.StatusOK {
+		t.Fatalf("new token after promotion: status %d, want 200", w.Code)
+	}
+
+	// A forced reset hands out a temporary password that replaces the old one
+	w := s.do("POST", "/api/v1/admin/users/2/reset-password", "", "admin")
+	if w.Code != http.StatusOK {
+		t.Fatalf("reset password: %d %s", w.Code, w.Body)
+	}
+	var reset models.PasswordResetResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &reset); err != nil {
+		t.Fatal(err)
+	}
+	if reset.TemporaryPassword == "" || !reset.User.PasswordResetRequired {
+		t.Fatalf("reset response: %+v", reset)
+	}
+	if w := s.do("POST", "/api/v1/users/login", `{"username":"reader","password":"reader-password"}`, ""); w.Code != http.StatusUnauthorized {
+		t.Fatalf("old password after reset: status %d, want 401", w.Code)
+	}
+
+	// The temporary password only allows choosing a new one, even after a refresh
+	temporary := s.login("reader", reset.TemporaryPassword)
+	w = s.do("POST", "/api/v1/users/refresh", `{"refresh_token":"`+temporary.RefreshToken+`"}`, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("refresh with the temporary password: %d %s", w.Code, w.Body)
+	}
+	decode(t, w, &temporary)
+	s.tokens["reader"] = temporary
+	if w := s.do("GET", "/api/v1/orders", "", "reader"); w.Code != http.StatusForbidden {
+		t.Fatalf("orders before the change: status %d, want 403", w.Code)
+	}
+	if w := s.do("GET", "/api/v1/users/profile", "", "reader"); w.Code != http.StatusOK {
+		t.Fatalf("profile before the change: status %d, want 200", w.Code)
+	}
+	body := `{"current_password":"` + reset.TemporaryPassword + `","new_password":"a much longer secret"}`
+	w = s.do("POST", "/api/v1/users/password", body, "reader")
+	if w.Code != http.StatusOK {
+		t.Fatalf("change password: %d %s", w.Code, w.Body)
+	}
+	var changed models.AuthResponse
+	decode(t, w, &changed)
+	if changed.User.PasswordResetRequired {
+		t.Fatal("password change did not clear password_reset_required")
+	}
+	s.tokens["reader"] = changed
+	if w := s.do("GET", "/api/v1/orders", "", "reader"); w.Code != http.StatusOK {
+		t.Fatalf("orders after the change: status %d, want 200: %s", w.Code, w.Body)
+	}
+}
+
+// stuckSessions is a token store that cannot revoke a user's sessions
+type stuckSessions struct {
+	repository.TokenRepository
+}
+
+func (stuckSessions) RevokeUserFamilies(ctx context.Context, userID uint, denyUntil time.Time) error {
+	return errors.New("revoke failed")
+}
+
+// TestInactiveAccountTokens checks that disabling or deleting an account stops
+// its tokens even when signing it out everywhere fails
+func TestInactiveAccountTokens(t *testing.T) {
+	repos := repository.NewMemoryRepositories()
+	repos.Tokens = stuckSessions{repos.Tokens}
+	s := newServerWith(t, config.Default(), repos)
+	s.createUser("leaver", "user")
+
+	for _, step := range []struct {
+		name, method, path, as string
+		status                 int
+	}{
+		{"disable", "POST", "/api/v1/admin/users/2/disable", "admin", 500},
+		{"profile while disabled", "GET", "/api/v1/users/profile", "reader", 401},
+		{"orders while disabled", "GET", "/api/v1/orders", "reader", 401},
+		{"delete", "DELETE", "/api/v1/admin/users/3", "admin", 500},
+		{"profile after delete", "GET", "/api/v1/users/profile", "leaver", 401},
+	} {
+		w := s.do(step.method, step.path, "", step.as)
+		if w.Code != step.status {
+			t.Fatalf("%s: status %d, want %d: %s", step.name, w.Code, step.status, w.Body)
+		}
+	}
+}
+
+// downBooks is a book store whose database cannot be reached: lists fail at once
+// and lookups hang until the request deadline
+type downBooks struct {
+	repository.BookRepository
+}
+
+func (downBooks) List(ctx context.Context, params query.Params, includeDeleted bool) (query.Page[models.Book], error) {
+	return query.Page[models.Book]{}, fmt.Errorf("dial tcp 10.0.0.5:5432: %w", driver.ErrBadConn)
+}
+
+func (downBooks) Get(ctx context.Context, id uint) (models.Book, error) {
+	<-ctx.Done()
+	return models.Book{}, ctx.Err()
+}
+
+// TestDatabaseUnavailable checks that database outages and timeouts answer 503
+// without leaking driver details
+func TestDatabaseUnavailable(t *testing.T) {
+	repos := repository.NewMemoryRepositories()
+	repos.Books = downBooks{repos.Books}
+
+	r := gin.New()
+	r.Use(middleware.Timeout(20 * time.Millisecond))
+	keys, err := middleware.LoadKeySet(config.Default().Auth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	routers.RegisterRoutes(r, routers.NewServices(config.Default(), keys, repos, mailer.NewFileMailer("test@example.com", t.TempDir())))
+	s := &server{t: t, router: r}
+
+	for _, tt := range []struct{ name, path string }{
+		{"database_unavailable", "/api/v1/books"},
+		{"database_timeout", "/api/v1/books/1"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			w := s.do("GET", tt.path, "", "")
+			if w.Code != http.StatusServiceUnavailable {
+				t.Fatalf("status %d, want %d: %s", w.Code, http.StatusServiceUnavailable, w.Body)
+			}
+			if w.Header().Get("Retry-After") == "" {
+				t.Error("missing Retry-After header")
+			}
+			assertGolden(t, tt.name, w)
+		})
+	}
+}
+
+// assertGolden compares the status and normalized JSON body with testdata/<name>.golden.json
+func assertGolden(t *testing.T, name string, w *httptest.ResponseRecorder) {
+	t.Helper()
+	var body interface{}
+	if w.Body.Len() > 0 {
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatalf("response is not JSON: %v: %s", err, w.Body)
+		}
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(map[string]interface{}{"status": w.Code, "body": normalize(body)}); err != nil {
+		t.Fatal(err)
+	}
+	got := buf.Bytes()
+
+	path := filepath.Join("testdata", name+".golden.json")
+	if *update {
+		if err := os.MkdirAll("testdata", 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v (run go test ./routers -update to create it)", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("response differs from %s\ngot:\n%s\nwant:\n%s", path, got, want)
+	}
+}
+
+// volatileFields change on every run, so only their presence is compared
+var volatileFields = map[string]string{
+	"token":             "<token>",
+	"refresh_token":     "<token>",
+	"created_at":        "<time>",
+	"updated_at":        "<time>",
+	"deleted_at":        "<time>",
+	"expires_at":        "<time>",
+	"disabled_at":       "<time>",
+	"email_verified_at": "<time>",
+	"mfa_enabled_at":    "<time>",
+	"last_used_at":      "<time>",
+	"key":               "<token>",
+	"prefix":            "<token>",
+	"request_id":        "<request_id>",
+}
+
+func normalize(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			if placeholder, ok := volatileFields[key]; ok && value != nil {
+				v[key] = placeholder
+				continue
+			}
+			v[key] = normalize(value)
+		}
+	case []interface{}:
+		for i := range v {
+			v[i] = normalize(v[i])
+		}
+	}
+	return v
+}
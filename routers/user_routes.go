@@ -3,18 +3,82 @@ package routers
 import (
 	"book_order_app/controllers"
 	"book_order_app/middleware"
+	"book_order_app/models"
 
 	"github.com/gin-gonic/gin"
 )
 
-func RegisterUserRoutes(rg *gin.RouterGroup) {
-	userController := controllers.InitializeUserController()
+func RegisterUserRoutes(rg *gin.RouterGroup, svc Services) {
+	userController := controllers.InitializeUserController(svc.Users, svc.Tokens, svc.PasswordResets, svc.Verifications, svc.MFA, svc.APIKeys)
+	apiKeyController := controllers.InitializeAPIKeyController(svc.APIKeys)
+	roleController := controllers.InitializeRoleController(svc.Roles)
 	users := rg.Group("/users")
 	{
 		users.POST("/login", userController.LoginUser)
+		users.POST("/login/mfa", userController.LoginMFA)
 		users.POST("/register", userController.RegisterUser)
+		users.POST("/refresh", userController.RefreshToken)
+		users.POST("/password/forgot", userController.ForgotPassword)
+		users.POST("/password/reset", userController.CompletePasswordReset)
+		users.POST("/verify-email", userController.VerifyEmail)
 
 		// Protected routes
-		users.GET("/profile", middleware.AuthMiddleware(), userController.GetProfile)
+		users.GET("/profile", middleware.AllowPendingPasswordChange(), svc.Auth.Authenticate(), userController.GetProfile)
+	}
+
+	// All a user whose password an admin reset can do before choosing a new one
+	pending := rg.Group("/users", middleware.AllowPendingPasswordChange(), svc.Auth.Authenticate(), middleware.RequireSession())
+	{
+		pending.POST("/password", userController.ChangePassword)
+		pending.POST("/logout", userController.Logout)
+	}
+
+	// Account credentials and contact details, which API keys cannot change
+	account := rg.Group("/users", svc.Auth.Authenticate(), middleware.RequireSession())
+	{
+		account.PATCH("/profile", userController.UpdateProfile)
+		account.POST("/verify-email/resend", userController.ResendVerification)
+		account.POST("/mfa/totp", userController.StartMFA)
+		account.POST("/mfa/totp/confirm", userController.ConfirmMFA)
+		account.POST("/mfa/disable", userController.DisableMFA)
+		account.POST("/mfa/recovery-codes", userController.RegenerateRecoveryCodes)
+
+		account.GET("/me/api-keys", apiKeyController.ListAPIKeys)
+		account.POST("/me/api-keys", apiKeyController.CreateAPIKey)
+		account.DELETE("/me/api-keys/:keyId", apiKeyController.RevokeAPIKey)
+	}
+
+	// User management for staff
+	adminUsers := rg.Group("/admin/users", svc.Auth.Authenticate(), middleware.RequireScope("users"))
+	{
+		adminUsers.GET("", svc.Auth.RequirePermission(models.PermUsersRead), userController.ListUsers)
+		adminUsers.GET("/:userId/role-history", svc.Auth.RequirePermission(models.PermUsersRead), userController.GetRoleHistory)
+
+		manage := adminUsers.Group("", svc.Auth.RequirePermission(models.PermUsersManage))
+		manage.POST("", userController.CreateUser)
+		manage.DELETE("/:userId", userController.DeleteUser)
+		manage.PUT("/:userId/role", userController.ChangeRole)
+		manage.POST("/:userId/disable", userController.DisableUser)
+		manage.POST("/:userId/enable", userController.EnableUser)
+		manage.POST("/:userId/reset-password", userController.ResetPassword)
+		manage.POST("/:userId/mfa/reset", userController.ResetMFA)
+		manage.POST("/:userId/unlock", userController.UnlockUser)
+		manage.POST("/:userId/restore", userController.RestoreUser)
+	}
+
+	adminTokens := rg.Group("/admin/tokens", svc.Auth.Authenticate(), svc.Auth.RequirePermission(models.PermUsersManage), middleware.RequireScope("users"))
+	{
+		adminTokens.POST("/revoke", userController.RevokeToken)
+	}
+
+	// Role definitions, changed at runtime
+	adminRoles := rg.Group("/admin", svc.Auth.Authenticate(), svc.Auth.RequirePermission(models.PermRolesManage), middleware.RequireScope("users"))
+	{
+		adminRoles.GET("/roles", roleController.ListRoles)
+		adminRoles.POST("/roles", roleController.CreateRole)
+		adminRoles.GET("/roles/:role", roleController.GetRole)
+		adminRoles.PUT("/roles/:role", roleController.UpdateRole)
+		adminRoles.DELETE("/roles/:role", roleController.DeleteRole)
+		adminRoles.GET("/permissions", roleController.ListPermissions)
 	}
 }
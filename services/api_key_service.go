@@ -0,0 +1,161 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"book_order_app/middleware"
+	"book_order_app/models"
+	"book_order_app/repository"
+)
+
+// apiKeyTouchInterval is how stale last_used_at may get, so that a busy script
+// does not cost a write per request
+const apiKeyTouchInterval = time.Minute
+
+// apiKeyPrefixLength is how much of a key is kept in the clear to tell keys apart
+const apiKeyPrefixLength = len(models.APIKeyPrefix) + 8
+
+var ErrAPIKeyNotFound = repository.ErrAPIKeyNotFound
+
+type APIKeyService interface {
+	Create(ctx context.Context, userID uint, mfa bool, req models.CreateAPIKeyRequest) (models.CreatedAPIKeyResponse, error)
+	List(ctx context.Context, userID uint) ([]models.APIKey, error)
+	Revoke(ctx context.Context, userID, id uint) error
+	RevokeAll(ctx context.Context, userID uint) error
+	AuthenticateAPIKey(ctx context.Context, key string) (middleware.APIKeyIdentity, error)
+}
+
+type apiKeyService struct {
+	keys  repository.APIKeyRepository
+	users repository.UserRepository
+}
+
+func NewAPIKeyService(keys repository.APIKeyRepository, users repository.UserRepository) APIKeyService {
+	return &apiKeyService{keys: keys, users: users}
+}
+
+// Create issues a key for the user; mfa records whether the creating session
+// passed a second factor. The key is returned only this once.
+func (as *apiKeyService) Create(ctx context.Context, userID uint, mfa bool, req models.CreateAPIKeyRequest) (models.CreatedAPIKeyResponse, error) {
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		return models.CreatedAPIKeyResponse{}, Invalid(errors.New("invalid api key"),
+			FieldError{Field: "name", Message: "must not be blank"})
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		return models.CreatedAPIKeyResponse{}, Invalid(errors.New("invalid api key"),
+			FieldError{Field: "expires_at", Message: "must be in the future"})
+	}
+
+	secret, err := newRefreshToken()
+	if err != nil {
+		return models.CreatedAPIKeyResponse{}, err
+	}
+	raw := models.APIKeyPrefix + secret
+	key := models.APIKey{
+		UserID:    userID,
+		Name:      name,
+		Prefix:    raw[:apiKeyPrefixLength],
+		KeyHash:   hashToken(raw),
+		Scopes:    req.Scopes,
+		MFA:       mfa,
+		ExpiresAt: req.ExpiresAt,
+	}
+	if key.Scopes == nil {
+		key.Scopes = []string{}
+	}
+	if err := as.keys.CreateAPIKey(ctx, &key); err != nil {
+		userLogger.WithError(err).WithField("user_id", userID).Error("Error creating API key")
+		return models.CreatedAPIKeyResponse{}, fmt.Errorf("failed to create api key: %w", err)
+	}
+
+	userLogger.WithField("user_id", userID).WithField("prefix", key.Prefix).Info("Created API key")
+	return models.CreatedAPIKeyResponse{APIKey: key, Key: raw}, nil
+}
+
+// List returns the user's keys, without the keys themselves
+func (as *apiKeyService) List(ctx context.Context, userID uint) ([]models.APIKey, error) {
+	keys, err := as.keys.ListAPIKeys(ctx, userID)
+	if err != nil {
+		userLogger.WithError(err).WithField("user_id", userID).Error("Error listing API keys")
+		return nil, err
+	}
+	return keys, nil
+}
+
+// Revoke deletes one of the user's keys; it stops working immediately
+func (as *apiKeyService) Revoke(ctx context.Context, userID, id uint) error {
+	if err := as.keys.DeleteAPIKey(ctx, userID, id); err != nil {
+		if !errors.Is(err, ErrAPIKeyNotFound) {
+			userLogger.WithError(err).WithField("user_id", userID).Error("Error revoking API key")
+		}
+		return err
+	}
+	userLogger.WithField("user_id", userID).WithField("api_key_id", id).Info("Revoked API key")
+	return nil
+}
+
+// RevokeAll deletes every key of a user, after the account's credentials were
+// reset, so a key taken along with a compromised password stops working too
+func (as *apiKeyService) RevokeAll(ctx context.Context, userID uint) error {
+	deleted, err := as.keys.DeleteUserAPIKeys(ctx, userID)
+	if err != nil {
+		userLogger.WithError(err).WithField("user_id", userID).Error("Error revoking API keys")
+		return fmt.Errorf("failed to revoke api keys: %w", err)
+	}
+	if deleted > 0 {
+		userLogger.WithField("user_id", userID).WithField("count", deleted).Info("Revoked API keys")
+	}
+	return nil
+}
+
+// AuthenticateAPIKey resolves a key to its owner with the owner's current role,
+// so role changes, disabling and deleting the account take effect on its keys at once
+func (as *apiKeyService) AuthenticateAPIKey(ctx context.Context, raw string) (middleware.APIKeyIdentity, error) {
+	if !strings.HasPrefix(raw, models.APIKeyPrefix) {
+		return middleware.APIKeyIdentity{}, middleware.ErrInvalidAPIKey
+	}
+	key, err := as.keys.GetAPIKey(ctx, hashToken(raw))
+	if err != nil {
+		if errors.Is(err, ErrAPIKeyNotFound) {
+			return middleware.APIKeyIdentity{}, middleware.ErrInvalidAPIKey
+		}
+		logger.WithError(err).Error("Error checking API key")
+		return middleware.APIKeyIdentity{}, err
+	}
+	now := time.Now()
+	if key.IsExpired(now) {
+		return middleware.APIKeyIdentity{}, middleware.ErrInvalidAPIKey
+	}
+
+	user, err := as.users.GetByID(ctx, key.UserID)
+	if err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			return middleware.APIKeyIdentity{}, middleware.ErrInvalidAPIKey
+		}
+		logger.WithError(err).WithField("user_id", key.UserID).Error("Error checking API key")
+		return middleware.APIKeyIdentity{}, err
+	}
+	if user.IsDisabled() {
+		return middleware.APIKeyIdentity{}, middleware.ErrInvalidAPIKey
+	}
+
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) >= apiKeyTouchInterval {
+		// The request goes ahead either way; a missed update only makes last_used_at stale
+		if err := as.keys.TouchAPIKey(ctx, key.ID, now); err != nil {
+			logger.WithError(err).WithField("api_key_id", key.ID).Warn("Error recording API key use")
+		}
+	}
+	return middleware.APIKeyIdentity{
+		KeyID:    key.ID,
+		UserID:   user.ID,
+		Username: user.Username,
+		Role:     string(user.Role),
+		Scopes:   key.Scopes,
+		MFA:      key.MFA,
+	}, nil
+}
@@ -1,54 +1,98 @@
 package services
 
 import (
-	"book_order_app/config"
+	"context"
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+
 	"book_order_app/middleware"
 	"book_order_app/models"
+	"book_order_app/query"
+	"book_order_app/repository"
 )
 
 var logger = middleware.GetLogger()
 
+var (
+	ErrBookNotFound     = repository.ErrBookNotFound
+	ErrBookHasOrders    = repository.ErrBookHasOrders
+	ErrEmptySearchQuery = NewError(KindValidation, "search query must contain at least one letter or digit")
+)
+
+var searchTermPattern = regexp.MustCompile(`[\p{L}\p{N}]+`)
+
+// BookQuerySpec lists what GET /books may sort and filter on
+var BookQuerySpec = query.Spec{
+	Sortable: map[string]query.Field{
+		"id":         {Column: "id", Kind: query.Number},
+		"title":      {Column: "title", Kind: query.String},
+		"author":     {Column: "author", Kind: query.String},
+		"price":      {Column: "price", Kind: query.Number},
+		"created_at": {Column: "created_at", Kind: query.Time},
+		"updated_at": {Column: "updated_at", Kind: query.Time},
+	},
+	Filters: map[string]query.Filter{
+		"title":          {Column: "title", Kind: query.String, Op: query.Contains},
+		"author":         {Column: "author", Kind: query.String, Op: query.Contains},
+		"min_price":      {Column: "price", Kind: query.Number, Op: query.Gte},
+		"max_price":      {Column: "price", Kind: query.Number, Op: query.Lte},
+		"created_after":  {Column: "created_at", Kind: query.Time, Op: query.Gt},
+		"created_before": {Column: "created_at", Kind: query.Time, Op: query.Lt},
+	},
+	DefaultSort: "-created_at",
+}
+
 type BookService interface {
-	GetAll() []models.Book
-	Create(book models.Book) models.Book
-	GetBookById(bookId string) (models.Book, error)
-	Exists(id uint) bool
+	GetAll(ctx context.Context, params query.Params, includeDeleted bool) (query.Page[models.Book], error)
+	Create(ctx context.Context, book models.Book) (models.Book, error)
+	GetBookById(ctx context.Context, bookId string) (models.Book, error)
+	Exists(ctx context.Context, id uint) (bool, error)
+	Update(ctx context.Context, id uint, book models.Book) (models.Book, error)
+	Patch(ctx context.Context, id uint, patch models.PatchBookRequest) (models.Book, error)
+	Delete(ctx context.Context, id uint) error
+	Restore(ctx context.Context, id uint) (models.Book, error)
+	Search(ctx context.Context, q string, params query.Params) (query.Page[models.BookSearchResult], error)
 }
 
 type bookService struct {
-	dbHandler *config.DBHandler
+	books repository.BookRepository
 }
 
-func NewBookService() BookService {
-	dbHandler := config.InitializeDBHandler()
-	return &bookService{dbHandler: dbHandler}
+func NewBookService(books repository.BookRepository) BookService {
+	return &bookService{books: books}
 }
 
-func (bs *bookService) GetAll() []models.Book {
-	var books []models.Book
-	if err := bs.dbHandler.DB.Find(&books).Error; err != nil {
+func (bs *bookService) GetAll(ctx context.Context, params query.Params, includeDeleted bool) (query.Page[models.Book], error) {
+	page, err := bs.books.List(ctx, params, includeDeleted)
+	if err != nil {
 		logger.WithError(err).Error("Error fetching books")
-		return []models.Book{}
+		return query.Page[models.Book]{}, err
 	}
-	logger.WithField("count", len(books)).Info("Successfully fetched books")
-	return books
+	logger.WithField("count", len(page.Data)).Info("Successfully fetched books")
+	return page, nil
 }
 
-func (bs *bookService) Create(book models.Book) models.Book {
-	if err := bs.dbHandler.DB.Create(&book).Error; err != nil {
+func (bs *bookService) Create(ctx context.Context, book models.Book) (models.Book, error) {
+	if err := bs.books.Create(ctx, &book); err != nil {
 		logger.WithError(err).WithField("book", book.Title).Error("Error creating book")
-		return book
+		return models.Book{}, err
 	}
 	logger.WithFields(map[string]interface{}{
 		"book_id": book.ID,
 		"title":   book.Title,
 	}).Info("Successfully created book")
-	return book
+	return book, nil
 }
 
-func (bs *bookService) GetBookById(bookId string) (models.Book, error) {
-	var book models.Book
-	if err := bs.dbHandler.DB.First(&book, bookId).Error; err != nil {
+func (bs *bookService) GetBookById(ctx context.Context, bookId string) (models.Book, error) {
+	id, err := strconv.ParseUint(bookId, 10, 0)
+	if err != nil {
+		return models.Book{}, ErrBookNotFound
+	}
+	book, err := bs.books.Get(ctx, uint(id))
+	if err != nil {
 		logger.WithError(err).Error("Error fetching book") //after this logs the log in the logger.go will be printed for error
 		return models.Book{}, err
 	}
@@ -56,8 +100,116 @@ func (bs *bookService) GetBookById(bookId string) (models.Book, error) {
 	return book, nil
 }
 
-func (bs *bookService) Exists(id uint) bool {
-	var count int64
-	bs.dbHandler.DB.Model(&models.Book{}).Where("id = ?", id).Count(&count)
-	return count > 0
+// Exists reports whether a live book has the given id. Only a missing book is
+// reported as false; any other failure is returned.
+func (bs *bookService) Exists(ctx context.Context, id uint) (bool, error) {
+	_, err := bs.books.Get(ctx, id)
+	if errors.Is(err, ErrBookNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Update replaces every editable field of an existing book
+func (bs *bookService) Update(ctx context.Context, id uint, book models.Book) (models.Book, error) {
+	existing, err := bs.findByID(ctx, id)
+	if err != nil {
+		return models.Book{}, err
+	}
+
+	existing.Title = book.Title
+	existing.Author = book.Author
+	existing.Price = book.Price
+
+	// Stock is owned by the inventory ledger and is never overwritten here
+	if err := bs.books.UpdateDetails(ctx, &existing); err != nil {
+		logger.WithError(err).WithField("book_id", id).Error("Error updating book")
+		return models.Book{}, err
+	}
+	logger.WithField("book_id", id).Info("Successfully updated book")
+	return existing, nil
+}
+
+// Patch applies a JSON merge patch to an existing book, leaving omitted fields untouched
+func (bs *bookService) Patch(ctx context.Context, id uint, patch models.PatchBookRequest) (models.Book, error) {
+	existing, err := bs.findByID(ctx, id)
+	if err != nil {
+		return models.Book{}, err
+	}
+
+	if patch.Title != nil {
+		existing.Title = *patch.Title
+	}
+	if patch.Author != nil {
+		existing.Author = *patch.Author
+	}
+	if patch.Price != nil {
+		existing.Price = *patch.Price
+	}
+
+	// Stock is owned by the inventory ledger and is never overwritten here
+	if err := bs.books.UpdateDetails(ctx, &existing); err != nil {
+		logger.WithError(err).WithField("book_id", id).Error("Error patching book")
+		return models.Book{}, err
+	}
+	logger.WithField("book_id", id).Info("Successfully patched book")
+	return existing, nil
+}
+
+// Delete soft-deletes a book unless open orders still reference it through fk_order_items_book
+func (bs *bookService) Delete(ctx context.Context, id uint) error {
+	if err := bs.books.Delete(ctx, id); err != nil {
+		if !errors.Is(err, ErrBookNotFound) && !errors.Is(err, ErrBookHasOrders) {
+			logger.WithError(err).WithField("book_id", id).Error("Error deleting book")
+		}
+		return err
+	}
+
+	logger.WithField("book_id", id).Info("Successfully deleted book")
+	return nil
+}
+
+// Restore brings a soft-deleted book back into the catalog
+func (bs *bookService) Restore(ctx context.Context, id uint) (models.Book, error) {
+	book, err := bs.books.Restore(ctx, id)
+	if err != nil {
+		if !errors.Is(err, ErrBookNotFound) && !errors.Is(err, ErrNotDeleted) {
+			logger.WithError(err).WithField("book_id", id).Error("Error restoring book")
+		}
+		return models.Book{}, err
+	}
+	logger.WithField("book_id", id).Info("Successfully restored book")
+	return book, nil
+}
+
+// Search ranks books by full-text match on title and author, with prefix matching on
+// every term and trigram similarity as a fallback for typos
+func (bs *bookService) Search(ctx context.Context, q string, params query.Params) (query.Page[models.BookSearchResult], error) {
+	terms := searchTermPattern.FindAllString(strings.ToLower(q), -1)
+	if len(terms) == 0 {
+		return query.Page[models.BookSearchResult]{}, ErrEmptySearchQuery
+	}
+
+	page, err := bs.books.Search(ctx, terms, params)
+	if err != nil {
+		logger.WithError(err).WithField("q", q).Error("Error searching books")
+		return query.Page[models.BookSearchResult]{}, err
+	}
+
+	logger.WithFields(map[string]interface{}{
+		"q":     q,
+		"count": len(page.Data),
+	}).Info("Successfully searched books")
+	return page, nil
+}
+
+func (bs *bookService) findByID(ctx context.Context, id uint) (models.Book, error) {
+	book, err := bs.books.Get(ctx, id)
+	if err != nil {
+		if !errors.Is(err, ErrBookNotFound) {
+			logger.WithError(err).WithField("book_id", id).Error("Error fetching book")
+		}
+		return models.Book{}, err
+	}
+	return book, nil
 }
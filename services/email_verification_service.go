@@ -0,0 +1,131 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"book_order_app/config"
+	"book_order_app/mailer"
+	"book_order_app/models"
+	"book_order_app/repository"
+)
+
+var (
+	ErrInvalidVerificationToken = Invalid(errors.New("invalid or expired email verification token"),
+		FieldError{Field: "token", Message: "is invalid or expired"})
+	ErrNoEmail              = NewError(KindValidation, "the account has no email address; add one to the profile first")
+	ErrEmailAlreadyVerified = NewError(KindConflict, "the email address is already verified")
+	ErrEmailNotVerified     = NewError(KindForbidden, "verify your email address before placing orders")
+)
+
+type EmailVerificationService interface {
+	Send(ctx context.Context, user *models.User) error
+	Resend(ctx context.Context, userID uint) error
+	Verify(ctx context.Context, token string) (*models.User, error)
+}
+
+type emailVerificationService struct {
+	cfg    *config.Config
+	tokens repository.TokenRepository
+	users  repository.UserRepository
+	mail   mailer.Mailer
+}
+
+func NewEmailVerificationService(cfg *config.Config, tokens repository.TokenRepository, users repository.UserRepository, mail mailer.Mailer) EmailVerificationService {
+	return &emailVerificationService{cfg: cfg, tokens: tokens, users: users, mail: mail}
+}
+
+// Send mails a verification link for the user's current email address, superseding
+// any link sent before. Users without an address or already verified get nothing.
+func (vs *emailVerificationService) Send(ctx context.Context, user *models.User) error {
+	if user.Email == nil || user.IsEmailVerified() {
+		return nil
+	}
+
+	raw, err := issueOneTimeToken(ctx, vs.tokens, user.ID, models.PurposeEmailVerification, time.Duration(vs.cfg.Auth.EmailVerificationTTL))
+	if err != nil {
+		userLogger.WithError(err).WithField("user_id", user.ID).Error("Error creating email verification token")
+		return fmt.Errorf("failed to send email verification: %w", err)
+	}
+	sendInBackground(ctx, vs.mail, vs.verificationMessage(*user.Email, user.Username, raw), user.ID, "email verification")
+	return nil
+}
+
+// Resend mails a new verification link to a user who lost or let expire the first one
+func (vs *emailVerificationService) Resend(ctx context.Context, userID uint) error {
+	user, err := vs.users.GetByID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			return err
+		}
+		userLogger.WithError(err).WithField("user_id", userID).Error("Error finding user for email verification")
+		return fmt.Errorf("failed to send email verification: %w", err)
+	}
+	if user.Email == nil {
+		return ErrNoEmail
+	}
+	if user.IsEmailVerified() {
+		return ErrEmailAlreadyVerified
+	}
+	return vs.Send(ctx, &user)
+}
+
+// Verify marks the address a token was sent to as verified. A token stops working
+// once used, once a newer one is sent and once the user changes the address.
+func (vs *emailVerificationService) Verify(ctx context.Context, raw string) (*models.User, error) {
+	token, err := vs.tokens.GetOneTimeToken(ctx, models.PurposeEmailVerification, hashToken(raw))
+	if err != nil {
+		if errors.Is(err, repository.ErrOneTimeTokenNotFound) {
+			return nil, ErrInvalidVerificationToken
+		}
+		userLogger.WithError(err).Error("Error finding email verification token")
+		return nil, fmt.Errorf("failed to verify email: %w", err)
+	}
+	if token.UsedAt != nil || time.Now().After(token.ExpiresAt) {
+		return nil, ErrInvalidVerificationToken
+	}
+
+	user, err := vs.users.GetByID(ctx, token.UserID)
+	if err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			return nil, ErrInvalidVerificationToken
+		}
+		userLogger.WithError(err).WithField("user_id", token.UserID).Error("Error finding user for email verification")
+		return nil, fmt.Errorf("failed to verify email: %w", err)
+	}
+	if user.Email == nil {
+		return nil, ErrInvalidVerificationToken
+	}
+
+	if err := vs.tokens.UseOneTimeToken(ctx, token.ID); err != nil {
+		if errors.Is(err, repository.ErrOneTimeTokenUsed) {
+			return nil, ErrInvalidVerificationToken
+		}
+		userLogger.WithError(err).WithField("user_id", user.ID).Error("Error using email verification token")
+		return nil, fmt.Errorf("failed to verify email: %w", err)
+	}
+	// The address may have changed since the token was read; then it verifies nothing
+	verified, err := vs.users.VerifyEmail(ctx, user.ID, *user.Email)
+	if err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			return nil, ErrInvalidVerificationToken
+		}
+		userLogger.WithError(err).WithField("user_id", user.ID).Error("Error verifying email")
+		return nil, fmt.Errorf("failed to verify email: %w", err)
+	}
+
+	userLogger.WithField("user_id", user.ID).Info("Successfully verified email address")
+	return &verified, nil
+}
+
+// verificationMessage is the mail carrying a verification token, as a link when a verification page is configured
+func (vs *emailVerificationService) verificationMessage(to, username, token string) mailer.Message {
+	action := tokenAction(vs.cfg.Mail.VerifyURL, token, "confirm it")
+	body := fmt.Sprintf("Hello %s,\n\n"+
+		"This address was given for your Book Order App account. If that was you, %s\n\n"+
+		"The token expires in %s and works once. If you do not know the account, ignore this mail.\n",
+		username, action, time.Duration(vs.cfg.Auth.EmailVerificationTTL))
+	return mailer.Message{To: to, Subject: "Confirm your email address for Book Order App", Body: body}
+}
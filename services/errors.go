@@ -0,0 +1,111 @@
+package services
+
+import (
+	"errors"
+	"time"
+
+	"book_order_app/middleware"
+	"book_order_app/repository"
+)
+
+// Kind classifies a domain error. The API layer turns it into an HTTP status.
+type Kind int
+
+const (
+	// KindInternal covers every error the services do not classify; its message is never shown to clients
+	KindInternal Kind = iota
+	KindValidation
+	KindUnauthorized
+	KindForbidden
+	KindNotFound
+	KindConflict
+	KindTooManyRequests
+	KindUnavailable
+)
+
+// FieldError explains why one field of a request was rejected
+type FieldError struct {
+	Field   string `json:"field" example:"password"`
+	Message string `json:"message" example:"must be at least 6 characters long"`
+}
+
+// Error is a domain error whose message is safe to show to clients
+type Error struct {
+	Kind    Kind
+	Message string
+	// Fields lists the rejected request fields of a validation error
+	Fields []FieldError
+	// Err is the underlying cause, if any
+	Err error
+	// RetryAfter tells clients when the request may succeed again, if that is known
+	RetryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// NewError returns a domain error of the given kind
+func NewError(kind Kind, message string) *Error {
+	return &Error{Kind: kind, Message: message}
+}
+
+// Invalid wraps err as a validation error, optionally naming the fields at fault
+func Invalid(err error, fields ...FieldError) *Error {
+	return &Error{Kind: KindValidation, Message: err.Error(), Fields: fields, Err: err}
+}
+
+// sentinelKinds classifies the errors that other layers return through the services
+var sentinelKinds = []struct {
+	err  error
+	kind Kind
+}{
+	{repository.ErrBookNotFound, KindNotFound},
+	{repository.ErrOrderNotFound, KindNotFound},
+	{repository.ErrUserNotFound, KindNotFound},
+	{repository.ErrBookHasOrders, KindConflict},
+	{repository.ErrInvalidTransition, KindConflict},
+	{repository.ErrInsufficientStock, KindConflict},
+	{repository.ErrUsernameTaken, KindConflict},
+	{repository.ErrEmailTaken, KindConflict},
+	{repository.ErrNotDeleted, KindConflict},
+	{repository.ErrLastAdmin, KindConflict},
+	{middleware.ErrAuthRequired, KindUnauthorized},
+	{middleware.ErrInvalidToken, KindUnauthorized},
+	{middleware.ErrTokenRevoked, KindUnauthorized},
+	{middleware.ErrAccountInactive, KindUnauthorized},
+	{middleware.ErrPermissionDenied, KindForbidden},
+	{middleware.ErrMFARequired, KindForbidden},
+	{middleware.ErrPasswordChangeRequired, KindForbidden},
+	{repository.ErrMFAEnabled, KindConflict},
+	{middleware.ErrTokenCheckFailed, KindUnavailable},
+	{repository.ErrAPIKeyNotFound, KindNotFound},
+	{middleware.ErrInvalidAPIKey, KindUnauthorized},
+	{middleware.ErrInsufficientScope, KindForbidden},
+	{middleware.ErrSessionRequired, KindForbidden},
+	{repository.ErrRoleNotFound, KindNotFound},
+	{repository.ErrRoleExists, KindConflict},
+	{repository.ErrRoleInUse, KindConflict},
+}
+
+// KindOf classifies err. Errors that are neither a domain Error, a known sentinel
+// nor a sign that the database is unreachable are internal.
+func KindOf(err error) Kind {
+	var domain *Error
+	if errors.As(err, &domain) {
+		return domain.Kind
+	}
+	for _, s := range sentinelKinds {
+		if errors.Is(err, s.err) {
+			return s.kind
+		}
+	}
+	if repository.IsUnavailable(err) {
+		return KindUnavailable
+	}
+	return KindInternal
+}
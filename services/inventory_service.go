@@ -0,0 +1,76 @@
+package services
+
+import (
+	"context"
+	"errors"
+
+	"book_order_app/models"
+	"book_order_app/query"
+	"book_order_app/repository"
+)
+
+var ErrInsufficientStock = repository.ErrInsufficientStock
+
+// StockMovementQuerySpec lists what the stock ledger listing may sort and filter on
+var StockMovementQuerySpec = query.Spec{
+	Sortable: map[string]query.Field{
+		"id":         {Column: "id", Kind: query.Number},
+		"created_at": {Column: "created_at", Kind: query.Time},
+	},
+	Filters: map[string]query.Filter{
+		"reason":   {Column: "reason", Kind: query.String, Op: query.Eq},
+		"order_id": {Column: "order_id", Kind: query.Number, Op: query.Eq},
+	},
+	DefaultSort: "-created_at",
+}
+
+type InventoryService interface {
+	AdjustStock(ctx context.Context, bookID uint, actorID uint, req models.AdjustStockRequest) (models.Book, error)
+	GetMovements(ctx context.Context, bookID uint, params query.Params) (query.Page[models.StockMovement], error)
+}
+
+type inventoryService struct {
+	books repository.BookRepository
+}
+
+func NewInventoryService(books repository.BookRepository) InventoryService {
+	return &inventoryService{books: books}
+}
+
+// AdjustStock applies a manual stock change and records it in the ledger with its reason code
+func (is *inventoryService) AdjustStock(ctx context.Context, bookID uint, actorID uint, req models.AdjustStockRequest) (models.Book, error) {
+	book, err := is.books.AdjustStock(ctx, models.StockMovement{
+		BookID:  bookID,
+		ActorID: &actorID,
+		Delta:   req.Delta,
+		Reason:  req.Reason,
+		Note:    req.Note,
+	})
+	if err != nil {
+		if !errors.Is(err, ErrBookNotFound) && !errors.Is(err, ErrInsufficientStock) {
+			logger.WithError(err).WithField("book_id", bookID).Error("Error adjusting stock")
+		}
+		return models.Book{}, err
+	}
+
+	logger.WithFields(map[string]interface{}{
+		"book_id":  bookID,
+		"actor_id": actorID,
+		"delta":    req.Delta,
+		"reason":   req.Reason,
+		"stock":    book.Stock,
+	}).Info("Successfully adjusted stock")
+	return book, nil
+}
+
+// GetMovements lists the stock ledger of a book
+func (is *inventoryService) GetMovements(ctx context.Context, bookID uint, params query.Params) (query.Page[models.StockMovement], error) {
+	page, err := is.books.ListMovements(ctx, bookID, params)
+	if err != nil {
+		if !errors.Is(err, ErrBookNotFound) {
+			logger.WithError(err).WithField("book_id", bookID).Error("Error fetching stock movements")
+		}
+		return query.Page[models.StockMovement]{}, err
+	}
+	return page, nil
+}
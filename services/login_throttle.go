@@ -0,0 +1,143 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"book_order_app/config"
+	"book_order_app/models"
+	"book_order_app/repository"
+
+	"github.com/sirupsen/logrus"
+)
+
+// securityLogger records authentication events under category=security, with an
+// event field to alert on, e.g. many login_failed events from one client_ip
+var securityLogger = userLogger.WithField("category", "security")
+
+// loginThrottle locks out usernames and client addresses after repeated failed
+// logins. Each failure past the limit doubles the lockout, up to auth.LoginLockoutMax.
+type loginThrottle struct {
+	throttles repository.LoginThrottleRepository
+	auth      config.AuthConfig
+}
+
+// throttleSubject is one thing failed logins are counted for
+type throttleSubject struct {
+	scope   models.LoginScope
+	subject string
+	limit   int
+}
+
+// subjects lists what an attempt counts against. Usernames are compared without
+// case so that variants of one name share a count.
+func (lt loginThrottle) subjects(username, clientIP string) []throttleSubject {
+	subjects := []throttleSubject{
+		{models.LoginScopeUsername, strings.ToLower(strings.TrimSpace(username)), lt.auth.LoginMaxFailures},
+	}
+	if clientIP != "" {
+		subjects = append(subjects, throttleSubject{models.LoginScopeIP, clientIP, lt.auth.LoginMaxFailuresPerIP})
+	}
+	return subjects
+}
+
+// check refuses an attempt while its username or client address is locked out.
+// Unknown usernames are throttled like existing ones, so a lockout reveals nothing.
+func (lt loginThrottle) check(ctx context.Context, username, clientIP string) error {
+	now := time.Now()
+	for _, s := range lt.subjects(username, clientIP) {
+		throttle, err := lt.throttles.GetLoginThrottle(ctx, s.scope, s.subject)
+		if err != nil {
+			userLogger.WithError(err).WithField("username", username).Error("Error checking login throttle")
+			return fmt.Errorf("failed to authenticate: %w", err)
+		}
+		if throttle.IsLocked(now) {
+			securityEvent("login_blocked", username, clientIP).
+				WithField("scope", s.scope).
+				Warn("Login attempt while locked out")
+			return lockedOut(throttle.LockedUntil.Sub(now))
+		}
+	}
+	return nil
+}
+
+// fail counts a failed attempt and returns the lockout error when it was one too
+// many. Storage errors are only logged: the attempt failed either way.
+func (lt loginThrottle) fail(ctx context.Context, username, clientIP, reason string) error {
+	securityEvent("login_failed", username, clientIP).WithField("reason", reason).Warn("Login failed")
+
+	var locked error
+	for _, s := range lt.subjects(username, clientIP) {
+		if s.limit == 0 {
+			continue
+		}
+		throttle, err := lt.throttles.RecordLoginFailure(ctx, s.scope, s.subject, time.Duration(lt.auth.LoginLockoutMax))
+		if err != nil {
+			userLogger.WithError(err).WithField("username", username).Error("Error recording failed login")
+			continue
+		}
+		if throttle.Failures < s.limit {
+			continue
+		}
+
+		lockout := lt.lockoutFor(throttle.Failures - s.limit)
+		if err := lt.throttles.LockLogin(ctx, s.scope, s.subject, time.Now().Add(lockout)); err != nil {
+			userLogger.WithError(err).WithField("username", username).Error("Error locking out login")
+			continue
+		}
+		securityEvent("login_locked", username, clientIP).WithFields(logrus.Fields{
+			"scope":    s.scope,
+			"failures": throttle.Failures,
+			"lockout":  lockout.String(),
+		}).Warn("Too many failed logins, locking out")
+		locked = lockedOut(lockout)
+	}
+	return locked
+}
+
+// succeed forgets the failed attempts of a username once its owner signed in.
+// The client address keeps its count, so one valid account does not reset it.
+func (lt loginThrottle) succeed(ctx context.Context, user *models.User, clientIP string) {
+	securityEvent("login_succeeded", user.Username, clientIP).
+		WithField("user_id", user.ID).
+		Info("User logged in successfully")
+	if err := lt.unlock(ctx, user.Username); err != nil {
+		userLogger.WithError(err).WithField("user_id", user.ID).Error("Error clearing failed logins")
+	}
+}
+
+// unlock lifts the lockout of a username and forgets its failed attempts
+func (lt loginThrottle) unlock(ctx context.Context, username string) error {
+	subject := lt.subjects(username, "")[0]
+	return lt.throttles.ClearLoginFailures(ctx, subject.scope, subject.subject)
+}
+
+// lockoutFor returns the lockout after excess failures beyond the limit:
+// auth.LoginLockout, doubled for each one, capped at auth.LoginLockoutMax
+func (lt loginThrottle) lockoutFor(excess int) time.Duration {
+	lockout, ceiling := time.Duration(lt.auth.LoginLockout), time.Duration(lt.auth.LoginLockoutMax)
+	for i := 0; i < excess && lockout < ceiling; i++ {
+		lockout *= 2
+	}
+	return min(lockout, ceiling)
+}
+
+func lockedOut(retryAfter time.Duration) error {
+	return &Error{
+		Kind:       KindTooManyRequests,
+		Message:    "too many failed logins, try again later",
+		RetryAfter: retryAfter,
+	}
+}
+
+// securityEvent starts a security log entry; clientIP is left out when empty, e.g.
+// for admin actions
+func securityEvent(event, username, clientIP string) *logrus.Entry {
+	fields := logrus.Fields{"event": event, "username": username}
+	if clientIP != "" {
+		fields["client_ip"] = clientIP
+	}
+	return securityLogger.WithFields(fields)
+}
@@ -0,0 +1,305 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"book_order_app/config"
+	"book_order_app/models"
+	"book_order_app/repository"
+	"book_order_app/totp"
+)
+
+// recoveryCodeCount is how many recovery codes a user holds at a time
+const recoveryCodeCount = 10
+
+var (
+	ErrInvalidMFACode = Invalid(errors.New("the code is not valid"),
+		FieldError{Field: "code", Message: "is wrong, expired or already used"})
+	ErrMFALoginFailed   = NewError(KindUnauthorized, "invalid code or expired sign-in; sign in with your password again")
+	ErrMFANotEnabled    = NewError(KindConflict, "two-factor authentication is not enabled")
+	ErrMFANotEnrolling  = NewError(KindConflict, "start the enrollment with POST /users/mfa/totp first")
+	ErrMFAAlreadyActive = repository.ErrMFAEnabled
+)
+
+type MFAService interface {
+	StartEnrollment(ctx context.Context, userID uint) (models.TOTPEnrollment, error)
+	Enable(ctx context.Context, userID uint, code string) (*models.User, []string, error)
+	Disable(ctx context.Context, userID uint, code string) (*models.User, error)
+	RegenerateRecoveryCodes(ctx context.Context, userID uint, code string) ([]string, error)
+	Reset(ctx context.Context, actorID uint, userID uint) (*models.User, error)
+	Challenge(ctx context.Context, user *models.User) (models.MFAChallengeResponse, error)
+	CompleteLogin(ctx context.Context, req models.MFALoginRequest, clientIP string) (*models.User, error)
+}
+
+type mfaService struct {
+	auth     config.AuthConfig
+	users    repository.UserRepository
+	tokens   repository.TokenRepository
+	throttle loginThrottle
+	grant    permissionGrant
+}
+
+func NewMFAService(cfg *config.Config, users repository.UserRepository, roles repository.RoleRepository, tokens repository.TokenRepository, throttles repository.LoginThrottleRepository) MFAService {
+	return &mfaService{
+		auth:     cfg.Auth,
+		users:    users,
+		tokens:   tokens,
+		throttle: loginThrottle{throttles: throttles, auth: cfg.Auth},
+		grant:    permissionGrant{users: users, roles: roles},
+	}
+}
+
+// StartEnrollment gives the user a new TOTP secret to add to an authenticator app.
+// MFA is not on until Enable confirms the app produces valid codes.
+func (ms *mfaService) StartEnrollment(ctx context.Context, userID uint) (models.TOTPEnrollment, error) {
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return models.TOTPEnrollment{}, err
+	}
+	user, err := ms.users.StartMFAEnrollment(ctx, userID, secret)
+	if err != nil {
+		if errors.Is(err, ErrUserNotFound) || errors.Is(err, ErrMFAAlreadyActive) {
+			return models.TOTPEnrollment{}, err
+		}
+		userLogger.WithError(err).WithField("user_id", userID).Error("Error starting MFA enrollment")
+		return models.TOTPEnrollment{}, fmt.Errorf("failed to start mfa enrollment: %w", err)
+	}
+	return models.TOTPEnrollment{
+		Secret:          secret,
+		ProvisioningURI: totp.ProvisioningURI(ms.auth.MFAIssuer, user.Username, secret),
+	}, nil
+}
+
+// Enable turns MFA on once code shows the authenticator is set up, and returns
+// the user's recovery codes. The caller revokes the sessions started without MFA.
+func (ms *mfaService) Enable(ctx context.Context, userID uint, code string) (*models.User, []string, error) {
+	user, err := ms.getUser(ctx, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if user.MFAEnabled() {
+		return nil, nil, ErrMFAAlreadyActive
+	}
+	if user.TOTPSecret == "" {
+		return nil, nil, ErrMFANotEnrolling
+	}
+	step, ok := totp.Validate(user.TOTPSecret, strings.TrimSpace(code), time.Now())
+	if !ok {
+		return nil, nil, ErrInvalidMFACode
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, nil, err
+	}
+	updated, err := ms.users.EnableMFA(ctx, userID, step, hashes)
+	if err != nil {
+		return nil, nil, logUnexpected(err, userID, "Error enabling MFA")
+	}
+	userLogger.WithField("user_id", userID).Info("Enabled two-factor authentication")
+	return &updated, codes, nil
+}
+
+// Disable turns MFA off after checking a current code. The caller revokes the
+// user's sessions, since they all carry the MFA claim.
+func (ms *mfaService) Disable(ctx context.Context, userID uint, code string) (*models.User, error) {
+	user, err := ms.getUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !user.MFAEnabled() {
+		return nil, ErrMFANotEnabled
+	}
+	if err := ms.verifyCode(ctx, user, code); err != nil {
+		return nil, err
+	}
+	return ms.disable(ctx, userID, "Disabled two-factor authentication")
+}
+
+// RegenerateRecoveryCodes replaces every recovery code after checking a current code
+func (ms *mfaService) RegenerateRecoveryCodes(ctx context.Context, userID uint, code string) ([]string, error) {
+	user, err := ms.getUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !user.MFAEnabled() {
+		return nil, ErrMFANotEnabled
+	}
+	if err := ms.verifyCode(ctx, user, code); err != nil {
+		return nil, err
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if err := ms.users.ReplaceRecoveryCodes(ctx, userID, hashes); err != nil {
+		return nil, logUnexpected(err, userID, "Error replacing recovery codes")
+	}
+	userLogger.WithField("user_id", userID).Info("Replaced recovery codes")
+	return codes, nil
+}
+
+// Reset turns MFA off on behalf of an admin, who must hold every permission of the
+// user's role, for users who lost both their authenticator and their recovery
+// codes. The caller revokes the user's sessions.
+func (ms *mfaService) Reset(ctx context.Context, actorID uint, userID uint) (*models.User, error) {
+	user, err := ms.getUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := ms.grant.checkUser(ctx, actorID, *user); err != nil {
+		return nil, err
+	}
+	if !user.MFAEnabled() && user.TOTPSecret == "" {
+		return nil, ErrMFANotEnabled
+	}
+	return ms.disable(ctx, userID, "Reset two-factor authentication")
+}
+
+// Challenge starts the second step of a login: the returned token, sent back with
+// a code, completes it
+func (ms *mfaService) Challenge(ctx context.Context, user *models.User) (models.MFAChallengeResponse, error) {
+	ttl := time.Duration(ms.auth.MFAChallengeTTL)
+	raw, err := issueOneTimeToken(ctx, ms.tokens, user.ID, models.PurposeMFAChallenge, ttl)
+	if err != nil {
+		userLogger.WithError(err).WithField("user_id", user.ID).Error("Error creating MFA challenge")
+		return models.MFAChallengeResponse{}, fmt.Errorf("failed to authenticate: %w", err)
+	}
+	return models.MFAChallengeResponse{MFARequired: true, MFAToken: raw, ExpiresIn: int(ttl.Seconds())}, nil
+}
+
+// CompleteLogin checks the code for a challenge from Challenge and returns the
+// user to start a session for. A challenge allows a single attempt, so guessing
+// codes means entering the password again each time, and wrong codes count
+// towards the login lockout like wrong passwords.
+func (ms *mfaService) CompleteLogin(ctx context.Context, req models.MFALoginRequest, clientIP string) (*models.User, error) {
+	token, err := ms.tokens.GetOneTimeToken(ctx, models.PurposeMFAChallenge, hashToken(req.MFAToken))
+	if err != nil {
+		if errors.Is(err, repository.ErrOneTimeTokenNotFound) {
+			return nil, ErrMFALoginFailed
+		}
+		userLogger.WithError(err).Error("Error finding MFA challenge")
+		return nil, fmt.Errorf("failed to authenticate: %w", err)
+	}
+	if token.UsedAt != nil || time.Now().After(token.ExpiresAt) {
+		return nil, ErrMFALoginFailed
+	}
+	if err := ms.tokens.UseOneTimeToken(ctx, token.ID); err != nil {
+		if errors.Is(err, repository.ErrOneTimeTokenUsed) {
+			return nil, ErrMFALoginFailed
+		}
+		userLogger.WithError(err).WithField("user_id", token.UserID).Error("Error using MFA challenge")
+		return nil, fmt.Errorf("failed to authenticate: %w", err)
+	}
+
+	user, err := ms.users.GetByID(ctx, token.UserID)
+	if err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			return nil, ErrMFALoginFailed
+		}
+		userLogger.WithError(err).WithField("user_id", token.UserID).Error("Error finding user for MFA login")
+		return nil, fmt.Errorf("failed to authenticate: %w", err)
+	}
+	if user.IsDisabled() {
+		return nil, ErrAccountDisabled
+	}
+	if !user.MFAEnabled() {
+		return nil, ErrMFALoginFailed
+	}
+	if err := ms.verifyCode(ctx, &user, req.Code); err != nil {
+		if errors.Is(err, ErrInvalidMFACode) {
+			if err := ms.throttle.fail(ctx, user.Username, clientIP, "wrong mfa code"); err != nil {
+				return nil, err
+			}
+			return nil, ErrMFALoginFailed
+		}
+		return nil, err
+	}
+
+	ms.throttle.succeed(ctx, &user, clientIP)
+	return &user, nil
+}
+
+// verifyCode accepts a TOTP code or an unused recovery code of user, each only once
+func (ms *mfaService) verifyCode(ctx context.Context, user *models.User, code string) error {
+	code = strings.TrimSpace(code)
+	var err error
+	if len(code) == totp.Digits {
+		step, ok := totp.Validate(user.TOTPSecret, code, time.Now())
+		if !ok {
+			return ErrInvalidMFACode
+		}
+		err = ms.users.UseTOTPStep(ctx, user.ID, step)
+	} else {
+		err = ms.users.UseRecoveryCode(ctx, user.ID, hashToken(normalizeRecoveryCode(code)))
+		if err == nil {
+			userLogger.WithField("user_id", user.ID).Warn("Recovery code used")
+		}
+	}
+	if errors.Is(err, repository.ErrMFACodeUsed) {
+		return ErrInvalidMFACode
+	}
+	if err != nil {
+		userLogger.WithError(err).WithField("user_id", user.ID).Error("Error checking MFA code")
+		return fmt.Errorf("failed to check code: %w", err)
+	}
+	return nil
+}
+
+func (ms *mfaService) disable(ctx context.Context, userID uint, msg string) (*models.User, error) {
+	updated, err := ms.users.DisableMFA(ctx, userID)
+	if err != nil {
+		return nil, logUnexpected(err, userID, "Error disabling MFA")
+	}
+	userLogger.WithField("user_id", userID).Info(msg)
+	return &updated, nil
+}
+
+func (ms *mfaService) getUser(ctx context.Context, userID uint) (*models.User, error) {
+	user, err := ms.users.GetByID(ctx, userID)
+	if err != nil {
+		return nil, logUnexpected(err, userID, "Error fetching user")
+	}
+	return &user, nil
+}
+
+// logUnexpected logs the failures of an MFA action on a user other than a missing user
+func logUnexpected(err error, id uint, msg string) error {
+	if errors.Is(err, ErrUserNotFound) {
+		return err
+	}
+	userLogger.WithError(err).WithField("user_id", id).Error(msg)
+	return err
+}
+
+var recoveryEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// newRecoveryCodes returns recovery codes like "k3x9m-q2w7p" (50 random bits
+// each) and the hashes to store
+func newRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 7)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		raw := recoveryEncoding.EncodeToString(b)[:10]
+		codes[i] = raw[:5] + "-" + raw[5:]
+		hashes[i] = hashToken(raw)
+	}
+	return codes, hashes, nil
+}
+
+// normalizeRecoveryCode drops the separator and case a user may type differently
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
@@ -0,0 +1,56 @@
+package services
+
+import (
+	"context"
+	"net/url"
+	"time"
+
+	"book_order_app/mailer"
+	"book_order_app/models"
+	"book_order_app/repository"
+)
+
+// issueOneTimeToken stores a new token for purpose, superseding the user's earlier
+// ones, and returns its raw value, which only the mail to the user carries
+func issueOneTimeToken(ctx context.Context, tokens repository.TokenRepository, userID uint, purpose models.TokenPurpose, ttl time.Duration) (string, error) {
+	raw, err := newRefreshToken()
+	if err != nil {
+		return "", err
+	}
+	token := models.OneTimeToken{
+		UserID:    userID,
+		Purpose:   purpose,
+		TokenHash: hashToken(raw),
+		ExpiresAt: time.Now().Add(ttl),
+	}
+	if err := tokens.CreateOneTimeToken(ctx, &token); err != nil {
+		return "", err
+	}
+	return raw, nil
+}
+
+// sendInBackground sends msg without holding up the request, so neither the mail
+// server's latency nor its failures reach the client. Failures are logged.
+func sendInBackground(ctx context.Context, mail mailer.Mailer, msg mailer.Message, userID uint, what string) {
+	go func(ctx context.Context) {
+		if err := mail.Send(ctx, msg); err != nil {
+			userLogger.WithError(err).WithField("user_id", userID).Error("Error sending " + what + " mail")
+			return
+		}
+		userLogger.WithField("user_id", userID).Info("Sent " + what + " mail")
+	}(context.WithoutCancel(ctx))
+}
+
+// tokenAction tells the reader of a mail what to do with token: open page with the
+// token appended as ?token=, or paste the token where no page is configured
+func tokenAction(page, token, goal string) string {
+	if page != "" {
+		if link, err := url.Parse(page); err == nil {
+			q := link.Query()
+			q.Set("token", token)
+			link.RawQuery = q.Encode()
+			return "open this link to " + goal + ":\n\n" + link.String()
+		}
+	}
+	return "use this token to " + goal + ":\n\n" + token
+}
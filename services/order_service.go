@@ -3,36 +3,155 @@ package services
 import (
 	"book_order_app/config"
 	"book_order_app/models"
+	"book_order_app/query"
+	"book_order_app/repository"
+	"context"
+	"errors"
 	"log"
 )
 
+var (
+	ErrOrderNotFound     = repository.ErrOrderNotFound
+	ErrInvalidTransition = repository.ErrInvalidTransition
+)
+
+// OrderQuerySpec lists what GET /orders may sort and filter on
+var OrderQuerySpec = query.Spec{
+	Sortable: map[string]query.Field{
+		"id":         {Column: "id", Kind: query.Number},
+		"total":      {Column: "total", Kind: query.Number},
+		"created_at": {Column: "created_at", Kind: query.Time},
+	},
+	Filters: map[string]query.Filter{
+		"book_id": {
+			Kind: query.Number,
+			Expr: "EXISTS (SELECT 1 FROM order_items WHERE order_items.order_id = orders.id AND order_items.book_id = ?)",
+		},
+		"min_total":      {Column: "total", Kind: query.Number, Op: query.Gte},
+		"max_total":      {Column: "total", Kind: query.Number, Op: query.Lte},
+		"status":         {Column: "status", Kind: query.String, Op: query.Eq},
+		"user_id":        {Column: "user_id", Kind: query.Number, Op: query.Eq},
+		"customer_name":  {Column: "customer_name", Kind: query.String, Op: query.Contains},
+		"created_after":  {Column: "created_at", Kind: query.Time, Op: query.Gt},
+		"created_before": {Column: "created_at", Kind: query.Time, Op: query.Lt},
+	},
+	DefaultSort: "-created_at",
+}
+
 type OrderService interface {
-	GetAll() []models.Order
-	Create(order models.Order) models.Order
+	GetAll(ctx context.Context, params query.Params, includeDeleted bool) (query.Page[models.Order], error)
+	GetByID(ctx context.Context, id uint) (models.Order, error)
+	Create(ctx context.Context, order models.Order) (models.Order, error)
+	Delete(ctx context.Context, id uint) error
+	Restore(ctx context.Context, id uint) (models.Order, error)
+	Transition(ctx context.Context, id uint, to models.OrderStatus, actorID uint, note string) (models.Order, error)
+	GetHistory(ctx context.Context, id uint) ([]models.OrderStatusChange, error)
 }
 
 type orderService struct {
-	dbHandler *config.DBHandler
+	orders repository.OrderRepository
+	users  repository.UserRepository
+	// requireVerifiedEmail keeps users from ordering until they verified their address
+	requireVerifiedEmail bool
 }
 
-func NewOrderService() OrderService {
-	dbHandler := config.InitializeDBHandler()
-	return &orderService{dbHandler: dbHandler}
+func NewOrderService(cfg *config.Config, orders repository.OrderRepository, users repository.UserRepository) OrderService {
+	return &orderService{orders: orders, users: users, requireVerifiedEmail: cfg.Auth.RequireVerifiedEmail}
 }
 
-func (os *orderService) GetAll() []models.Order {
-	var orders []models.Order
-	if err := os.dbHandler.DB.Find(&orders).Error; err != nil {
+func (os *orderService) GetAll(ctx context.Context, params query.Params, includeDeleted bool) (query.Page[models.Order], error) {
+	page, err := os.orders.List(ctx, params, includeDeleted)
+	if err != nil {
 		log.Printf("Error fetching orders: %v", err)
-		return []models.Order{}
+		return query.Page[models.Order]{}, err
+	}
+	return page, nil
+}
+
+// GetByID returns an order with its items
+func (os *orderService) GetByID(ctx context.Context, id uint) (models.Order, error) {
+	order, err := os.orders.Get(ctx, id)
+	if err != nil {
+		if !errors.Is(err, ErrOrderNotFound) {
+			log.Printf("Error fetching order %d: %v", id, err)
+		}
+		return models.Order{}, err
+	}
+	return order, nil
+}
+
+// Create prices every line from the current book prices, reserves stock and stores
+// the order with its items in a single transaction. ErrBookNotFound is returned when
+// any line references a missing book and ErrInsufficientStock when a line cannot be filled.
+// When verified email addresses are required, ErrEmailNotVerified is returned for
+// customers who have not verified theirs.
+func (os *orderService) Create(ctx context.Context, order models.Order) (models.Order, error) {
+	if order.UserID != nil && os.requireVerifiedEmail {
+		user, err := os.users.GetByID(ctx, *order.UserID)
+		if err != nil {
+			if !errors.Is(err, ErrUserNotFound) {
+				log.Printf("Error checking customer %d: %v", *order.UserID, err)
+			}
+			return models.Order{}, err
+		}
+		if !user.IsEmailVerified() {
+			return models.Order{}, ErrEmailNotVerified
+		}
+	}
+
+	if err := os.orders.Create(ctx, &order); err != nil {
+		if !errors.Is(err, ErrBookNotFound) && !errors.Is(err, ErrInsufficientStock) {
+			log.Printf("Error creating order: %v", err)
+		}
+		return models.Order{}, err
+	}
+	return order, nil
+}
+
+// Delete soft-deletes an order and releases the stock it still holds
+func (os *orderService) Delete(ctx context.Context, id uint) error {
+	err := os.orders.Delete(ctx, id)
+	if err != nil && !errors.Is(err, ErrOrderNotFound) {
+		log.Printf("Error deleting order %d: %v", id, err)
+	}
+	return err
+}
+
+// Restore brings a soft-deleted order back, reserving its stock again if its status holds stock
+func (os *orderService) Restore(ctx context.Context, id uint) (models.Order, error) {
+	order, err := os.orders.Restore(ctx, id)
+	if err != nil {
+		if !errors.Is(err, ErrOrderNotFound) && !errors.Is(err, ErrNotDeleted) &&
+			!errors.Is(err, ErrBookNotFound) && !errors.Is(err, ErrInsufficientStock) {
+			log.Printf("Error restoring order %d: %v", id, err)
+		}
+		return models.Order{}, err
+	}
+	return order, nil
+}
+
+// Transition moves an order to a new status when the lifecycle allows it, releasing
+// reserved stock when the order leaves a stock-holding status without shipping,
+// and records the change in the status history.
+func (os *orderService) Transition(ctx context.Context, id uint, to models.OrderStatus, actorID uint, note string) (models.Order, error) {
+	order, err := os.orders.Transition(ctx, id, to, actorID, note)
+	if err != nil {
+		if !errors.Is(err, ErrOrderNotFound) && !errors.Is(err, ErrInvalidTransition) {
+			log.Printf("Error changing status of order %d: %v", id, err)
+		}
+		return models.Order{}, err
 	}
-	return orders
+	return order, nil
 }
 
-func (os *orderService) Create(order models.Order) models.Order {
-	if err := os.dbHandler.DB.Create(&order).Error; err != nil {
-		log.Printf("Error creating order: %v", err)
-		return order
+// GetHistory returns the status changes of an order, oldest first
+func (os *orderService) GetHistory(ctx context.Context, id uint) ([]models.OrderStatusChange, error) {
+	history, err := os.orders.History(ctx, id)
+	if err != nil {
+		if !errors.Is(err, ErrOrderNotFound) {
+			log.Printf("Error fetching history of order %d: %v", id, err)
+		}
+		return nil, err
 	}
-	return order
+	return history, nil
 }
@@ -0,0 +1,34 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// MinPasswordLength is the shortest password any account may have
+	MinPasswordLength = 8
+	// MaxPasswordBytes is the most bcrypt looks at; longer passwords are rejected rather than silently truncated
+	MaxPasswordBytes = 72
+)
+
+// checkPassword applies the password policy to a password chosen for username,
+// reporting the violation against the named request field
+func checkPassword(field, password, username string) error {
+	var message string
+	switch {
+	case utf8.RuneCountInString(password) < MinPasswordLength:
+		message = fmt.Sprintf("must be at least %d characters long", MinPasswordLength)
+	case len(password) > MaxPasswordBytes:
+		message = fmt.Sprintf("must be at most %d bytes long", MaxPasswordBytes)
+	case strings.TrimSpace(password) == "":
+		message = "must not be blank"
+	case strings.EqualFold(password, username):
+		message = "must not be the username"
+	default:
+		return nil
+	}
+	return Invalid(errors.New("password does not meet the password policy"), FieldError{Field: field, Message: message})
+}
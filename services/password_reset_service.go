@@ -0,0 +1,123 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"book_order_app/config"
+	"book_order_app/mailer"
+	"book_order_app/models"
+	"book_order_app/repository"
+)
+
+var ErrInvalidResetToken = Invalid(errors.New("invalid or expired password reset token"),
+	FieldError{Field: "token", Message: "is invalid or expired"})
+
+type PasswordResetService interface {
+	Forgot(ctx context.Context, username string) error
+	Reset(ctx context.Context, req models.ResetPasswordRequest) (*models.User, error)
+}
+
+type passwordResetService struct {
+	cfg    *config.Config
+	tokens repository.TokenRepository
+	users  repository.UserRepository
+	mail   mailer.Mailer
+}
+
+func NewPasswordResetService(cfg *config.Config, tokens repository.TokenRepository, users repository.UserRepository, mail mailer.Mailer) PasswordResetService {
+	return &passwordResetService{cfg: cfg, tokens: tokens, users: users, mail: mail}
+}
+
+// Forgot mails a password reset link to the account's email address. Whether the
+// account exists, is disabled or has no address is never revealed: the result is
+// the same and the mail is sent in the background so the response time does not
+// tell either. Only a failing database is reported.
+func (ps *passwordResetService) Forgot(ctx context.Context, username string) error {
+	user, err := ps.users.GetByUsername(ctx, username)
+	if err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			userLogger.WithField("username", username).Warn("Password reset requested for an unknown user")
+			return nil
+		}
+		userLogger.WithError(err).WithField("username", username).Error("Error finding user for password reset")
+		return fmt.Errorf("failed to request password reset: %w", err)
+	}
+	if user.IsDisabled() || user.Email == nil {
+		userLogger.WithField("user_id", user.ID).Warn("Password reset requested for an account that cannot receive it")
+		return nil
+	}
+
+	raw, err := issueOneTimeToken(ctx, ps.tokens, user.ID, models.PurposePasswordReset, time.Duration(ps.cfg.Auth.PasswordResetTTL))
+	if err != nil {
+		userLogger.WithError(err).WithField("user_id", user.ID).Error("Error creating password reset token")
+		return fmt.Errorf("failed to request password reset: %w", err)
+	}
+	sendInBackground(ctx, ps.mail, ps.resetMessage(*user.Email, user.Username, raw), user.ID, "password reset")
+	return nil
+}
+
+// Reset sets a new password with a token from Forgot. The token is used up before
+// the password is stored, so each one works at most once. The caller revokes the
+// user's sessions.
+func (ps *passwordResetService) Reset(ctx context.Context, req models.ResetPasswordRequest) (*models.User, error) {
+	token, err := ps.tokens.GetOneTimeToken(ctx, models.PurposePasswordReset, hashToken(req.Token))
+	if err != nil {
+		if errors.Is(err, repository.ErrOneTimeTokenNotFound) {
+			return nil, ErrInvalidResetToken
+		}
+		userLogger.WithError(err).Error("Error finding password reset token")
+		return nil, fmt.Errorf("failed to reset password: %w", err)
+	}
+	if token.UsedAt != nil || time.Now().After(token.ExpiresAt) {
+		return nil, ErrInvalidResetToken
+	}
+
+	user, err := ps.users.GetByID(ctx, token.UserID)
+	if err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			return nil, ErrInvalidResetToken
+		}
+		userLogger.WithError(err).WithField("user_id", token.UserID).Error("Error finding user for password reset")
+		return nil, fmt.Errorf("failed to reset password: %w", err)
+	}
+	if user.IsDisabled() {
+		return nil, ErrInvalidResetToken
+	}
+	if err := checkPassword("new_password", req.NewPassword, user.Username); err != nil {
+		return nil, err
+	}
+
+	// Consuming the token first makes a concurrent second use fail
+	if err := ps.tokens.UseOneTimeToken(ctx, token.ID); err != nil {
+		if errors.Is(err, repository.ErrOneTimeTokenUsed) {
+			return nil, ErrInvalidResetToken
+		}
+		userLogger.WithError(err).WithField("user_id", user.ID).Error("Error using password reset token")
+		return nil, fmt.Errorf("failed to reset password: %w", err)
+	}
+	updated, err := ps.users.SetPassword(ctx, user.ID, req.NewPassword, false)
+	if err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			return nil, ErrInvalidResetToken
+		}
+		userLogger.WithError(err).WithField("user_id", user.ID).Error("Error resetting password")
+		return nil, fmt.Errorf("failed to reset password: %w", err)
+	}
+
+	userLogger.WithField("user_id", user.ID).Info("Successfully reset password with a reset token")
+	return &updated, nil
+}
+
+// resetMessage is the mail carrying a reset token, as a link when a reset page is configured
+func (ps *passwordResetService) resetMessage(to, username, token string) mailer.Message {
+	action := tokenAction(ps.cfg.Mail.ResetURL, token, "choose a new password")
+	body := fmt.Sprintf("Hello %s,\n\n"+
+		"Someone asked to reset the password of your Book Order App account. If it was you, %s\n\n"+
+		"The token expires in %s and works once. If you did not ask for a reset, ignore this mail; "+
+		"your password stays the same.\n",
+		username, action, time.Duration(ps.cfg.Auth.PasswordResetTTL))
+	return mailer.Message{To: to, Subject: "Reset your Book Order App password", Body: body}
+}
@@ -0,0 +1,67 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"book_order_app/config"
+	"book_order_app/repository"
+)
+
+// PurgeResult reports how many rows a purge run permanently removed
+type PurgeResult = repository.PurgeResult
+
+type PurgeService interface {
+	Purge(ctx context.Context, retention time.Duration) (PurgeResult, error)
+	Run(ctx context.Context, interval, retention time.Duration)
+}
+
+type purgeService struct {
+	purger repository.PurgeRepository
+	// forgetAfter is how long failed logins are remembered
+	forgetAfter time.Duration
+}
+
+func NewPurgeService(cfg *config.Config, purger repository.PurgeRepository) PurgeService {
+	return &purgeService{purger: purger, forgetAfter: time.Duration(cfg.Auth.LoginLockoutMax)}
+}
+
+// Purge permanently removes records that were soft-deleted more than retention ago,
+// along with expired tokens and failed logins that no longer count
+func (ps *purgeService) Purge(ctx context.Context, retention time.Duration) (PurgeResult, error) {
+	now := time.Now()
+	cutoff := now.Add(-retention)
+	// Failures are forgotten after LoginLockoutMax, by which any lockout has ended too
+	result, err := ps.purger.Purge(ctx, cutoff, now.Add(-ps.forgetAfter))
+	if err != nil {
+		logger.WithError(err).Error("Error purging soft-deleted records")
+		return PurgeResult{}, err
+	}
+
+	logger.WithFields(map[string]interface{}{
+		"orders":          result.Orders,
+		"books":           result.Books,
+		"users":           result.Users,
+		"tokens":          result.Tokens,
+		"login_throttles": result.LoginThrottles,
+		"cutoff":          cutoff,
+	}).Info("Purged soft-deleted records")
+	return result, nil
+}
+
+// Run purges once immediately and then on every interval tick until ctx is cancelled
+func (ps *purgeService) Run(ctx context.Context, interval, retention time.Duration) {
+	_, _ = ps.Purge(ctx, retention)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_, _ = ps.Purge(ctx, retention)
+		}
+	}
+}
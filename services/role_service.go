@@ -0,0 +1,240 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+
+	"book_order_app/middleware"
+	"book_order_app/models"
+	"book_order_app/repository"
+)
+
+var roleLogger = middleware.GetLogger()
+
+var (
+	ErrRoleNotFound         = repository.ErrRoleNotFound
+	ErrRoleExists           = repository.ErrRoleExists
+	ErrRoleInUse            = repository.ErrRoleInUse
+	ErrAdminRoleFixed       = NewError(KindConflict, "the admin role always grants every permission")
+	ErrBuiltInRole          = NewError(KindConflict, "built-in roles cannot be deleted")
+	ErrPermissionEscalation = NewError(KindForbidden, "you cannot grant permissions you do not hold")
+	ErrUserOutranksActor    = NewError(KindForbidden, "you cannot manage users whose role grants permissions you do not hold")
+)
+
+// roleNamePattern keeps role names short, lower case and safe in URLs
+var roleNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_-]*$`)
+
+type RoleService interface {
+	List(ctx context.Context) ([]models.Role, error)
+	Get(ctx context.Context, name models.UserRole) (models.Role, error)
+	Create(ctx context.Context, actorID uint, req models.CreateRoleRequest) (models.Role, error)
+	Update(ctx context.Context, actorID uint, name models.UserRole, req models.UpdateRoleRequest) (models.Role, error)
+	Delete(ctx context.Context, name models.UserRole) error
+	SeedBuiltIn(ctx context.Context) error
+	UserPermissions(ctx context.Context, userID uint) ([]string, error)
+}
+
+type roleService struct {
+	roles repository.RoleRepository
+	grant permissionGrant
+}
+
+func NewRoleService(roles repository.RoleRepository, users repository.UserRepository) RoleService {
+	return &roleService{roles: roles, grant: permissionGrant{users: users, roles: roles}}
+}
+
+func (rs *roleService) List(ctx context.Context) ([]models.Role, error) {
+	return rs.roles.ListRoles(ctx)
+}
+
+func (rs *roleService) Get(ctx context.Context, name models.UserRole) (models.Role, error) {
+	return rs.roles.GetRole(ctx, name)
+}
+
+// Create defines a role. Its permissions must all be held by the actor.
+func (rs *roleService) Create(ctx context.Context, actorID uint, req models.CreateRoleRequest) (models.Role, error) {
+	if !roleNamePattern.MatchString(string(req.Name)) {
+		return models.Role{}, Invalid(errors.New("invalid role"),
+			FieldError{Field: "name", Message: "must start with a lower-case letter and contain only lower-case letters, digits, '-' and '_'"})
+	}
+	if err := rs.grant.check(ctx, actorID, req.Permissions); err != nil {
+		return models.Role{}, err
+	}
+
+	role := models.Role{Name: req.Name, Description: req.Description, Permissions: permissionSet(req.Permissions)}
+	if err := rs.roles.CreateRole(ctx, &role); err != nil {
+		if errors.Is(err, ErrRoleExists) {
+			return models.Role{}, err
+		}
+		roleLogger.WithError(err).WithField("role", req.Name).Error("Error creating role")
+		return models.Role{}, fmt.Errorf("failed to create role: %w", err)
+	}
+	roleLogger.WithFields(map[string]interface{}{
+		"role":        role.Name,
+		"permissions": role.Permissions,
+		"actor_id":    actorID,
+	}).Info("Successfully created role")
+	return role, nil
+}
+
+// Update replaces the description and permissions of a role. The actor must hold
+// every permission the role grants before and after, so a role cannot be taken
+// away from or handed to others beyond the actor's own reach.
+func (rs *roleService) Update(ctx context.Context, actorID uint, name models.UserRole, req models.UpdateRoleRequest) (models.Role, error) {
+	if name == models.RoleAdmin {
+		return models.Role{}, ErrAdminRoleFixed
+	}
+	current, err := rs.roles.GetRole(ctx, name)
+	if err != nil {
+		return models.Role{}, err
+	}
+	if err := rs.grant.check(ctx, actorID, append(slices.Clone(current.Permissions), req.Permissions...)); err != nil {
+		return models.Role{}, err
+	}
+
+	role, err := rs.roles.UpdateRole(ctx, name, req.Description, permissionSet(req.Permissions))
+	if err != nil {
+		return models.Role{}, err
+	}
+	roleLogger.WithFields(map[string]interface{}{
+		"role":        role.Name,
+		"permissions": role.Permissions,
+		"actor_id":    actorID,
+	}).Info("Successfully updated role")
+	return role, nil
+}
+
+// Delete removes a role no user has. Built-in roles are kept.
+func (rs *roleService) Delete(ctx context.Context, name models.UserRole) error {
+	role, err := rs.roles.GetRole(ctx, name)
+	if err != nil {
+		return err
+	}
+	if role.BuiltIn {
+		return ErrBuiltInRole
+	}
+	if err := rs.roles.DeleteRole(ctx, name); err != nil {
+		return err
+	}
+	roleLogger.WithField("role", name).Info("Successfully deleted role")
+	return nil
+}
+
+// SeedBuiltIn creates the built-in roles that are missing and gives the admin role
+// every permission, including ones added since it was created. Roles that were
+// redefined are left alone, so it is safe to run on every start.
+func (rs *roleService) SeedBuiltIn(ctx context.Context) error {
+	for _, builtIn := range models.BuiltInRoles {
+		role := builtIn
+		role.BuiltIn = true
+		role.Permissions = slices.Clone(builtIn.Permissions)
+		err := rs.roles.CreateRole(ctx, &role)
+		if err == nil {
+			roleLogger.WithField("role", role.Name).Info("Created built-in role")
+			continue
+		}
+		if !errors.Is(err, ErrRoleExists) {
+			return fmt.Errorf("failed to create role %s: %w", role.Name, err)
+		}
+		if role.Name != models.RoleAdmin {
+			continue
+		}
+		current, err := rs.roles.GetRole(ctx, role.Name)
+		if err != nil {
+			return fmt.Errorf("failed to look up role %s: %w", role.Name, err)
+		}
+		if slices.Equal(current.Permissions, role.Permissions) {
+			continue
+		}
+		if _, err := rs.roles.UpdateRole(ctx, role.Name, current.Description, role.Permissions); err != nil {
+			return fmt.Errorf("failed to update role %s: %w", role.Name, err)
+		}
+	}
+	return nil
+}
+
+// UserPermissions returns the permissions of the user's current role, or
+// middleware.ErrAccountInactive when the user was disabled or deleted
+func (rs *roleService) UserPermissions(ctx context.Context, userID uint) ([]string, error) {
+	user, err := rs.grant.users.GetByID(ctx, userID)
+	if errors.Is(err, ErrUserNotFound) {
+		return nil, middleware.ErrAccountInactive
+	}
+	if err != nil {
+		return nil, err
+	}
+	if user.IsDisabled() {
+		return nil, middleware.ErrAccountInactive
+	}
+	return rolePermissions(ctx, rs.roles, user.Role)
+}
+
+// rolePermissions returns the permissions name grants; unknown roles grant none
+func rolePermissions(ctx context.Context, roles repository.RoleRepository, name models.UserRole) ([]string, error) {
+	if name == models.RoleAdmin {
+		return models.PermissionNames(), nil
+	}
+	role, err := roles.GetRole(ctx, name)
+	if errors.Is(err, ErrRoleNotFound) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return role.Permissions, nil
+}
+
+// permissionSet returns permissions without duplicates, never nil
+func permissionSet(permissions []string) []string {
+	set := []string{}
+	for _, p := range permissions {
+		if !slices.Contains(set, p) {
+			set = append(set, p)
+		}
+	}
+	return set
+}
+
+// permissionGrant keeps staff from handing out more than they hold themselves
+type permissionGrant struct {
+	users repository.UserRepository
+	roles repository.RoleRepository
+}
+
+// checkUser returns ErrUserOutranksActor when user's role grants permissions the
+// actor does not hold, so staff cannot take over an admin by resetting their
+// password or MFA, nor lock them out
+func (g permissionGrant) checkUser(ctx context.Context, actorID uint, user models.User) error {
+	held, err := rolePermissions(ctx, g.roles, user.Role)
+	if err != nil {
+		return fmt.Errorf("failed to look up role: %w", err)
+	}
+	if err := g.check(ctx, actorID, held); err != nil {
+		if errors.Is(err, ErrPermissionEscalation) {
+			return ErrUserOutranksActor
+		}
+		return err
+	}
+	return nil
+}
+
+// check returns ErrPermissionEscalation unless the actor's role grants every one of permissions
+func (g permissionGrant) check(ctx context.Context, actorID uint, permissions []string) error {
+	actor, err := g.users.GetByID(ctx, actorID)
+	if err != nil {
+		return fmt.Errorf("failed to look up actor: %w", err)
+	}
+	held, err := rolePermissions(ctx, g.roles, actor.Role)
+	if err != nil {
+		return fmt.Errorf("failed to look up actor permissions: %w", err)
+	}
+	for _, p := range permissions {
+		if !slices.Contains(held, p) {
+			return ErrPermissionEscalation
+		}
+	}
+	return nil
+}
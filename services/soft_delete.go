@@ -0,0 +1,8 @@
+package services
+
+import (
+	"book_order_app/repository"
+)
+
+// ErrNotDeleted is returned when restoring a record that is not in the trash
+var ErrNotDeleted = repository.ErrNotDeleted
@@ -0,0 +1,208 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"book_order_app/config"
+	"book_order_app/middleware"
+	"book_order_app/models"
+	"book_order_app/repository"
+)
+
+var (
+	ErrInvalidRefreshToken = NewError(KindUnauthorized, "invalid or expired refresh token")
+	ErrRefreshTokenReused  = NewError(KindUnauthorized, "refresh token reuse detected; session revoked")
+)
+
+type TokenService interface {
+	Issue(ctx context.Context, user *models.User) (models.AuthResponse, error)
+	Refresh(ctx context.Context, refreshToken string) (models.AuthResponse, error)
+	RevokeSession(ctx context.Context, sessionID string) error
+	RevokeUserSessions(ctx context.Context, userID uint) error
+	RevokeAccessToken(ctx context.Context, jti string) error
+	IsRevoked(ctx context.Context, ids ...string) (bool, error)
+}
+
+type tokenService struct {
+	access *middleware.AccessTokens
+	// refreshTTL is how long a refresh token can be exchanged for a new access token
+	refreshTTL time.Duration
+	tokens     repository.TokenRepository
+	users      repository.UserRepository
+}
+
+func NewTokenService(cfg *config.Config, access *middleware.AccessTokens, tokens repository.TokenRepository, users repository.UserRepository) TokenService {
+	return &tokenService{access: access, refreshTTL: time.Duration(cfg.Auth.RefreshTokenTTL), tokens: tokens, users: users}
+}
+
+// Issue starts a new session for user: an access token plus the first refresh token of a new family
+func (ts *tokenService) Issue(ctx context.Context, user *models.User) (models.AuthResponse, error) {
+	resp, token, err := ts.newTokens(user, middleware.NewTokenID())
+	if err == nil {
+		err = ts.tokens.CreateRefreshToken(ctx, &token)
+	}
+	if err != nil {
+		logger.WithError(err).WithField("user_id", user.ID).Error("Error issuing tokens")
+		return models.AuthResponse{}, err
+	}
+	return resp, nil
+}
+
+// Refresh rotates a refresh token: the presented token is revoked and replaced by a new one
+// in the same family. Presenting a token that was already rotated means it leaked, so the
+// whole family and every access token of the session are revoked.
+func (ts *tokenService) Refresh(ctx context.Context, refreshToken string) (models.AuthResponse, error) {
+	current, err := ts.tokens.GetRefreshToken(ctx, hashToken(refreshToken))
+	if err != nil {
+		if errors.Is(err, repository.ErrRefreshTokenNotFound) {
+			return models.AuthResponse{}, ErrInvalidRefreshToken
+		}
+		logger.WithError(err).Error("Error refreshing token")
+		return models.AuthResponse{}, err
+	}
+	if current.RevokedAt != nil {
+		return models.AuthResponse{}, ts.reused(ctx, current.FamilyID)
+	}
+	if time.Now().After(current.ExpiresAt) {
+		return models.AuthResponse{}, ErrInvalidRefreshToken
+	}
+
+	user, err := ts.users.GetByID(ctx, current.UserID)
+	if err != nil {
+		if errors.Is(err, repository.ErrUserNotFound) {
+			return models.AuthResponse{}, ErrInvalidRefreshToken
+		}
+		logger.WithError(err).Error("Error refreshing token")
+		return models.AuthResponse{}, err
+	}
+	if user.IsDisabled() {
+		return models.AuthResponse{}, ErrAccountDisabled
+	}
+
+	resp, next, err := ts.newTokens(&user, current.FamilyID)
+	if err == nil {
+		err = ts.tokens.ReplaceRefreshToken(ctx, current.ID, &next)
+	}
+	if err != nil {
+		// Losing a race against another refresh of the same token is a replay too
+		if errors.Is(err, repository.ErrRefreshTokenRevoked) {
+			return models.AuthResponse{}, ts.reused(ctx, current.FamilyID)
+		}
+		logger.WithError(err).Error("Error refreshing token")
+		return models.AuthResponse{}, err
+	}
+	return resp, nil
+}
+
+// RevokeSession revokes every refresh token of a session and denylists its access tokens
+func (ts *tokenService) RevokeSession(ctx context.Context, sessionID string) error {
+	err := ts.tokens.RevokeFamily(ctx, sessionID, ts.denyUntil())
+	if err != nil {
+		logger.WithError(err).WithField("family_id", sessionID).Error("Error revoking session")
+	}
+	return err
+}
+
+// RevokeUserSessions revokes every active session of a user
+func (ts *tokenService) RevokeUserSessions(ctx context.Context, userID uint) error {
+	err := ts.tokens.RevokeUserFamilies(ctx, userID, ts.denyUntil())
+	if err != nil {
+		logger.WithError(err).WithField("user_id", userID).Error("Error revoking user sessions")
+	}
+	return err
+}
+
+// RevokeAccessToken denylists a single access token id until it would have expired anyway
+func (ts *tokenService) RevokeAccessToken(ctx context.Context, jti string) error {
+	if err := ts.tokens.Denylist(ctx, jti, ts.denyUntil()); err != nil {
+		logger.WithError(err).WithField("jti", jti).Error("Error revoking access token")
+		return err
+	}
+	logger.WithField("jti", jti).Info("Access token revoked")
+	return nil
+}
+
+// IsRevoked reports whether any of the token or session ids is on the denylist
+func (ts *tokenService) IsRevoked(ctx context.Context, ids ...string) (bool, error) {
+	var present []string
+	for _, id := range ids {
+		if id != "" {
+			present = append(present, id)
+		}
+	}
+	if len(present) == 0 {
+		return false, nil
+	}
+
+	revoked, err := ts.tokens.IsDenylisted(ctx, present...)
+	if err != nil {
+		logger.WithError(err).Error("Error checking token denylist")
+		return false, err
+	}
+	return revoked, nil
+}
+
+// newTokens signs an access token for the session and prepares the next refresh token of its family
+func (ts *tokenService) newTokens(user *models.User, family string) (models.AuthResponse, models.RefreshToken, error) {
+	// Every session of an account with MFA went through the second factor: logins
+	// need it, and enabling MFA revokes the sessions started without it. Sessions
+	// started after an admin reset the password are limited until it is changed,
+	// which revokes them.
+	accessToken, err := ts.access.Generate(user.ID, user.Username, string(user.Role), family,
+		user.MFAEnabled(), user.PasswordResetRequired)
+	if err != nil {
+		return models.AuthResponse{}, models.RefreshToken{}, err
+	}
+
+	raw, err := newRefreshToken()
+	if err != nil {
+		return models.AuthResponse{}, models.RefreshToken{}, err
+	}
+	token := models.RefreshToken{
+		UserID:    user.ID,
+		FamilyID:  family,
+		TokenHash: hashToken(raw),
+		ExpiresAt: time.Now().Add(ts.refreshTTL),
+	}
+
+	return models.AuthResponse{
+		Token:        accessToken,
+		ExpiresIn:    int(ts.access.TTL().Seconds()),
+		RefreshToken: raw,
+		User:         *user,
+	}, token, nil
+}
+
+// reused revokes a family whose refresh token was replayed
+func (ts *tokenService) reused(ctx context.Context, family string) error {
+	if err := ts.tokens.RevokeFamily(ctx, family, ts.denyUntil()); err != nil {
+		logger.WithError(err).WithField("family_id", family).Error("Error revoking session")
+		return err
+	}
+	logger.WithField("family_id", family).Warn("Refresh token reuse detected, session revoked")
+	return ErrRefreshTokenReused
+}
+
+// denyUntil is how long a denylist entry must live: as long as an access token can
+func (ts *tokenService) denyUntil() time.Time {
+	return time.Now().Add(ts.access.TTL())
+}
+
+func newRefreshToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -4,104 +4,464 @@ import (
 	"book_order_app/config"
 	"book_order_app/middleware"
 	"book_order_app/models"
+	"book_order_app/query"
+	"book_order_app/repository"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
 	"errors"
-
-	"gorm.io/gorm"
+	"fmt"
+	"net/mail"
+	"strings"
 )
 
 var userLogger = middleware.GetLogger()
 
+var (
+	ErrUserNotFound       = repository.ErrUserNotFound
+	ErrUsernameTaken      = repository.ErrUsernameTaken
+	ErrInvalidCredentials = NewError(KindUnauthorized, "invalid username or password")
+	ErrAccountDisabled    = NewError(KindForbidden, "account is disabled")
+	ErrLastAdmin          = repository.ErrLastAdmin
+	ErrEmailTaken         = repository.ErrEmailTaken
+)
+
+// UserQuerySpec lists what the admin user listing may sort and filter on
+var UserQuerySpec = query.Spec{
+	Sortable: map[string]query.Field{
+		"id":         {Column: "id", Kind: query.Number},
+		"username":   {Column: "username", Kind: query.String},
+		"created_at": {Column: "created_at", Kind: query.Time},
+	},
+	Filters: map[string]query.Filter{
+		"username": {Column: "username", Kind: query.String, Op: query.Contains},
+		"role":     {Column: "role", Kind: query.String, Op: query.Eq},
+	},
+	DefaultSort: "id",
+}
+
 type UserService interface {
-	Register(req models.RegisterRequest) (*models.User, error)
-	Login(req models.LoginRequest) (*models.User, error)
-	GetByUsername(username string) (*models.User, error)
-	GetByID(id uint) (*models.User, error)
+	Register(ctx context.Context, req models.RegisterRequest) (*models.User, error)
+	Create(ctx context.Context, actorID uint, req models.CreateUserRequest) (*models.User, error)
+	BootstrapAdmin(ctx context.Context, username, password string) (*models.User, error)
+	Login(ctx context.Context, req models.LoginRequest, clientIP string) (*models.User, error)
+	Unlock(ctx context.Context, actorID uint, id uint) (*models.User, error)
+	GetByUsername(ctx context.Context, username string) (*models.User, error)
+	GetByID(ctx context.Context, id uint) (*models.User, error)
+	GetAll(ctx context.Context, params query.Params, includeDeleted bool) (query.Page[models.User], error)
+	Restore(ctx context.Context, actorID uint, id uint) (*models.User, error)
+	GetRoleHistory(ctx context.Context, id uint) ([]models.RoleChange, error)
+	ChangeRole(ctx context.Context, actorID uint, id uint, req models.ChangeRoleRequest) (*models.User, error)
+	SetDisabled(ctx context.Context, actorID uint, id uint, disabled bool) (*models.User, error)
+	ResetPassword(ctx context.Context, actorID uint, id uint) (models.PasswordResetResponse, error)
+	Delete(ctx context.Context, actorID uint, id uint) error
+	UpdateProfile(ctx context.Context, id uint, req models.UpdateProfileRequest) (*models.User, error)
+	ChangePassword(ctx context.Context, id uint, req models.ChangePasswordRequest) (*models.User, error)
 }
 
 type userService struct {
-	dbHandler *config.DBHandler
+	users    repository.UserRepository
+	roles    repository.RoleRepository
+	throttle loginThrottle
+	grant    permissionGrant
 }
 
-func NewUserService() UserService {
-	dbHandler := config.InitializeDBHandler()
-	return &userService{dbHandler: dbHandler}
+func NewUserService(cfg *config.Config, users repository.UserRepository, roles repository.RoleRepository, throttles repository.LoginThrottleRepository) UserService {
+	return &userService{
+		users:    users,
+		roles:    roles,
+		throttle: loginThrottle{throttles: throttles, auth: cfg.Auth},
+		grant:    permissionGrant{users: users, roles: roles},
+	}
 }
 
-// Register creates a new user
-func (us *userService) Register(req models.RegisterRequest) (*models.User, error) {
-	// Check if user already exists
-	var existingUser models.User
-	if err := us.dbHandler.DB.Where("username = ?", req.Username).First(&existingUser).Error; err == nil {
-		return nil, errors.New("username already exists")
+// Register creates a self-registered account, which always has the user role. The
+// caller sends the verification mail for its email address.
+func (us *userService) Register(ctx context.Context, req models.RegisterRequest) (*models.User, error) {
+	email, err := normalizeEmail(req.Email)
+	if err != nil {
+		return nil, err
 	}
+	user := models.User{Username: req.Username, Password: req.Password, Role: models.RoleUser, Email: email}
+	return us.create(ctx, user, nil, "self-registration")
+}
 
-	// Create new user
-	user := models.User{
-		Username: req.Username,
-		Password: req.Password, // Will be hashed by BeforeCreate hook
-		Role:     req.Role,
+// Create creates an account with the requested role on behalf of an admin, who
+// must hold every permission of that role
+func (us *userService) Create(ctx context.Context, actorID uint, req models.CreateUserRequest) (*models.User, error) {
+	if err := us.checkGrant(ctx, actorID, req.Role); err != nil {
+		return nil, err
 	}
+	user := models.User{Username: req.Username, Password: req.Password, Role: req.Role}
+	return us.create(ctx, user, &actorID, "created by an admin")
+}
 
-	if err := us.dbHandler.DB.Create(&user).Error; err != nil {
-		userLogger.WithError(err).WithField("username", req.Username).Error("Error creating user")
-		return nil, errors.New("failed to create user")
+// BootstrapAdmin creates the first admin account. Once any admin exists it does
+// nothing and returns a nil user, so it is safe to run on every start.
+func (us *userService) BootstrapAdmin(ctx context.Context, username, password string) (*models.User, error) {
+	hasAdmin, err := us.users.HasRole(ctx, models.RoleAdmin)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up admins: %w", err)
 	}
+	if hasAdmin {
+		return nil, nil
+	}
+	user := models.User{Username: username, Password: password, Role: models.RoleAdmin}
+	return us.create(ctx, user, nil, "bootstrap")
+}
 
-	userLogger.WithFields(map[string]interface{}{
+// create stores user and records its role as granted by actorID
+func (us *userService) create(ctx context.Context, user models.User, actorID *uint, note string) (*models.User, error) {
+	if err := checkPassword("password", user.Password, user.Username); err != nil {
+		return nil, err
+	}
+
+	// Soft-deleted users still hold their username. The password is hashed by the repository.
+	if err := us.users.Create(ctx, &user, actorID, note); err != nil {
+		if errors.Is(err, ErrUsernameTaken) || errors.Is(err, ErrEmailTaken) {
+			return nil, err
+		}
+		userLogger.WithError(err).WithField("username", user.Username).Error("Error creating user")
+		return nil, fmt.Errorf("failed to create user: %w", err)
+	}
+
+	fields := map[string]interface{}{
 		"user_id":  user.ID,
 		"username": user.Username,
 		"role":     user.Role,
-	}).Info("Successfully registered user")
+		"note":     note,
+	}
+	if actorID != nil {
+		fields["actor_id"] = *actorID
+	}
+	userLogger.WithFields(fields).Info("Successfully created user")
 	return &user, nil
 }
 
-// Login authenticates a user
-func (us *userService) Login(req models.LoginRequest) (*models.User, error) {
-	var user models.User
-	if err := us.dbHandler.DB.Where("username = ?", req.Username).First(&user).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			userLogger.WithField("username", req.Username).Warn("Login attempt with invalid username")
-			return nil, errors.New("invalid username or password")
+// Login authenticates a user. Failed attempts are counted per username and per
+// client address, and either is locked out for a while after too many.
+func (us *userService) Login(ctx context.Context, req models.LoginRequest, clientIP string) (*models.User, error) {
+	if err := us.throttle.check(ctx, req.Username, clientIP); err != nil {
+		return nil, err
+	}
+
+	user, err := us.users.GetByUsername(ctx, req.Username)
+	if err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			return nil, us.loginFailed(ctx, req.Username, clientIP, "unknown username")
 		}
 		userLogger.WithError(err).WithField("username", req.Username).Error("Error finding user during login")
-		return nil, errors.New("failed to authenticate")
+		return nil, fmt.Errorf("failed to authenticate: %w", err)
 	}
 
 	// Check password
 	if err := user.CheckPassword(req.Password); err != nil {
-		userLogger.WithField("username", req.Username).Warn("Login attempt with invalid password")
-		return nil, errors.New("invalid username or password")
+		return nil, us.loginFailed(ctx, req.Username, clientIP, "wrong password")
+	}
+	// Only reveal that an account is disabled to someone who knows its password
+	if user.IsDisabled() {
+		securityEvent("login_disabled", req.Username, clientIP).Warn("Login attempt on a disabled account")
+		return nil, ErrAccountDisabled
 	}
 
-	userLogger.WithFields(map[string]interface{}{
-		"user_id":  user.ID,
-		"username": user.Username,
-	}).Info("User logged in successfully")
+	// Users with MFA have only passed the first step
+	if user.MFAEnabled() {
+		securityEvent("login_password_accepted", user.Username, clientIP).
+			WithField("user_id", user.ID).
+			Info("Password accepted, waiting for the second factor")
+		return &user, nil
+	}
+	us.throttle.succeed(ctx, &user, clientIP)
+	return &user, nil
+}
+
+// loginFailed counts a failed login, returning the lockout it caused if any
+func (us *userService) loginFailed(ctx context.Context, username, clientIP, reason string) error {
+	if err := us.throttle.fail(ctx, username, clientIP, reason); err != nil {
+		return err
+	}
+	return ErrInvalidCredentials
+}
+
+// Unlock lifts a lockout caused by failed logins of a user and forgets them
+func (us *userService) Unlock(ctx context.Context, actorID uint, id uint) (*models.User, error) {
+	user, err := us.managedUser(ctx, actorID, id, "Error finding user to unlock")
+	if err != nil {
+		return nil, err
+	}
+	if err := us.throttle.unlock(ctx, user.Username); err != nil {
+		userLogger.WithError(err).WithField("user_id", id).Error("Error unlocking user")
+		return nil, fmt.Errorf("failed to unlock user: %w", err)
+	}
+	securityEvent("login_unlocked", user.Username, "").WithField("user_id", id).Info("Unlocked logins of user")
 	return &user, nil
 }
 
 // GetByUsername retrieves a user by username
-func (us *userService) GetByUsername(username string) (*models.User, error) {
-	var user models.User
-	if err := us.dbHandler.DB.Where("username = ?", username).First(&user).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return nil, errors.New("user not found")
+func (us *userService) GetByUsername(ctx context.Context, username string) (*models.User, error) {
+	user, err := us.users.GetByUsername(ctx, username)
+	if err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			return nil, err
 		}
 		userLogger.WithError(err).WithField("username", username).Error("Error finding user by username")
-		return nil, errors.New("failed to retrieve user")
+		return nil, fmt.Errorf("failed to retrieve user: %w", err)
 	}
 	return &user, nil
 }
 
 // GetByID retrieves a user by ID
-func (us *userService) GetByID(id uint) (*models.User, error) {
-	var user models.User
-	if err := us.dbHandler.DB.First(&user, id).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return nil, errors.New("user not found")
+func (us *userService) GetByID(ctx context.Context, id uint) (*models.User, error) {
+	user, err := us.users.GetByID(ctx, id)
+	if err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			return nil, err
 		}
 		userLogger.WithError(err).WithField("user_id", id).Error("Error finding user by ID")
-		return nil, errors.New("failed to retrieve user")
+		return nil, fmt.Errorf("failed to retrieve user: %w", err)
+	}
+	return &user, nil
+}
+
+// GetAll lists users, optionally including soft-deleted ones
+func (us *userService) GetAll(ctx context.Context, params query.Params, includeDeleted bool) (query.Page[models.User], error) {
+	page, err := us.users.List(ctx, params, includeDeleted)
+	if err != nil {
+		userLogger.WithError(err).Error("Error fetching users")
+		return query.Page[models.User]{}, fmt.Errorf("failed to retrieve users: %w", err)
+	}
+	return page, nil
+}
+
+// Restore brings a soft-deleted user back
+func (us *userService) Restore(ctx context.Context, actorID uint, id uint) (*models.User, error) {
+	deleted, err := us.users.GetByIDWithDeleted(ctx, id)
+	if err != nil {
+		return nil, us.manageError(err, id, "Error finding user to restore")
+	}
+	if err := us.grant.checkUser(ctx, actorID, deleted); err != nil {
+		return nil, err
+	}
+	user, err := us.users.Restore(ctx, id)
+	if err != nil {
+		if errors.Is(err, ErrUserNotFound) || errors.Is(err, ErrNotDeleted) {
+			return nil, err
+		}
+		userLogger.WithError(err).WithField("user_id", id).Error("Error restoring user")
+		return nil, fmt.Errorf("failed to restore user: %w", err)
 	}
+
+	userLogger.WithField("user_id", id).Info("Successfully restored user")
+	return &user, nil
+}
+
+// GetRoleHistory returns the role changes of a user, oldest first
+func (us *userService) GetRoleHistory(ctx context.Context, id uint) ([]models.RoleChange, error) {
+	history, err := us.users.RoleHistory(ctx, id)
+	if err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			return nil, err
+		}
+		userLogger.WithError(err).WithField("user_id", id).Error("Error fetching role history")
+		return nil, fmt.Errorf("failed to retrieve role history: %w", err)
+	}
+	return history, nil
+}
+
+// ChangeRole gives a user another role on behalf of an admin and records the change
+func (us *userService) ChangeRole(ctx context.Context, actorID uint, id uint, req models.ChangeRoleRequest) (*models.User, error) {
+	current, err := us.users.GetByID(ctx, id)
+	if err != nil {
+		return nil, us.manageError(err, id, "Error changing role")
+	}
+	if err := us.checkGrant(ctx, actorID, req.Role, current.Role); err != nil {
+		return nil, err
+	}
+	user, err := us.users.SetRole(ctx, id, req.Role, actorID, req.Note)
+	if err != nil {
+		return nil, us.manageError(err, id, "Error changing role")
+	}
+	userLogger.WithFields(map[string]interface{}{
+		"user_id":  id,
+		"role":     user.Role,
+		"actor_id": actorID,
+	}).Info("Successfully changed user role")
 	return &user, nil
 }
+
+// checkGrant rejects a requested role that is not defined, as a validation error on
+// the role field, and one that grants permissions the actor does not hold. The
+// actor must also hold every permission of the previous roles being taken away.
+func (us *userService) checkGrant(ctx context.Context, actorID uint, requested models.UserRole, previous ...models.UserRole) error {
+	role, err := us.roles.GetRole(ctx, requested)
+	if errors.Is(err, ErrRoleNotFound) {
+		return Invalid(errors.New("invalid role"), FieldError{Field: "role", Message: "is not a defined role"})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to look up role: %w", err)
+	}
+	permissions := role.Permissions
+	for _, name := range previous {
+		held, err := rolePermissions(ctx, us.roles, name)
+		if err != nil {
+			return fmt.Errorf("failed to look up role: %w", err)
+		}
+		permissions = append(permissions, held...)
+	}
+	return us.grant.check(ctx, actorID, permissions)
+}
+
+// SetDisabled disables or re-enables an account. Disabled accounts cannot sign in.
+func (us *userService) SetDisabled(ctx context.Context, actorID uint, id uint, disabled bool) (*models.User, error) {
+	if _, err := us.managedUser(ctx, actorID, id, "Error changing account status"); err != nil {
+		return nil, err
+	}
+	user, err := us.users.SetDisabled(ctx, id, disabled)
+	if err != nil {
+		return nil, us.manageError(err, id, "Error changing account status")
+	}
+	userLogger.WithFields(map[string]interface{}{
+		"user_id":  id,
+		"disabled": disabled,
+	}).Info("Successfully changed account status")
+	return &user, nil
+}
+
+// ResetPassword replaces a user's password with a random temporary one, which is
+// returned only this once, and flags the account so the user chooses a new password
+func (us *userService) ResetPassword(ctx context.Context, actorID uint, id uint) (models.PasswordResetResponse, error) {
+	if _, err := us.managedUser(ctx, actorID, id, "Error resetting password"); err != nil {
+		return models.PasswordResetResponse{}, err
+	}
+	password, err := temporaryPassword()
+	if err != nil {
+		return models.PasswordResetResponse{}, err
+	}
+	user, err := us.users.SetPassword(ctx, id, password, true)
+	if err != nil {
+		return models.PasswordResetResponse{}, us.manageError(err, id, "Error resetting password")
+	}
+	userLogger.WithField("user_id", id).Info("Successfully reset password")
+	return models.PasswordResetResponse{TemporaryPassword: password, User: user}, nil
+}
+
+// Delete moves a user to the trash, from which Restore can bring it back
+func (us *userService) Delete(ctx context.Context, actorID uint, id uint) error {
+	if _, err := us.managedUser(ctx, actorID, id, "Error deleting user"); err != nil {
+		return err
+	}
+	if err := us.users.Delete(ctx, id); err != nil {
+		return us.manageError(err, id, "Error deleting user")
+	}
+	userLogger.WithField("user_id", id).Info("Successfully deleted user")
+	return nil
+}
+
+// managedUser returns the user an admin action is about to change, as long as the
+// actor holds every permission of the user's role
+func (us *userService) managedUser(ctx context.Context, actorID uint, id uint, msg string) (models.User, error) {
+	user, err := us.users.GetByID(ctx, id)
+	if err != nil {
+		return models.User{}, us.manageError(err, id, msg)
+	}
+	if err := us.grant.checkUser(ctx, actorID, user); err != nil {
+		return models.User{}, err
+	}
+	return user, nil
+}
+
+// manageError logs the unexpected failures of an admin action on a user
+func (us *userService) manageError(err error, id uint, msg string) error {
+	if errors.Is(err, ErrUserNotFound) || errors.Is(err, ErrLastAdmin) {
+		return err
+	}
+	userLogger.WithError(err).WithField("user_id", id).Error(msg)
+	return err
+}
+
+// temporaryPassword returns 16 random URL-safe characters
+func temporaryPassword() (string, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// UpdateProfile applies a merge patch to the fields users edit themselves. Email
+// addresses are stored in lower case; an empty one removes the address and a new
+// one has to be verified again, for which the caller sends the mail.
+func (us *userService) UpdateProfile(ctx context.Context, id uint, req models.UpdateProfileRequest) (*models.User, error) {
+	user, err := us.users.GetByID(ctx, id)
+	if err != nil {
+		return nil, us.manageError(err, id, "Error fetching user")
+	}
+
+	if req.DisplayName != nil {
+		user.DisplayName = strings.TrimSpace(*req.DisplayName)
+	}
+	if req.Email != nil {
+		email, err := normalizeEmail(*req.Email)
+		if err != nil {
+			return nil, err
+		}
+		if email == nil || user.Email == nil || *email != *user.Email {
+			user.EmailVerifiedAt = nil
+		}
+		user.Email = email
+	}
+	if req.ShippingAddress != nil {
+		user.ShippingAddress = strings.TrimSpace(*req.ShippingAddress)
+	}
+
+	if err := us.users.UpdateProfile(ctx, &user); err != nil {
+		if errors.Is(err, ErrEmailTaken) {
+			return nil, err
+		}
+		return nil, us.manageError(err, id, "Error updating profile")
+	}
+	userLogger.WithField("user_id", id).Info("Successfully updated profile")
+	return &user, nil
+}
+
+// ChangePassword replaces a user's password after checking the current one. The
+// caller revokes the user's sessions.
+func (us *userService) ChangePassword(ctx context.Context, id uint, req models.ChangePasswordRequest) (*models.User, error) {
+	user, err := us.users.GetByID(ctx, id)
+	if err != nil {
+		return nil, us.manageError(err, id, "Error fetching user")
+	}
+
+	if err := user.CheckPassword(req.CurrentPassword); err != nil {
+		userLogger.WithField("user_id", id).Warn("Password change with a wrong current password")
+		return nil, Invalid(errors.New("current password is incorrect"), FieldError{Field: "current_password", Message: "is incorrect"})
+	}
+	if err := checkPassword("new_password", req.NewPassword, user.Username); err != nil {
+		return nil, err
+	}
+	if req.NewPassword == req.CurrentPassword {
+		return nil, Invalid(errors.New("password does not meet the password policy"),
+			FieldError{Field: "new_password", Message: "must differ from the current password"})
+	}
+
+	// The repository hashes the password; BeforeCreate only runs on insert
+	updated, err := us.users.SetPassword(ctx, id, req.NewPassword, false)
+	if err != nil {
+		return nil, us.manageError(err, id, "Error changing password")
+	}
+	userLogger.WithField("user_id", id).Info("Successfully changed password")
+	return &updated, nil
+}
+
+// normalizeEmail lower-cases a bare email address; an empty one becomes nil
+func normalizeEmail(raw string) (*string, error) {
+	email := strings.ToLower(strings.TrimSpace(raw))
+	if email == "" {
+		return nil, nil
+	}
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return nil, Invalid(errors.New("email address is not valid"), FieldError{Field: "email", Message: "must be a valid email address"})
+	}
+	return &email, nil
+}